@@ -21,14 +21,129 @@ type (
 		Node
 		stmt()
 	}
+	// Pattern is the target of a destructuring let: an identifier, or an
+	// array or hash pattern.
+	Pattern interface {
+		Node
+		pattern()
+	}
 	Program struct {
 		token.Token
 		Statements []Statement
+		Resolved   bool
 	}
+	// let x = ...; or, when destructuring, let [a, b] = ...; in which case
+	// Pattern is set and Lhs is nil. const x = ... is the same, except that
+	// its bindings cannot be bound again.
 	LetStatement struct {
 		token.Token
-		Lhs *Identifier
-		Rhs Expression
+		Lhs     *Identifier
+		Pattern Pattern
+		Rhs     Expression
+		Const   bool
+	}
+	// [a, b = 2, ...rest]
+	ArrayPattern struct {
+		token.Token
+		Elems []PatternElement
+		Rest  *Identifier
+	}
+	// {"name": n, "age": a = 0}
+	HashPattern struct {
+		token.Token
+		Pairs []HashPatternPair
+	}
+	// _ matches anything and binds nothing
+	WildcardPattern struct {
+		token.Token
+	}
+	// LiteralPattern matches values equal to a number, string or boolean
+	LiteralPattern struct {
+		token.Token
+		Value Expression
+	}
+	// match value { pattern if guard => body, ... }
+	MatchExpression struct {
+		token.Token
+		Value Expression
+		Arms  []MatchArm
+	}
+	// MatchArm is an arm of a match expression. Guard is optional.
+	MatchArm struct {
+		Pattern Pattern
+		Guard   Expression
+		Body    Expression
+		// Locals is the number of slots of the scope of the arm, set by the
+		// resolver
+		Locals int
+	}
+	// PatternElement is an element of an array or hash pattern, with the
+	// value it takes if the element is missing, if any.
+	PatternElement struct {
+		Target  Pattern
+		Default Expression
+	}
+	HashPatternPair struct {
+		Key Expression
+		PatternElement
+	}
+	// fn name(params) { ... }
+	// A declaration binds Name to Fn before any statement of the enclosing
+	// block runs, so declared functions may be called before they appear.
+	FunctionDeclaration struct {
+		token.Token
+		Name *Identifier
+		Fn   *FunctionLiteral
+	}
+	// struct Name { field, ..., fn method(params) { ... } }
+	// Declares Name as the constructor of instances with the given fields.
+	// Methods are called on an instance, which they refer to as self.
+	StructDeclaration struct {
+		token.Token
+		Name    *Identifier
+		Fields  []*Identifier
+		Methods []*FunctionDeclaration
+	}
+	// enum Name { Variant(field, ...), Variant, ... }
+	// Declares Name, and binds every variant by its name: a variant with
+	// fields to its constructor, and a variant without to its only value.
+	EnumDeclaration struct {
+		token.Token
+		Name     *Identifier
+		Variants []*EnumVariant
+	}
+	EnumVariant struct {
+		Name   *Identifier
+		Fields []*Identifier
+	}
+	// Variant(patterns...) or Enum.Variant(patterns...) matches the values
+	// of a variant whose fields match the patterns. The parentheses can be
+	// left off a variant without fields if it is qualified by the enum; the
+	// resolver turns a pattern of just the name of a variant into a
+	// VariantPattern as well.
+	VariantPattern struct {
+		token.Token
+		Enum   *Identifier // nil if not qualified
+		Name   *Identifier
+		Fields []Pattern
+	}
+	// Value.Field, the field or method of a struct instance, or an export of
+	// a module. Value?.Field is Optional, and null if Value is.
+	FieldExpression struct {
+		token.Token // the . or ?.
+		Value       Expression
+		Field       *Identifier
+		Optional    bool
+	}
+	// export let x = ...; or export fn f() { ... }
+	ExportStatement struct {
+		token.Token
+		Stmt Statement // *LetStatement or *FunctionDeclaration
+	}
+	// import "path", which evaluates to the module at path
+	ImportExpression struct {
+		token.Token
+		Path *String
 	}
 	AssignExpression struct {
 		token.Token
@@ -42,6 +157,15 @@ type (
 	Identifier struct {
 		token.Token
 		Value string
+		// Binding is set by the resolver for variables that live in a
+		// function frame. It is nil for globals and builtins, which are
+		// looked up by name.
+		Binding *Binding
+	}
+	// Binding locates a variable: Slot in the frame of the function that is
+	// Depth functions out from the use.
+	Binding struct {
+		Depth, Slot int
 	}
 	Number struct {
 		token.Token
@@ -75,27 +199,111 @@ type (
 		Then, Else *BlockStatement
 	}
 	FunctionLiteral struct {
+		token.Token
+		Name   string // set for declared functions; empty if anonymous
+		Params []Identifier
+		// Defaults holds the default value of each parameter, or nil for
+		// parameters without one. It is nil if no parameter has a default.
+		Defaults []Expression
+		// Rest collects the arguments that are left over, if set: ...rest
+		Rest *Identifier
+		// Receiver is the implicit self parameter of a method, bound to the
+		// instance the method is called on. It is nil for other functions.
+		Receiver *Identifier
+		// Generator is set for fn*, whose calls return an iterator over
+		// the values its body yields.
+		Generator bool
+		Body      *BlockStatement
+		// Resolved is set once the resolver has assigned slots to the
+		// parameters and locals; Locals is the size of the frame.
+		Resolved bool
+		Locals   int
+	}
+	// macro(params) { ... }, which is evaluated by the macro expansion
+	// phase: its parameters are bound to the quoted arguments of a call, and
+	// its body returns the quoted code that replaces the call.
+	MacroLiteral struct {
 		token.Token
 		Params []Identifier
 		Body   *BlockStatement
 	}
+	// Function(Params...), or Function?.(Params...) which is Optional: it
+	// is null, and the arguments are not evaluated, if Function is null.
 	CallExpression struct {
 		token.Token
 		Function Expression // identifier or FunctionLiteral
 		Params   []Expression
+		Optional bool
+	}
+	// ...Value, which spreads an array into the elements of an array
+	// literal or the arguments of a call.
+	Spread struct {
+		token.Token
+		Value Expression
+	}
+	// Name: Value, an argument passed by parameter name.
+	KeywordArgument struct {
+		token.Token
+		Name  *Identifier
+		Value Expression
 	}
 	ReturnExpression struct {
 		token.Token
 		Value Expression
 	}
+	ThrowExpression struct {
+		token.Token
+		Value Expression
+	}
+	// try { ... } catch (e) { ... } finally { ... }
+	// Catch and Finally are optional, but not both. Param is optional even
+	// when there is a catch block.
+	TryExpression struct {
+		token.Token
+		Block   *BlockStatement
+		Param   *Identifier
+		Catch   *BlockStatement
+		Finally *BlockStatement
+		// Locals is the number of slots of the scope of the catch block,
+		// set by the resolver
+		Locals int
+	}
 	Array struct {
 		token.Token
 		Elems []Expression
 	}
+	// Array[Index], or Array?.[Index] which is Optional: it is null, and
+	// Index is not evaluated, if Array is null.
+	// [Value for x in xs if cond], an array comprehension. Clauses are
+	// nested in order: each for clause runs for every value of the ones
+	// before it, and an if clause skips the values it is false for.
+	ArrayComprehension struct {
+		token.Token // the [
+		Value       Expression
+		Clauses     []ComprehensionClause
+	}
+	// {Key: Value for k, v in h}, a hash comprehension
+	HashComprehension struct {
+		token.Token // the {
+		Key, Value  Expression
+		Clauses     []ComprehensionClause
+	}
+	// for Target in Iterable, or if Cond, a clause of a comprehension.
+	// for k, v in ... is parsed as the pattern [k, v].
+	ComprehensionClause struct {
+		token.Token // for or if
+		Target      Pattern
+		Iterable    Expression
+		Cond        Expression
+		// Locals is the number of slots of the scope of a for clause, set
+		// by the resolver
+		Locals int
+	}
 	ArrayIndex struct {
 		token.Token
-		Array Expression // ident or array or Hash
-		Index Expression // anything, but should evaluate to a number
+		Array    Expression // ident or array or Hash
+		Index    Expression // anything, but should evaluate to a number
+		Optional bool
 	}
 	// {"foo": "bar", true: false, 1: 3}
 	HashLiteral struct {
@@ -118,17 +326,162 @@ func (n *LetStatement) String() string {
 	if n == nil {
 		return "<LetStatement:nil>"
 	}
-	return fmt.Sprintf("let %s = %s", n.Lhs, n.Rhs)
+	kw := "let"
+	if n.Const {
+		kw = "const"
+	}
+	if n.Pattern != nil {
+		return fmt.Sprintf("%s %s = %s", kw, n.Pattern, n.Rhs)
+	}
+	return fmt.Sprintf("%s %s = %s", kw, n.Lhs, n.Rhs)
+}
+
+func (n *ArrayPattern) TokenLiteral() string { return n.Token.Literal }
+func (n *ArrayPattern) pattern()             {}
+func (n *ArrayPattern) String() string {
+	var elems []string
+	for _, e := range n.Elems {
+		elems = append(elems, e.String())
+	}
+	if n.Rest != nil {
+		elems = append(elems, "..."+n.Rest.String())
+	}
+	return fmt.Sprintf("[%s]", strings.Join(elems, ", "))
+}
+
+func (n *HashPattern) TokenLiteral() string { return n.Token.Literal }
+func (n *HashPattern) pattern()             {}
+func (n *HashPattern) String() string {
+	var pairs []string
+	for _, p := range n.Pairs {
+		pairs = append(pairs, fmt.Sprintf("%s: %s", p.Key, p.PatternElement.String()))
+	}
+	return fmt.Sprintf("{%s}", strings.Join(pairs, ", "))
+}
+
+func (n *WildcardPattern) TokenLiteral() string { return n.Token.Literal }
+func (n *WildcardPattern) pattern()             {}
+func (n *WildcardPattern) String() string       { return "_" }
+
+func (n *LiteralPattern) TokenLiteral() string { return n.Token.Literal }
+func (n *LiteralPattern) pattern()             {}
+func (n *LiteralPattern) String() string       { return n.Value.String() }
+
+func (n *MatchExpression) TokenLiteral() string { return n.Token.Literal }
+func (n *MatchExpression) expr()                {}
+func (n *MatchExpression) String() string {
+	var arms []string
+	for _, a := range n.Arms {
+		arms = append(arms, a.String())
+	}
+	return fmt.Sprintf("match %s {%s}", n.Value, strings.Join(arms, ", "))
+}
+
+func (a MatchArm) String() string {
+	if a.Guard != nil {
+		return fmt.Sprintf("%s if %s => %s", a.Pattern, a.Guard, a.Body)
+	}
+	return fmt.Sprintf("%s => %s", a.Pattern, a.Body)
+}
+
+func (e PatternElement) String() string {
+	if e.Default != nil {
+		return fmt.Sprintf("%s = %s", e.Target, e.Default)
+	}
+	return e.Target.String()
 }
 func (n *LetStatement) TokenLiteral() string { return n.Token.Literal }
 func (n *LetStatement) stmt()                {}
 
+func (n *FunctionDeclaration) String() string       { return n.Fn.String() }
+func (n *FunctionDeclaration) TokenLiteral() string { return n.Token.Literal }
+func (n *FunctionDeclaration) stmt()                {}
+
+func (n *ExportStatement) String() string       { return "export " + n.Stmt.String() }
+func (n *ExportStatement) TokenLiteral() string { return n.Token.Literal }
+func (n *ExportStatement) stmt()                {}
+
+func (n *ImportExpression) String() string       { return "import " + n.Path.String() }
+func (n *ImportExpression) TokenLiteral() string { return n.Token.Literal }
+func (n *ImportExpression) expr()                {}
+
+// Declaration returns the function declared by stmt, which may be exported,
+// or nil if stmt is not a function declaration.
+func Declaration(stmt Statement) *FunctionDeclaration {
+	if e, ok := stmt.(*ExportStatement); ok {
+		stmt = e.Stmt
+	}
+	decl, _ := stmt.(*FunctionDeclaration)
+	return decl
+}
+
+func (n *StructDeclaration) TokenLiteral() string { return n.Token.Literal }
+func (n *StructDeclaration) stmt()                {}
+func (n *StructDeclaration) String() string {
+	var members []string
+	for _, f := range n.Fields {
+		members = append(members, f.String())
+	}
+	for _, m := range n.Methods {
+		members = append(members, m.String())
+	}
+	return fmt.Sprintf("struct %s {%s}", n.Name, strings.Join(members, ", "))
+}
+
+func (n *EnumDeclaration) TokenLiteral() string { return n.Token.Literal }
+func (n *EnumDeclaration) stmt()                {}
+func (n *EnumDeclaration) String() string {
+	var variants []string
+	for _, v := range n.Variants {
+		variants = append(variants, v.String())
+	}
+	return fmt.Sprintf("enum %s {%s}", n.Name, strings.Join(variants, ", "))
+}
+
+func (v *EnumVariant) String() string {
+	if len(v.Fields) == 0 {
+		return v.Name.String()
+	}
+	var fields []string
+	for _, f := range v.Fields {
+		fields = append(fields, f.String())
+	}
+	return fmt.Sprintf("%s(%s)", v.Name, strings.Join(fields, ", "))
+}
+
+func (n *VariantPattern) TokenLiteral() string { return n.Token.Literal }
+func (n *VariantPattern) pattern()             {}
+func (n *VariantPattern) String() string {
+	name := n.Name.String()
+	if n.Enum != nil {
+		name = n.Enum.String() + "." + name
+	}
+	if n.Fields == nil {
+		return name
+	}
+	var fields []string
+	for _, f := range n.Fields {
+		fields = append(fields, f.String())
+	}
+	return fmt.Sprintf("%s(%s)", name, strings.Join(fields, ", "))
+}
+
+func (n *FieldExpression) TokenLiteral() string { return n.Token.Literal }
+func (n *FieldExpression) expr()                {}
+func (n *FieldExpression) String() string {
+	if n.Optional {
+		return fmt.Sprintf("%s?.%s", n.Value, n.Field)
+	}
+	return fmt.Sprintf("%s.%s", n.Value, n.Field)
+}
+
 func (n *ExpressionStatement) TokenLiteral() string { return n.Token.Literal }
 func (n *ExpressionStatement) stmt()                {}
 func (n *ExpressionStatement) String() string       { return fmt.Sprintf("%s", n.Expr) }
 
 func (n *Identifier) TokenLiteral() string { return n.Token.Literal }
 func (n *Identifier) expr()                {}
+func (n *Identifier) pattern()             {}
 func (n *Identifier) String() string {
 	if n == nil {
 		return "<Identifier:nil>"
@@ -188,13 +541,45 @@ func (n *IfExpression) String() string {
 func (n *FunctionLiteral) TokenLiteral() string { return n.Token.Literal }
 func (n *FunctionLiteral) expr()                {}
 func (n *FunctionLiteral) String() string {
+	var params []string
+	for i, p := range n.Params {
+		if n.Defaults != nil && n.Defaults[i] != nil {
+			params = append(params, fmt.Sprintf("%s = %s", p.String(), n.Defaults[i]))
+			continue
+		}
+		params = append(params, p.String())
+	}
+	if n.Rest != nil {
+		params = append(params, "..."+n.Rest.String())
+	}
+	fn := "fn"
+	if n.Generator {
+		fn = "fn*"
+	}
+	if n.Name != "" {
+		return fmt.Sprintf("%s %s(%s) %s", fn, n.Name, strings.Join(params, ", "), n.Body.String())
+	}
+	return fmt.Sprintf("%s(%s) %s", fn, strings.Join(params, ", "), n.Body.String())
+}
+
+func (n *MacroLiteral) TokenLiteral() string { return n.Token.Literal }
+func (n *MacroLiteral) expr()                {}
+func (n *MacroLiteral) String() string {
 	var params []string
 	for _, p := range n.Params {
 		params = append(params, p.String())
 	}
-	return fmt.Sprintf("fn(%s) %s", strings.Join(params, ", "), n.Body.String())
+	return fmt.Sprintf("macro(%s) %s", strings.Join(params, ", "), n.Body.String())
 }
 
+func (n *Spread) TokenLiteral() string { return n.Token.Literal }
+func (n *Spread) expr()                {}
+func (n *Spread) String() string       { return fmt.Sprintf("...%s", n.Value) }
+
+func (n *KeywordArgument) TokenLiteral() string { return n.Token.Literal }
+func (n *KeywordArgument) expr()                {}
+func (n *KeywordArgument) String() string       { return fmt.Sprintf("%s: %s", n.Name, n.Value) }
+
 func (n *CallExpression) TokenLiteral() string { return n.Token.Literal }
 func (n *CallExpression) expr()                {}
 func (n *CallExpression) String() string {
@@ -205,6 +590,9 @@ func (n *CallExpression) String() string {
 	for _, p := range n.Params {
 		params = append(params, p.String())
 	}
+	if n.Optional {
+		return fmt.Sprintf("%s?.(%s)", n.Function, params)
+	}
 	return fmt.Sprintf("%s(%s)", n.Function, params)
 }
 
@@ -231,9 +619,36 @@ func (a *ArrayIndex) String() string {
 	if a == nil || a.Array == nil || a.Index == nil {
 		return "<ArrayIndex:nil>"
 	}
+	if a.Optional {
+		return fmt.Sprintf("%s?.[%s]", a.Array.String(), a.Index.String())
+	}
 	return fmt.Sprintf("%s[%s]", a.Array.String(), a.Index.String())
 }
 
+func (n *ArrayComprehension) TokenLiteral() string { return n.Token.Literal }
+func (n *ArrayComprehension) expr()                {}
+func (n *ArrayComprehension) String() string {
+	return fmt.Sprintf("[%s%s]", n.Value, clauses(n.Clauses))
+}
+
+func (n *HashComprehension) TokenLiteral() string { return n.Token.Literal }
+func (n *HashComprehension) expr()                {}
+func (n *HashComprehension) String() string {
+	return fmt.Sprintf("{%s: %s%s}", n.Key, n.Value, clauses(n.Clauses))
+}
+
+func clauses(cs []ComprehensionClause) string {
+	var out string
+	for _, c := range cs {
+		if c.Cond != nil {
+			out += fmt.Sprintf(" if %s", c.Cond)
+		} else {
+			out += fmt.Sprintf(" for %s in %s", c.Target, c.Iterable)
+		}
+	}
+	return out
+}
+
 func (h *HashLiteral) TokenLiteral() string { return h.Token.Literal }
 func (h *HashLiteral) expr()                {}
 func (h *HashLiteral) String() string {
@@ -255,3 +670,25 @@ func (ae *AssignExpression) String() string {
 	}
 	return fmt.Sprintf("%s=%s", ae.Lhs, ae.Rhs)
 }
+
+func (n *ThrowExpression) TokenLiteral() string { return n.Token.Literal }
+func (n *ThrowExpression) expr()                {}
+func (n *ThrowExpression) String() string       { return fmt.Sprintf("throw %s", n.Value) }
+
+func (n *TryExpression) TokenLiteral() string { return n.Token.Literal }
+func (n *TryExpression) expr()                {}
+func (n *TryExpression) String() string {
+	w := &bytes.Buffer{}
+	fmt.Fprintf(w, "try %s", n.Block)
+	if n.Catch != nil {
+		fmt.Fprint(w, " catch ")
+		if n.Param != nil {
+			fmt.Fprintf(w, "(%s) ", n.Param)
+		}
+		fmt.Fprint(w, n.Catch)
+	}
+	if n.Finally != nil {
+		fmt.Fprintf(w, " finally %s", n.Finally)
+	}
+	return w.String()
+}
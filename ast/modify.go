@@ -0,0 +1,311 @@
+package ast
+
+// Modify returns a copy of node rewritten by f. f is called on each node
+// before its children, and returns the node to put in its place and whether
+// to rewrite the children of that node as well. A node whose children are
+// rewritten is copied first, so node itself is never changed and the same
+// tree can be rewritten any number of times; this is what lets a macro
+// expand its template once for every call.
+//
+// Fields that hold a particular kind of node, such as the body of a
+// function, keep their value if f replaces it with a node of another kind.
+func Modify(node Node, f func(Node) (Node, bool)) Node {
+	node, descend := f(node)
+	if !descend {
+		return node
+	}
+	expr := func(e Expression) Expression {
+		if e == nil {
+			return nil
+		}
+		if out, ok := Modify(e, f).(Expression); ok {
+			return out
+		}
+		return e
+	}
+	block := func(b *BlockStatement) *BlockStatement {
+		if b == nil {
+			return nil
+		}
+		if out, ok := Modify(b, f).(*BlockStatement); ok {
+			return out
+		}
+		return b
+	}
+	ident := func(id *Identifier) *Identifier {
+		if id == nil {
+			return nil
+		}
+		if out, ok := Modify(id, f).(*Identifier); ok {
+			return out
+		}
+		return id
+	}
+	pattern := func(p Pattern) Pattern {
+		if p == nil {
+			return nil
+		}
+		if out, ok := Modify(p, f).(Pattern); ok {
+			return out
+		}
+		return p
+	}
+	element := func(e PatternElement) PatternElement {
+		return PatternElement{Target: pattern(e.Target), Default: expr(e.Default)}
+	}
+	stmts := func(in []Statement) []Statement {
+		var out []Statement
+		for _, s := range in {
+			if m, ok := Modify(s, f).(Statement); ok {
+				s = m
+			}
+			out = append(out, s)
+		}
+		return out
+	}
+	clauses := func(in []ComprehensionClause) []ComprehensionClause {
+		out := make([]ComprehensionClause, len(in))
+		for i, c := range in {
+			out[i] = c
+			out[i].Target = pattern(c.Target)
+			out[i].Iterable = expr(c.Iterable)
+			out[i].Cond = expr(c.Cond)
+		}
+		return out
+	}
+	exprs := func(in []Expression) []Expression {
+		if in == nil {
+			return nil
+		}
+		out := make([]Expression, len(in))
+		for i, e := range in {
+			out[i] = expr(e)
+		}
+		return out
+	}
+
+	switch n := node.(type) {
+	case *Program:
+		cp := *n
+		cp.Statements = stmts(n.Statements)
+		return &cp
+	case *LetStatement:
+		cp := *n
+		cp.Lhs = ident(n.Lhs)
+		cp.Pattern = pattern(n.Pattern)
+		cp.Rhs = expr(n.Rhs)
+		return &cp
+	case *ArrayPattern:
+		cp := *n
+		cp.Elems = nil
+		for _, e := range n.Elems {
+			cp.Elems = append(cp.Elems, element(e))
+		}
+		cp.Rest = ident(n.Rest)
+		return &cp
+	case *HashPattern:
+		cp := *n
+		cp.Pairs = nil
+		for _, pair := range n.Pairs {
+			cp.Pairs = append(cp.Pairs, HashPatternPair{Key: expr(pair.Key), PatternElement: element(pair.PatternElement)})
+		}
+		return &cp
+	case *WildcardPattern:
+		cp := *n
+		return &cp
+	case *LiteralPattern:
+		cp := *n
+		cp.Value = expr(n.Value)
+		return &cp
+	case *MatchExpression:
+		cp := *n
+		cp.Value = expr(n.Value)
+		cp.Arms = nil
+		for _, arm := range n.Arms {
+			arm.Pattern = pattern(arm.Pattern)
+			arm.Guard = expr(arm.Guard)
+			arm.Body = expr(arm.Body)
+			cp.Arms = append(cp.Arms, arm)
+		}
+		return &cp
+	case *FunctionDeclaration:
+		cp := *n
+		cp.Name = ident(n.Name)
+		if fn, ok := Modify(n.Fn, f).(*FunctionLiteral); ok {
+			cp.Fn = fn
+		}
+		return &cp
+	case *StructDeclaration:
+		cp := *n
+		cp.Name = ident(n.Name)
+		cp.Fields = nil
+		for _, field := range n.Fields {
+			cp.Fields = append(cp.Fields, ident(field))
+		}
+		cp.Methods = nil
+		for _, m := range n.Methods {
+			if out, ok := Modify(m, f).(*FunctionDeclaration); ok {
+				m = out
+			}
+			cp.Methods = append(cp.Methods, m)
+		}
+		return &cp
+	case *EnumDeclaration:
+		cp := *n
+		cp.Name = ident(n.Name)
+		cp.Variants = nil
+		for _, v := range n.Variants {
+			variant := &EnumVariant{Name: ident(v.Name)}
+			for _, field := range v.Fields {
+				variant.Fields = append(variant.Fields, ident(field))
+			}
+			cp.Variants = append(cp.Variants, variant)
+		}
+		return &cp
+	case *VariantPattern:
+		cp := *n
+		cp.Enum = ident(n.Enum)
+		cp.Name = ident(n.Name)
+		if n.Fields != nil {
+			cp.Fields = make([]Pattern, len(n.Fields))
+			for i, field := range n.Fields {
+				cp.Fields[i] = pattern(field)
+			}
+		}
+		return &cp
+	case *FieldExpression:
+		cp := *n
+		cp.Value = expr(n.Value)
+		// the field is a name on the value rather than a variable, so f is
+		// not called on it
+		return &cp
+	case *ExportStatement:
+		cp := *n
+		if s, ok := Modify(n.Stmt, f).(Statement); ok {
+			cp.Stmt = s
+		}
+		return &cp
+	case *ImportExpression:
+		cp := *n
+		if s, ok := Modify(n.Path, f).(*String); ok {
+			cp.Path = s
+		}
+		return &cp
+	case *AssignExpression:
+		cp := *n
+		cp.Lhs = expr(n.Lhs)
+		cp.Rhs = expr(n.Rhs)
+		return &cp
+	case *ExpressionStatement:
+		cp := *n
+		cp.Expr = expr(n.Expr)
+		return &cp
+	case *Identifier:
+		cp := *n
+		return &cp
+	case *Number:
+		cp := *n
+		return &cp
+	case *Boolean:
+		cp := *n
+		return &cp
+	case *String:
+		cp := *n
+		return &cp
+	case *PrefixExpression:
+		cp := *n
+		cp.Rhs = expr(n.Rhs)
+		return &cp
+	case *InfixExpression:
+		cp := *n
+		cp.Lhs = expr(n.Lhs)
+		cp.Rhs = expr(n.Rhs)
+		return &cp
+	case *BlockStatement:
+		cp := *n
+		cp.Statements = stmts(n.Statements)
+		return &cp
+	case *IfExpression:
+		cp := *n
+		cp.Cond = expr(n.Cond)
+		cp.Then = block(n.Then)
+		cp.Else = block(n.Else)
+		return &cp
+	case *FunctionLiteral:
+		cp := *n
+		cp.Params = make([]Identifier, len(n.Params))
+		for i := range n.Params {
+			cp.Params[i] = *ident(&n.Params[i])
+		}
+		cp.Defaults = exprs(n.Defaults)
+		cp.Rest = ident(n.Rest)
+		cp.Receiver = ident(n.Receiver)
+		cp.Body = block(n.Body)
+		return &cp
+	case *MacroLiteral:
+		cp := *n
+		cp.Params = make([]Identifier, len(n.Params))
+		for i := range n.Params {
+			cp.Params[i] = *ident(&n.Params[i])
+		}
+		cp.Body = block(n.Body)
+		return &cp
+	case *CallExpression:
+		cp := *n
+		cp.Function = expr(n.Function)
+		cp.Params = exprs(n.Params)
+		return &cp
+	case *Spread:
+		cp := *n
+		cp.Value = expr(n.Value)
+		return &cp
+	case *KeywordArgument:
+		cp := *n
+		cp.Name = ident(n.Name)
+		cp.Value = expr(n.Value)
+		return &cp
+	case *ReturnExpression:
+		cp := *n
+		cp.Value = expr(n.Value)
+		return &cp
+	case *ThrowExpression:
+		cp := *n
+		cp.Value = expr(n.Value)
+		return &cp
+	case *TryExpression:
+		cp := *n
+		cp.Block = block(n.Block)
+		cp.Param = ident(n.Param)
+		cp.Catch = block(n.Catch)
+		cp.Finally = block(n.Finally)
+		return &cp
+	case *Array:
+		cp := *n
+		cp.Elems = exprs(n.Elems)
+		return &cp
+	case *ArrayIndex:
+		cp := *n
+		cp.Array = expr(n.Array)
+		cp.Index = expr(n.Index)
+		return &cp
+	case *ArrayComprehension:
+		cp := *n
+		cp.Value = expr(n.Value)
+		cp.Clauses = clauses(n.Clauses)
+		return &cp
+	case *HashComprehension:
+		cp := *n
+		cp.Key = expr(n.Key)
+		cp.Value = expr(n.Value)
+		cp.Clauses = clauses(n.Clauses)
+		return &cp
+	case *HashLiteral:
+		cp := *n
+		cp.Pairs = make(map[Expression]Expression, len(n.Pairs))
+		for k, v := range n.Pairs {
+			cp.Pairs[expr(k)] = expr(v)
+		}
+		return &cp
+	}
+	return node
+}
@@ -0,0 +1,532 @@
+package compiler
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/kvalv/monkey/ast"
+	"github.com/kvalv/monkey/eval"
+	"github.com/kvalv/monkey/object"
+	"github.com/kvalv/monkey/resolver"
+)
+
+// Bytecode is the output of the compiler: the instructions of the main
+// program and the constants they refer to. The program leaves its value on
+// the stack and ends with OpReturnValue.
+type Bytecode struct {
+	Instructions Instructions
+	Constants    []object.Object
+}
+
+type compilationScope struct {
+	instructions Instructions
+}
+
+type Compiler struct {
+	constants []object.Object
+	symbols   *SymbolTable
+	scopes    []compilationScope
+	// unset holds the locals of hoisted functions whose closures have not
+	// been created yet; closures that capture them get them filled in later.
+	unset map[Symbol]bool
+	// err is set by emit when an operand does not fit in its instruction
+	err error
+}
+
+// New returns a compiler whose global scope knows about the builtins, in the
+// order given by eval.BuiltinNames.
+func New() *Compiler {
+	symbols := NewSymbolTable()
+	for i, name := range eval.BuiltinNames() {
+		symbols.DefineBuiltin(i, name)
+	}
+	return NewWithState(symbols, nil)
+}
+
+// NewWithState returns a compiler that continues from an earlier one, which is
+// how a REPL keeps its globals between lines.
+func NewWithState(symbols *SymbolTable, constants []object.Object) *Compiler {
+	return &Compiler{
+		constants: constants,
+		symbols:   symbols,
+		scopes:    []compilationScope{{}},
+		unset:     make(map[Symbol]bool),
+	}
+}
+
+func (c *Compiler) SymbolTable() *SymbolTable { return c.symbols }
+
+func (c *Compiler) Bytecode() *Bytecode {
+	return &Bytecode{Instructions: c.currentInstructions(), Constants: c.constants}
+}
+
+// Compile compiles a program, or any other node into the current scope.
+func (c *Compiler) Compile(node ast.Node) error {
+	if err := c.compile(node); err != nil {
+		return err
+	}
+	return c.err
+}
+
+func (c *Compiler) compile(node ast.Node) error {
+	switch n := node.(type) {
+	case *ast.Program:
+		// report name errors the same way as the evaluator does
+		globals := func(name string) resolver.Global {
+			if _, ok := c.symbols.Resolve(name); ok {
+				return resolver.Defined
+			}
+			return resolver.Undefined
+		}
+		n, errs := resolver.Resolve(n, globals)
+		if len(errs) > 0 {
+			return errors.Join(errs...)
+		}
+		if err := c.compileStatements(n.Statements, true); err != nil {
+			return err
+		}
+		c.emit(OpReturnValue)
+	case *ast.BlockStatement:
+		return c.compileStatements(n.Statements, true)
+	case *ast.ExpressionStatement:
+		return c.compileStatement(n, false)
+	case *ast.LetStatement:
+		return c.compileStatement(n, false)
+	case *ast.FunctionDeclaration:
+		return c.compileStatement(n, false)
+	case *ast.Number:
+		c.emit(OpConstant, c.addConstant(&object.Integer{Value: int64(n.Value)}))
+	case *ast.String:
+		c.emit(OpConstant, c.addConstant(&object.String{Value: n.Value}))
+	case *ast.Boolean:
+		if n.Value {
+			c.emit(OpTrue)
+		} else {
+			c.emit(OpFalse)
+		}
+	case *ast.PrefixExpression:
+		if err := c.Compile(n.Rhs); err != nil {
+			return err
+		}
+		switch n.Op {
+		case "-":
+			c.emit(OpMinus)
+		case "!":
+			c.emit(OpBang)
+		default:
+			return fmt.Errorf("unknown operator %s", n.Op)
+		}
+	case *ast.InfixExpression:
+		if n.Op == "??" {
+			return fmt.Errorf("compiler: ?? is not supported")
+		}
+		op, ok := infixOpcodes[n.Op]
+		if !ok {
+			return fmt.Errorf("unknown operator %s", n.Op)
+		}
+		if err := c.Compile(n.Lhs); err != nil {
+			return err
+		}
+		if err := c.Compile(n.Rhs); err != nil {
+			return err
+		}
+		c.emit(op)
+	case *ast.IfExpression:
+		return c.compileIf(n)
+	case *ast.Identifier:
+		sym, ok := c.symbols.Resolve(n.Value)
+		if !ok {
+			return fmt.Errorf("identifier '%s' not defined", n.Value)
+		}
+		c.loadSymbol(sym)
+	case *ast.ReturnExpression:
+		if err := c.Compile(n.Value); err != nil {
+			return err
+		}
+		c.emit(OpReturnValue)
+	case *ast.Array:
+		for _, elem := range n.Elems {
+			if err := c.Compile(elem); err != nil {
+				return err
+			}
+		}
+		c.emit(OpArray, len(n.Elems))
+	case *ast.HashLiteral:
+		// sort the keys so that the same program always compiles the same way
+		var keys []ast.Expression
+		for k := range n.Pairs {
+			keys = append(keys, k)
+		}
+		sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+		for _, k := range keys {
+			if err := c.Compile(k); err != nil {
+				return err
+			}
+			if err := c.Compile(n.Pairs[k]); err != nil {
+				return err
+			}
+		}
+		c.emit(OpHash, 2*len(keys))
+	case *ast.ArrayIndex:
+		if n.Optional {
+			return fmt.Errorf("compiler: optional chaining is not supported")
+		}
+		// like the evaluator, the index is evaluated before the array
+		if err := c.Compile(n.Index); err != nil {
+			return err
+		}
+		if err := c.Compile(n.Array); err != nil {
+			return err
+		}
+		c.emit(OpIndex)
+	case *ast.AssignExpression:
+		return c.compileAssign(n)
+	case *ast.FunctionLiteral:
+		_, err := c.compileFunction(n, "")
+		return err
+	case *ast.CallExpression:
+		if n.Optional {
+			return fmt.Errorf("compiler: optional chaining is not supported")
+		}
+		if err := c.Compile(n.Function); err != nil {
+			return err
+		}
+		for _, p := range n.Params {
+			if err := c.Compile(p); err != nil {
+				return err
+			}
+		}
+		c.emit(OpCall, len(n.Params))
+	case *ast.ImportExpression:
+		return fmt.Errorf("compiler: modules are not supported")
+	case *ast.FieldExpression:
+		return fmt.Errorf("compiler: structs are not supported")
+	case *ast.ArrayComprehension, *ast.HashComprehension:
+		return fmt.Errorf("compiler: comprehensions are not supported")
+	default:
+		return fmt.Errorf("compiler: unsupported node %T", node)
+	}
+	return nil
+}
+
+var infixOpcodes = map[string]Opcode{
+	"+":  OpAdd,
+	"-":  OpSub,
+	"*":  OpMul,
+	"/":  OpDiv,
+	"==": OpEqual,
+	"!=": OpNotEqual,
+	">":  OpGreaterThan,
+	"<":  OpLessThan,
+}
+
+// compileStatements compiles a list of statements. If keepLast is set, the
+// value of the final statement is left on the stack (null if there is none),
+// mirroring how the evaluator returns the last value of a block.
+func (c *Compiler) compileStatements(stmts []ast.Statement, keepLast bool) error {
+	if err := c.hoist(stmts); err != nil {
+		return err
+	}
+	for i, s := range stmts {
+		if err := c.compileStatement(s, keepLast && i == len(stmts)-1); err != nil {
+			return err
+		}
+	}
+	if keepLast && len(stmts) == 0 {
+		c.emit(OpNull)
+	}
+	return nil
+}
+
+func (c *Compiler) compileStatement(stmt ast.Statement, keepValue bool) error {
+	switch s := stmt.(type) {
+	case *ast.ExpressionStatement:
+		if err := c.Compile(s.Expr); err != nil {
+			return err
+		}
+		if !keepValue {
+			c.emit(OpPop)
+		}
+	case *ast.LetStatement:
+		if s.Pattern != nil {
+			return fmt.Errorf("compiler: destructuring is not supported")
+		}
+		if c.symbols.IsConst(s.Lhs.Value) {
+			return fmt.Errorf("compiler: cannot bind '%s': it is a constant", s.Lhs.Value)
+		}
+		define := c.symbols.Define
+		if s.Const {
+			define = c.symbols.DefineConst
+		}
+		var sym Symbol
+		if fn, ok := s.Rhs.(*ast.FunctionLiteral); ok {
+			// define the name first so that the function can call itself
+			sym = define(s.Lhs.Value)
+			if _, err := c.compileFunction(fn, s.Lhs.Value); err != nil {
+				return err
+			}
+		} else {
+			if err := c.Compile(s.Rhs); err != nil {
+				return err
+			}
+			sym = define(s.Lhs.Value)
+		}
+		if sym.Scope == GlobalScope {
+			c.emit(OpSetGlobal, sym.Index)
+		} else {
+			c.emit(OpSetLocal, sym.Index)
+		}
+		if keepValue {
+			// a let evaluates to the bound value
+			c.loadSymbol(sym)
+		}
+	case *ast.FunctionDeclaration:
+		// the closure was created by hoist
+		if keepValue {
+			sym, _ := c.symbols.Resolve(s.Name.Value)
+			c.loadSymbol(sym)
+		}
+	case *ast.ExportStatement:
+		return fmt.Errorf("compiler: modules are not supported")
+	case *ast.StructDeclaration:
+		return fmt.Errorf("compiler: structs are not supported")
+	case *ast.EnumDeclaration:
+		return fmt.Errorf("compiler: enums are not supported")
+	default:
+		return fmt.Errorf("compiler: unsupported statement %T", stmt)
+	}
+	return nil
+}
+
+// hoist creates the closures of the functions declared in stmts before the
+// statements run, like the evaluator does. Closures capture their free
+// variables when they are created, so a closure that refers to a local
+// declaration after it is created without it, and has it filled in once all
+// of the closures exist.
+func (c *Compiler) hoist(stmts []ast.Statement) error {
+	var decls []*ast.FunctionDeclaration
+	var syms []Symbol
+	for _, s := range stmts {
+		if decl := ast.Declaration(s); decl != nil {
+			if c.symbols.IsConst(decl.Name.Value) {
+				return fmt.Errorf("compiler: cannot bind '%s': it is a constant", decl.Name.Value)
+			}
+			sym := c.symbols.Define(decl.Name.Value)
+			if sym.Scope == LocalScope {
+				c.unset[sym] = true
+			}
+			decls, syms = append(decls, decl), append(syms, sym)
+		}
+	}
+	pending := make([]map[int]Symbol, len(decls))
+	for i, decl := range decls {
+		unset, err := c.compileFunction(decl.Fn, decl.Name.Value)
+		if err != nil {
+			return err
+		}
+		pending[i] = unset
+		if syms[i].Scope == GlobalScope {
+			c.emit(OpSetGlobal, syms[i].Index)
+		} else {
+			c.emit(OpSetLocal, syms[i].Index)
+		}
+	}
+	for i := range decls {
+		delete(c.unset, syms[i])
+	}
+	for i, unset := range pending {
+		// in index order, so that the output does not depend on map order
+		var idxs []int
+		for idx := range unset {
+			idxs = append(idxs, idx)
+		}
+		sort.Ints(idxs)
+		for _, idx := range idxs {
+			c.loadSymbol(syms[i])
+			c.loadSymbol(unset[idx])
+			c.emit(OpSetFree, idx)
+		}
+	}
+	return nil
+}
+
+func (c *Compiler) compileIf(n *ast.IfExpression) error {
+	if err := c.Compile(n.Cond); err != nil {
+		return err
+	}
+	jumpNotTruthy := c.emit(OpJumpNotTruthy, 9999)
+	if err := c.Compile(n.Then); err != nil {
+		return err
+	}
+	jump := c.emit(OpJump, 9999)
+	c.changeOperand(jumpNotTruthy, len(c.currentInstructions()))
+	if n.Else == nil {
+		c.emit(OpNull)
+	} else if err := c.Compile(n.Else); err != nil {
+		return err
+	}
+	c.changeOperand(jump, len(c.currentInstructions()))
+	return nil
+}
+
+func (c *Compiler) compileAssign(n *ast.AssignExpression) error {
+	ai, ok := n.Lhs.(*ast.ArrayIndex)
+	if !ok {
+		return fmt.Errorf("not implemented")
+	}
+	if _, ok := ai.Array.(*ast.Identifier); !ok {
+		return fmt.Errorf("only support identifiers")
+	}
+	for _, e := range []ast.Expression{ai.Array, ai.Index, n.Rhs} {
+		if err := c.Compile(e); err != nil {
+			return err
+		}
+	}
+	c.emit(OpSetIndex)
+	return nil
+}
+
+// compileFunction emits a closure for fn. name is the binding the function is
+// assigned to, if any, so that it can refer to itself. The free variables
+// that are hoisted functions not created yet are left null; they are
+// returned by index, for hoist to fill in.
+func (c *Compiler) compileFunction(fn *ast.FunctionLiteral, name string) (map[int]Symbol, error) {
+	if fn.Defaults != nil || fn.Rest != nil {
+		return nil, fmt.Errorf("compiler: default and rest parameters are not supported")
+	}
+	if fn.Generator {
+		return nil, fmt.Errorf("compiler: generators are not supported")
+	}
+	var paramNames []string
+	for _, p := range fn.Params {
+		paramNames = append(paramNames, p.Value)
+	}
+	c.enterScope()
+	if name != "" {
+		c.symbols.DefineFunctionName(name)
+	}
+	seen := make(map[string]bool)
+	for _, p := range fn.Params {
+		if seen[p.Value] {
+			c.leaveScope()
+			return nil, fmt.Errorf("repeated argument %q", p.Value)
+		}
+		seen[p.Value] = true
+		c.symbols.Define(p.Value)
+	}
+	if err := c.compileStatements(fn.Body.Statements, true); err != nil {
+		c.leaveScope()
+		return nil, err
+	}
+	c.emit(OpReturnValue)
+
+	free := c.symbols.FreeSymbols
+	numLocals := c.symbols.numDefinitions
+	instructions := c.leaveScope()
+	var unset map[int]Symbol
+	for i, sym := range free {
+		if c.unset[sym] {
+			if unset == nil {
+				unset = make(map[int]Symbol)
+			}
+			unset[i] = sym
+			c.emit(OpNull)
+			continue
+		}
+		c.loadSymbol(sym)
+	}
+	compiled := &object.CompiledFunction{
+		Instructions: instructions,
+		NumLocals:    numLocals,
+		NumParams:    len(fn.Params),
+		ParamNames:   paramNames,
+	}
+	c.emit(OpClosure, c.addConstant(compiled), len(free))
+	return unset, nil
+}
+
+func (c *Compiler) loadSymbol(s Symbol) {
+	switch s.Scope {
+	case GlobalScope:
+		c.emit(OpGetGlobal, s.Index)
+	case LocalScope:
+		c.emit(OpGetLocal, s.Index)
+	case BuiltinScope:
+		c.emit(OpGetBuiltin, s.Index)
+	case FreeScope:
+		c.emit(OpGetFree, s.Index)
+	case FunctionScope:
+		c.emit(OpCurrentClosure)
+	}
+}
+
+func (c *Compiler) addConstant(obj object.Object) int {
+	c.constants = append(c.constants, obj)
+	return len(c.constants) - 1
+}
+
+// operandNames says what the operands of an instruction count, for the error
+// when a program has more of them than an operand can hold.
+var operandNames = map[Opcode][]string{
+	OpConstant:      {"constants"},
+	OpJump:          {"instructions"},
+	OpJumpNotTruthy: {"instructions"},
+	OpSetGlobal:     {"globals"},
+	OpGetGlobal:     {"globals"},
+	OpSetLocal:      {"locals"},
+	OpGetLocal:      {"locals"},
+	OpGetBuiltin:    {"builtins"},
+	OpGetFree:       {"free variables"},
+	OpSetFree:       {"free variables"},
+	OpArray:         {"array elements"},
+	OpHash:          {"hash elements"},
+	OpClosure:       {"constants", "free variables"},
+	OpCall:          {"arguments"},
+}
+
+// checkOperands records an error if an operand of op does not fit in its
+// width, as Make would truncate it.
+func (c *Compiler) checkOperands(op Opcode, operands []int) {
+	def, ok := definitions[op]
+	if !ok || c.err != nil {
+		return
+	}
+	for i, o := range operands {
+		if limit := 1<<(8*def.OperandWidths[i]) - 1; o > limit {
+			c.err = fmt.Errorf("compiler: too many %s: %d exceeds the limit of %d", operandNames[op][i], o, limit)
+			return
+		}
+	}
+}
+
+// emit appends an instruction and returns its position
+func (c *Compiler) emit(op Opcode, operands ...int) int {
+	c.checkOperands(op, operands)
+	ins := Make(op, operands...)
+	pos := len(c.currentInstructions())
+	c.scopes[len(c.scopes)-1].instructions = append(c.currentInstructions(), ins...)
+	return pos
+}
+
+func (c *Compiler) changeOperand(pos int, operand int) {
+	ins := c.currentInstructions()
+	c.checkOperands(Opcode(ins[pos]), []int{operand})
+	copy(ins[pos:], Make(Opcode(ins[pos]), operand))
+}
+
+func (c *Compiler) currentInstructions() Instructions {
+	return c.scopes[len(c.scopes)-1].instructions
+}
+
+func (c *Compiler) enterScope() {
+	c.scopes = append(c.scopes, compilationScope{})
+	c.symbols = NewEnclosedSymbolTable(c.symbols)
+}
+
+func (c *Compiler) leaveScope() Instructions {
+	ins := c.currentInstructions()
+	c.scopes = c.scopes[:len(c.scopes)-1]
+	c.symbols = c.symbols.Outer
+	return ins
+}
@@ -0,0 +1,198 @@
+package compiler_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/kvalv/monkey/compiler"
+	"github.com/kvalv/monkey/object"
+	"github.com/kvalv/monkey/parser"
+)
+
+func TestMake(t *testing.T) {
+	cases := []struct {
+		op       compiler.Opcode
+		operands []int
+		expected []byte
+	}{
+		{compiler.OpConstant, []int{65534}, []byte{byte(compiler.OpConstant), 255, 254}},
+		{compiler.OpAdd, nil, []byte{byte(compiler.OpAdd)}},
+		{compiler.OpGetLocal, []int{255}, []byte{byte(compiler.OpGetLocal), 255}},
+		{compiler.OpClosure, []int{65534, 255}, []byte{byte(compiler.OpClosure), 255, 254, 255}},
+		{compiler.OpSetFree, []int{255}, []byte{byte(compiler.OpSetFree), 255}},
+	}
+	for _, tc := range cases {
+		got := compiler.Make(tc.op, tc.operands...)
+		if string(got) != string(tc.expected) {
+			t.Fatalf("expected %v, got %v", tc.expected, got)
+		}
+	}
+}
+
+func TestCompile(t *testing.T) {
+	cases := []struct {
+		input    string
+		expected string
+	}{
+		{"1 + 2", `0000 OpConstant 0
+0003 OpConstant 1
+0006 OpAdd
+0007 OpReturnValue
+`},
+		{"1; 2", `0000 OpConstant 0
+0003 OpPop
+0004 OpConstant 1
+0007 OpReturnValue
+`},
+		{"if true { 1 }", `0000 OpTrue
+0001 OpJumpNotTruthy 10
+0004 OpConstant 0
+0007 OpJump 11
+0010 OpNull
+0011 OpReturnValue
+`},
+		{"let a = 1; a", `0000 OpConstant 0
+0003 OpSetGlobal 0
+0006 OpGetGlobal 0
+0009 OpReturnValue
+`},
+		{"fn(a) { a }([])", `0000 OpClosure 0 0
+0004 OpArray 0
+0007 OpCall 1
+0009 OpReturnValue
+`},
+		{"fn(a) { fn(b) { a + b } }", `0000 OpClosure 1 0
+0004 OpReturnValue
+`},
+	}
+	for _, tc := range cases {
+		t.Run(tc.input, func(t *testing.T) {
+			prog, errs := parser.New(tc.input).Parse()
+			if len(errs) > 0 {
+				t.Fatalf("failed to parse: %v", errs)
+			}
+			c := compiler.New()
+			if err := c.Compile(prog); err != nil {
+				t.Fatal(err)
+			}
+			if got := c.Bytecode().Instructions.String(); got != tc.expected {
+				t.Fatalf("expected\n%s\ngot\n%s", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	cases := []struct {
+		input    string
+		expected string
+	}{
+		{"a", "identifier 'a' not defined"},
+		{"fn(x, x) { x }", `repeated argument "x"`},
+		{"fn() { y }", "identifier 'y' not defined"},
+		{"1 ?? 2", "compiler: ?? is not supported"},
+		{"[1]?.[0]", "compiler: optional chaining is not supported"},
+		{"len?.([1])", "compiler: optional chaining is not supported"},
+		{"[x for x in [1]]", "compiler: comprehensions are not supported"},
+		{"{x: x for x in [1]}", "compiler: comprehensions are not supported"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.input, func(t *testing.T) {
+			prog, _ := parser.New(tc.input).Parse()
+			err := compiler.New().Compile(prog)
+			if err == nil || err.Error() != tc.expected {
+				t.Fatalf("expected error %q, got %v", tc.expected, err)
+			}
+		})
+	}
+}
+
+func TestOperandLimits(t *testing.T) {
+	// repeat joins n copies of format, each formatted with a name of its own
+	repeat := func(format, sep string, n int) string {
+		parts := make([]string, n)
+		for i := range parts {
+			name := []byte{'a' + byte(i/26/26%26), 'a' + byte(i/26%26), 'a' + byte(i%26)}
+			parts[i] = fmt.Sprintf(format, name)
+		}
+		return strings.Join(parts, sep)
+	}
+	cases := []struct {
+		input    string
+		expected string
+	}{
+		{"fn() { " + repeat("let %s = 0", "; ", 257) + " }", "compiler: too many locals: 256 exceeds the limit of 255"},
+		{"fn(f) { f(" + repeat("%q", ", ", 256) + ") }", "compiler: too many arguments: 256 exceeds the limit of 255"},
+		{"fn() { " + repeat("let %s = 0", "; ", 256) + "; fn() { [" + repeat("%s", ", ", 256) + "] } }", "compiler: too many free variables: 256 exceeds the limit of 255"},
+		{"[" + repeat("%q", ", ", 1<<16) + "]", "compiler: too many array elements: 65536 exceeds the limit of 65535"},
+		{repeat("[%q]", "; ", 1<<16+1), "compiler: too many constants: 65536 exceeds the limit of 65535"},
+		{"if true { " + repeat("%q", "; ", 1<<14+1) + " }", "compiler: too many instructions: 65546 exceeds the limit of 65535"},
+	}
+	for i, tc := range cases {
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			prog, errs := parser.New(tc.input).Parse()
+			if len(errs) > 0 {
+				t.Fatalf("failed to parse: %v", errs)
+			}
+			err := compiler.New().Compile(prog)
+			if err == nil || err.Error() != tc.expected {
+				t.Fatalf("expected error %q, got %v", tc.expected, err)
+			}
+		})
+	}
+	// the largest operands still compile
+	prog, _ := parser.New("fn() { " + repeat("let %s = 0", "; ", 256) + " }").Parse()
+	if err := compiler.New().Compile(prog); err != nil {
+		t.Fatalf("expected 256 locals to compile, got %v", err)
+	}
+}
+
+func TestResolveFree(t *testing.T) {
+	global := compiler.NewSymbolTable()
+	global.Define("a")
+	outer := compiler.NewEnclosedSymbolTable(global)
+	outer.Define("b")
+	inner := compiler.NewEnclosedSymbolTable(outer)
+	inner.Define("c")
+
+	expected := map[string]compiler.Symbol{
+		"a": {Name: "a", Scope: compiler.GlobalScope, Index: 0},
+		"b": {Name: "b", Scope: compiler.FreeScope, Index: 0},
+		"c": {Name: "c", Scope: compiler.LocalScope, Index: 0},
+	}
+	for name, exp := range expected {
+		got, ok := inner.Resolve(name)
+		if !ok || got != exp {
+			t.Fatalf("%s: expected %+v, got %+v", name, exp, got)
+		}
+	}
+	if len(inner.FreeSymbols) != 1 || inner.FreeSymbols[0].Scope != compiler.LocalScope {
+		t.Fatalf("expected b to be captured as a free variable, got %+v", inner.FreeSymbols)
+	}
+}
+
+func TestHoistedClosures(t *testing.T) {
+	// f is created before g, so it gets g once both exist
+	prog, _ := parser.New("fn() { fn f() { g() }; fn g() { 1 } }").Parse()
+	c := compiler.New()
+	if err := c.Compile(prog); err != nil {
+		t.Fatal(err)
+	}
+	consts := c.Bytecode().Constants
+	outer := consts[len(consts)-1].(*object.CompiledFunction)
+	expected := `0000 OpNull
+0001 OpClosure 0 1
+0005 OpSetLocal 0
+0007 OpClosure 2 0
+0011 OpSetLocal 1
+0013 OpGetLocal 0
+0015 OpGetLocal 1
+0017 OpSetFree 0
+0019 OpGetLocal 1
+0021 OpReturnValue
+`
+	if got := compiler.Instructions(outer.Instructions).String(); got != expected {
+		t.Fatalf("expected\n%s\ngot\n%s", expected, got)
+	}
+}
@@ -0,0 +1,102 @@
+package compiler
+
+type SymbolScope string
+
+const (
+	GlobalScope   SymbolScope = "GLOBAL"
+	LocalScope    SymbolScope = "LOCAL"
+	BuiltinScope  SymbolScope = "BUILTIN"
+	FreeScope     SymbolScope = "FREE"
+	FunctionScope SymbolScope = "FUNCTION"
+)
+
+type Symbol struct {
+	Name  string
+	Scope SymbolScope
+	Index int
+}
+
+// SymbolTable resolves names for one function body; Outer is the table of
+// the enclosing function, or nil at the top level.
+type SymbolTable struct {
+	Outer       *SymbolTable
+	FreeSymbols []Symbol
+
+	store          map[string]Symbol
+	consts         map[string]bool
+	numDefinitions int
+}
+
+func NewSymbolTable() *SymbolTable {
+	return &SymbolTable{store: make(map[string]Symbol)}
+}
+
+func NewEnclosedSymbolTable(outer *SymbolTable) *SymbolTable {
+	s := NewSymbolTable()
+	s.Outer = outer
+	return s
+}
+
+// Define binds name in the current scope. Redefining a name reuses its slot,
+// just like a repeated let overwrites the binding in the evaluator.
+func (s *SymbolTable) Define(name string) Symbol {
+	if sym, ok := s.store[name]; ok && (sym.Scope == GlobalScope || sym.Scope == LocalScope) {
+		return sym
+	}
+	sym := Symbol{Name: name, Index: s.numDefinitions, Scope: LocalScope}
+	if s.Outer == nil {
+		sym.Scope = GlobalScope
+	}
+	s.store[name] = sym
+	s.numDefinitions++
+	return sym
+}
+
+// DefineConst binds name like Define, after which it is constant: IsConst
+// reports true for it until the table is discarded.
+func (s *SymbolTable) DefineConst(name string) Symbol {
+	sym := s.Define(name)
+	if s.consts == nil {
+		s.consts = make(map[string]bool)
+	}
+	s.consts[name] = true
+	return sym
+}
+
+// IsConst reports whether name is bound in the current scope by DefineConst.
+func (s *SymbolTable) IsConst(name string) bool {
+	return s.consts[name]
+}
+
+func (s *SymbolTable) DefineBuiltin(index int, name string) Symbol {
+	sym := Symbol{Name: name, Index: index, Scope: BuiltinScope}
+	s.store[name] = sym
+	return sym
+}
+
+// DefineFunctionName lets a function refer to itself by the name it is bound
+// to, without capturing that binding as a free variable.
+func (s *SymbolTable) DefineFunctionName(name string) Symbol {
+	sym := Symbol{Name: name, Index: 0, Scope: FunctionScope}
+	s.store[name] = sym
+	return sym
+}
+
+func (s *SymbolTable) defineFree(original Symbol) Symbol {
+	s.FreeSymbols = append(s.FreeSymbols, original)
+	sym := Symbol{Name: original.Name, Index: len(s.FreeSymbols) - 1, Scope: FreeScope}
+	s.store[original.Name] = sym
+	return sym
+}
+
+func (s *SymbolTable) Resolve(name string) (Symbol, bool) {
+	sym, ok := s.store[name]
+	if ok || s.Outer == nil {
+		return sym, ok
+	}
+	sym, ok = s.Outer.Resolve(name)
+	if !ok || sym.Scope == GlobalScope || sym.Scope == BuiltinScope {
+		return sym, ok
+	}
+	return s.defineFree(sym), true
+}
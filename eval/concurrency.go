@@ -0,0 +1,674 @@
+package eval
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/kvalv/monkey/object"
+)
+
+// Concurrency model
+//
+// spawn runs a function as a task. By default every task is a goroutine and
+// blocking operations (await, recv, send, select, wg_wait) simply block it.
+// WithDeterministicScheduler switches to a cooperative scheduler where only
+// one task runs at a time and control changes hands only when the running
+// task blocks, yields or finishes. Tasks are then resumed in FIFO order, which
+// makes the interleaving reproducible in tests.
+//
+// Either way, a blocked task gives up as soon as the context of the
+// evaluation is cancelled.
+
+var errDeadlock = errors.New("deadlock: all tasks are blocked")
+
+type ctxKey int
+
+const (
+	taskKey ctxKey = iota
+	schedulerKey
+	loopKey
+	modulesKey
+	importKey
+	generatorKey
+	generatorsKey
+	callbackKey
+)
+
+type scheduler interface {
+	// start runs a new task
+	start(ctx context.Context, t *Task, run func())
+	// park blocks t until ready(t) is called
+	park(ctx context.Context, t *Task) error
+	// ready marks a parked task as runnable
+	ready(t *Task)
+	// yield lets other tasks run
+	yield(ctx context.Context, t *Task) error
+	// exit is called when t has finished running
+	exit(t *Task)
+}
+
+// WithDeterministicScheduler returns a context under which spawned tasks are
+// scheduled cooperatively and in a reproducible order. The evaluation using
+// the returned context becomes the main task.
+func WithDeterministicScheduler(ctx context.Context) context.Context {
+	ctx = context.WithValue(ctx, schedulerKey, &deterministicScheduler{blocked: make(map[*Task]bool)})
+	return context.WithValue(ctx, taskKey, newTask())
+}
+
+func schedulerFrom(ctx context.Context) scheduler {
+	if s, ok := ctx.Value(schedulerKey).(scheduler); ok {
+		return s
+	}
+	return goroutineScheduler{}
+}
+
+// currentTask returns the task evaluating under ctx. Top-level evaluations
+// with the default scheduler are not tasks, so they get a throwaway one that
+// is only used for parking.
+func currentTask(ctx context.Context) *Task {
+	if t, ok := ctx.Value(taskKey).(*Task); ok {
+		return t
+	}
+	return newTask()
+}
+
+type goroutineScheduler struct{}
+
+func (goroutineScheduler) start(_ context.Context, _ *Task, run func()) { go run() }
+func (goroutineScheduler) park(ctx context.Context, t *Task) error {
+	select {
+	case <-t.wakeup:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+func (goroutineScheduler) ready(t *Task) {
+	select {
+	case t.wakeup <- struct{}{}:
+	default:
+	}
+}
+func (goroutineScheduler) yield(context.Context, *Task) error {
+	runtime.Gosched()
+	return nil
+}
+func (goroutineScheduler) exit(*Task) {}
+
+type deterministicScheduler struct {
+	mu      sync.Mutex
+	runq    []*Task
+	blocked map[*Task]bool
+}
+
+func (s *deterministicScheduler) start(ctx context.Context, t *Task, run func()) {
+	go func() {
+		select {
+		case <-t.wakeup:
+			run()
+		case <-ctx.Done():
+		}
+	}()
+	s.mu.Lock()
+	s.runq = append(s.runq, t)
+	s.mu.Unlock()
+}
+
+func (s *deterministicScheduler) park(ctx context.Context, t *Task) error {
+	s.mu.Lock()
+	next := s.pop()
+	if next == nil {
+		s.mu.Unlock()
+		return errDeadlock
+	}
+	s.blocked[t] = true
+	s.mu.Unlock()
+	return s.switchTo(ctx, t, next)
+}
+
+func (s *deterministicScheduler) ready(t *Task) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.blocked[t] {
+		delete(s.blocked, t)
+		s.runq = append(s.runq, t)
+	}
+}
+
+func (s *deterministicScheduler) yield(ctx context.Context, t *Task) error {
+	s.mu.Lock()
+	s.runq = append(s.runq, t)
+	next := s.pop()
+	s.mu.Unlock()
+	if next == t {
+		return nil
+	}
+	return s.switchTo(ctx, t, next)
+}
+
+func (s *deterministicScheduler) exit(t *Task) {
+	s.mu.Lock()
+	next := s.pop()
+	if next == nil && len(s.blocked) > 0 {
+		// nobody is left to wake the blocked tasks; fail the oldest one
+		var tasks []*Task
+		for b := range s.blocked {
+			tasks = append(tasks, b)
+		}
+		sort.Slice(tasks, func(i, j int) bool { return tasks[i].id < tasks[j].id })
+		next = tasks[0]
+		next.err = errDeadlock
+		delete(s.blocked, next)
+	}
+	s.mu.Unlock()
+	if next != nil {
+		next.wakeup <- struct{}{}
+	}
+}
+
+// switchTo hands control to next and blocks until t is scheduled again.
+func (s *deterministicScheduler) switchTo(ctx context.Context, t, next *Task) error {
+	next.wakeup <- struct{}{}
+	select {
+	case <-t.wakeup:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	if err := t.err; err != nil {
+		t.err = nil
+		return err
+	}
+	return nil
+}
+
+// pop must be called with s.mu held
+func (s *deterministicScheduler) pop() *Task {
+	if len(s.runq) == 0 {
+		return nil
+	}
+	t := s.runq[0]
+	s.runq = s.runq[1:]
+	return t
+}
+
+// waiter is a task blocked on a channel, task or wait group. All cases of a
+// select share one waitState, and whoever fires it first wins.
+type waiter struct {
+	task  *Task
+	state *waitState
+	index int
+	value object.Object // the value a sender wants to deliver
+}
+
+type waitState struct {
+	fired atomic.Bool
+	index int
+	value object.Object
+	ok    bool
+}
+
+func newWaiter(t *Task) *waiter { return &waiter{task: t, state: &waitState{}} }
+
+// wait parks the current task until w fires. If the evaluation is cancelled
+// first, w is disarmed so that nobody hands it a value afterwards.
+func wait(ctx context.Context, w *waiter) error {
+	sched := schedulerFrom(ctx)
+	err := sched.park(ctx, w.task)
+	if err == nil || w.state.fired.CompareAndSwap(false, true) {
+		return err
+	}
+	// we lost the race against fire, so the operation did complete; consume
+	// the wakeup it sends so that it doesn't end the next park early
+	if _, ok := sched.(goroutineScheduler); ok {
+		<-w.task.wakeup
+	}
+	return nil
+}
+
+// fire completes the operation w is waiting for. It returns false if w has
+// already fired, e.g. through another case of the same select.
+func fire(ctx context.Context, w *waiter, value object.Object, ok bool) bool {
+	if !w.state.fired.CompareAndSwap(false, true) {
+		return false
+	}
+	w.state.index, w.state.value, w.state.ok = w.index, value, ok
+	schedulerFrom(ctx).ready(w.task)
+	return true
+}
+
+var taskCounter atomic.Int64
+
+// Task is the handle returned by spawn.
+type Task struct {
+	id     int64
+	wakeup chan struct{}
+	err    error // set by the scheduler when resuming a task that cannot proceed
+
+	mu      sync.Mutex
+	done    bool
+	result  object.Object
+	waiters []*waiter
+}
+
+func newTask() *Task {
+	return &Task{id: taskCounter.Add(1), wakeup: make(chan struct{}, 1)}
+}
+
+func (t *Task) Type() object.Type { return object.TASK_OBJ }
+func (t *Task) String() string    { return fmt.Sprintf("task(%d)", t.id) }
+
+func (t *Task) finish(ctx context.Context, result object.Object) {
+	t.mu.Lock()
+	t.done, t.result = true, result
+	waiters := t.waiters
+	t.waiters = nil
+	t.mu.Unlock()
+	for _, w := range waiters {
+		fire(ctx, w, result, true)
+	}
+}
+
+func (t *Task) await(ctx context.Context) object.Object {
+	t.mu.Lock()
+	if t.done {
+		t.mu.Unlock()
+		return t.result
+	}
+	w := newWaiter(currentTask(ctx))
+	t.waiters = append(t.waiters, w)
+	t.mu.Unlock()
+	if err := wait(ctx, w); err != nil {
+		return errBlocked("await", err)
+	}
+	return w.state.value
+}
+
+var channelCounter atomic.Int64
+
+// Channel is a FIFO queue between tasks with an optional buffer.
+type Channel struct {
+	id     int64
+	mu     sync.Mutex
+	size   int
+	buf    []object.Object
+	closed bool
+	recvq  []*waiter
+	sendq  []*waiter
+}
+
+func newChannel(size int) *Channel {
+	return &Channel{id: channelCounter.Add(1), size: size}
+}
+
+func (c *Channel) Type() object.Type { return object.CHANNEL_OBJ }
+func (c *Channel) String() string    { return fmt.Sprintf("channel(%d)", c.id) }
+
+func (c *Channel) send(ctx context.Context, v object.Object) object.Object {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return object.NewError(object.RuntimeError, "send on closed channel")
+	}
+	for len(c.recvq) > 0 {
+		w := c.recvq[0]
+		c.recvq = c.recvq[1:]
+		if fire(ctx, w, v, true) {
+			c.mu.Unlock()
+			return object.NULL
+		}
+	}
+	if len(c.buf) < c.size {
+		c.buf = append(c.buf, v)
+		c.mu.Unlock()
+		return object.NULL
+	}
+	w := newWaiter(currentTask(ctx))
+	w.value = v
+	c.sendq = append(c.sendq, w)
+	c.mu.Unlock()
+	if err := wait(ctx, w); err != nil {
+		return errBlocked("send", err)
+	}
+	if !w.state.ok {
+		return object.NewError(object.RuntimeError, "send on closed channel")
+	}
+	return object.NULL
+}
+
+// tryRecv must be called with c.mu held. ready is false if receiving would
+// block.
+func (c *Channel) tryRecv(ctx context.Context) (value object.Object, ok, ready bool) {
+	if len(c.buf) > 0 {
+		value = c.buf[0]
+		c.buf = c.buf[1:]
+		for len(c.sendq) > 0 {
+			w := c.sendq[0]
+			c.sendq = c.sendq[1:]
+			if fire(ctx, w, nil, true) {
+				c.buf = append(c.buf, w.value)
+				break
+			}
+		}
+		return value, true, true
+	}
+	for len(c.sendq) > 0 {
+		w := c.sendq[0]
+		c.sendq = c.sendq[1:]
+		if fire(ctx, w, nil, true) {
+			return w.value, true, true
+		}
+	}
+	if c.closed {
+		return object.NULL, false, true
+	}
+	return nil, false, false
+}
+
+func (c *Channel) recv(ctx context.Context) (object.Object, bool, object.Object) {
+	c.mu.Lock()
+	if v, ok, ready := c.tryRecv(ctx); ready {
+		c.mu.Unlock()
+		return v, ok, nil
+	}
+	w := newWaiter(currentTask(ctx))
+	c.recvq = append(c.recvq, w)
+	c.mu.Unlock()
+	if err := wait(ctx, w); err != nil {
+		return nil, false, errBlocked("recv", err)
+	}
+	return w.state.value, w.state.ok, nil
+}
+
+func (c *Channel) close(ctx context.Context) object.Object {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return object.NewError(object.RuntimeError, "close of closed channel")
+	}
+	c.closed = true
+	for _, w := range c.recvq {
+		fire(ctx, w, object.NULL, false)
+	}
+	for _, w := range c.sendq {
+		fire(ctx, w, nil, false)
+	}
+	c.recvq, c.sendq = nil, nil
+	return object.NULL
+}
+
+// selectRecv receives from whichever channel is ready first. When several are
+// ready, the first one in argument order wins.
+func selectRecv(ctx context.Context, chans []*Channel) object.Object {
+	// lock every channel involved, in a consistent order
+	locked := append([]*Channel{}, chans...)
+	sort.Slice(locked, func(i, j int) bool { return locked[i].id < locked[j].id })
+	prev := (*Channel)(nil)
+	for _, c := range locked {
+		if c != prev {
+			c.mu.Lock()
+		}
+		prev = c
+	}
+	unlock := func() {
+		prev := (*Channel)(nil)
+		for _, c := range locked {
+			if c != prev {
+				c.mu.Unlock()
+			}
+			prev = c
+		}
+	}
+
+	for i, c := range chans {
+		if v, ok, ready := c.tryRecv(ctx); ready {
+			unlock()
+			return selected(i, v, ok)
+		}
+	}
+	state := &waitState{}
+	t := currentTask(ctx)
+	for i, c := range chans {
+		c.recvq = append(c.recvq, &waiter{task: t, state: state, index: i})
+	}
+	unlock()
+	if err := wait(ctx, &waiter{task: t, state: state}); err != nil {
+		return errBlocked("select", err)
+	}
+	return selected(state.index, state.value, state.ok)
+}
+
+func selected(i int, v object.Object, ok bool) object.Object {
+	return object.NewArray(&object.Integer{Value: int64(i)}, v, nativeBoolToBoolean(ok))
+}
+
+// WaitGroup waits for a number of tasks to call wg_done.
+type WaitGroup struct {
+	mu      sync.Mutex
+	count   int64
+	waiters []*waiter
+}
+
+func (wg *WaitGroup) Type() object.Type { return object.WAITGROUP_OBJ }
+func (wg *WaitGroup) String() string {
+	wg.mu.Lock()
+	defer wg.mu.Unlock()
+	return fmt.Sprintf("wait_group(%d)", wg.count)
+}
+
+func (wg *WaitGroup) add(ctx context.Context, n int64) object.Object {
+	wg.mu.Lock()
+	defer wg.mu.Unlock()
+	if wg.count+n < 0 {
+		return object.NewError(object.RuntimeError, "negative wait group counter")
+	}
+	wg.count += n
+	if wg.count == 0 {
+		for _, w := range wg.waiters {
+			fire(ctx, w, object.NULL, true)
+		}
+		wg.waiters = nil
+	}
+	return object.NULL
+}
+
+func (wg *WaitGroup) wait(ctx context.Context) object.Object {
+	wg.mu.Lock()
+	if wg.count == 0 {
+		wg.mu.Unlock()
+		return object.NULL
+	}
+	w := newWaiter(currentTask(ctx))
+	wg.waiters = append(wg.waiters, w)
+	wg.mu.Unlock()
+	if err := wait(ctx, w); err != nil {
+		return errBlocked("wg_wait", err)
+	}
+	return object.NULL
+}
+
+func errBlocked(op string, err error) *object.Error {
+	if errors.Is(err, errDeadlock) {
+		return object.NewError(object.RuntimeError, "%s(): %v", op, err)
+	}
+	return object.NewError(object.CancelledError, "%s(): evaluation cancelled: %v", op, err)
+}
+
+// spawn starts fn as a new task. The task evaluates under the caller's
+// context, so cancelling the evaluation also stops its tasks.
+func spawn(env *object.Environment, fn object.Object, args []object.Object) *Task {
+	ctx := env.Context()
+	t := newTask()
+	tctx := context.WithValue(ctx, taskKey, t)
+	sched := schedulerFrom(ctx)
+	sched.start(ctx, t, func() {
+		t.finish(tctx, applyFunction(fn, args, env.WithContext(tctx)))
+		sched.exit(t)
+	})
+	return t
+}
+
+func init() {
+	builtin["spawn"] = &object.Builtin{
+		Fn: func(env *object.Environment, args ...object.Object) object.Object {
+			if len(args) < 1 {
+				return object.NewError(object.TypeError, "spawn() expects a function")
+			}
+			switch args[0].(type) {
+			case *object.Function, *object.Builtin, *object.Closure:
+			default:
+				return object.NewError(object.TypeError, "spawn() not supported for objects of type %s", args[0].Type())
+			}
+			return spawn(env, args[0], args[1:])
+		},
+	}
+	builtin["await"] = &object.Builtin{
+		Fn: func(env *object.Environment, args ...object.Object) object.Object {
+			if len(args) != 1 {
+				return object.NewError(object.ArgumentError, "await() accepts 1 argument, got %d", len(args))
+			}
+			t, ok := args[0].(*Task)
+			if !ok {
+				return object.NewError(object.TypeError, "await() not supported for objects of type %s", args[0].Type())
+			}
+			return t.await(env.Context())
+		},
+	}
+	builtin["yield"] = &object.Builtin{
+		Fn: func(env *object.Environment, args ...object.Object) object.Object {
+			ctx := env.Context()
+			if len(args) > 0 {
+				// yield(value) is that of a generator
+				return yieldValue(ctx, args)
+			}
+			if err := schedulerFrom(ctx).yield(ctx, currentTask(ctx)); err != nil {
+				return errBlocked("yield", err)
+			}
+			return object.NULL
+		},
+	}
+	builtin["channel"] = &object.Builtin{
+		Fn: func(env *object.Environment, args ...object.Object) object.Object {
+			switch len(args) {
+			case 0:
+				return newChannel(0)
+			case 1:
+				size, ok := args[0].(*object.Integer)
+				if !ok || size.Value < 0 {
+					return object.NewError(object.ArgumentError, "channel() expects a non-negative buffer size")
+				}
+				return newChannel(int(size.Value))
+			default:
+				return object.NewError(object.ArgumentError, "channel() accepts at most 1 argument, got %d", len(args))
+			}
+		},
+	}
+	builtin["send"] = &object.Builtin{
+		Fn: func(env *object.Environment, args ...object.Object) object.Object {
+			if len(args) != 2 {
+				return object.NewError(object.ArgumentError, "send() accepts 2 arguments, got %d", len(args))
+			}
+			c, ok := args[0].(*Channel)
+			if !ok {
+				return object.NewError(object.TypeError, "send() not supported for objects of type %s", args[0].Type())
+			}
+			return c.send(env.Context(), args[1])
+		},
+	}
+	builtin["recv"] = &object.Builtin{
+		Fn: func(env *object.Environment, args ...object.Object) object.Object {
+			if len(args) != 1 {
+				return object.NewError(object.ArgumentError, "recv() accepts 1 argument, got %d", len(args))
+			}
+			c, ok := args[0].(*Channel)
+			if !ok {
+				return object.NewError(object.TypeError, "recv() not supported for objects of type %s", args[0].Type())
+			}
+			v, _, err := c.recv(env.Context())
+			if err != nil {
+				return err
+			}
+			return v
+		},
+	}
+	builtin["close"] = &object.Builtin{
+		Fn: func(env *object.Environment, args ...object.Object) object.Object {
+			if len(args) != 1 {
+				return object.NewError(object.ArgumentError, "close() accepts 1 argument, got %d", len(args))
+			}
+			c, ok := args[0].(*Channel)
+			if !ok {
+				return object.NewError(object.TypeError, "close() not supported for objects of type %s", args[0].Type())
+			}
+			return c.close(env.Context())
+		},
+	}
+	builtin["select"] = &object.Builtin{
+		Fn: func(env *object.Environment, args ...object.Object) object.Object {
+			if len(args) == 0 {
+				return object.NewError(object.ArgumentError, "select() expects at least one channel")
+			}
+			var chans []*Channel
+			for _, arg := range args {
+				c, ok := arg.(*Channel)
+				if !ok {
+					return object.NewError(object.TypeError, "select() not supported for objects of type %s", arg.Type())
+				}
+				chans = append(chans, c)
+			}
+			return selectRecv(env.Context(), chans)
+		},
+	}
+	builtin["wait_group"] = &object.Builtin{
+		Fn: func(env *object.Environment, args ...object.Object) object.Object {
+			if len(args) != 0 {
+				return object.NewError(object.ArgumentError, "wait_group() accepts no arguments, got %d", len(args))
+			}
+			return &WaitGroup{}
+		},
+	}
+	builtin["wg_add"] = &object.Builtin{
+		Fn: func(env *object.Environment, args ...object.Object) object.Object {
+			if len(args) != 2 {
+				return object.NewError(object.ArgumentError, "wg_add() accepts 2 arguments, got %d", len(args))
+			}
+			wg, ok := args[0].(*WaitGroup)
+			if !ok {
+				return object.NewError(object.TypeError, "wg_add() not supported for objects of type %s", args[0].Type())
+			}
+			n, ok := args[1].(*object.Integer)
+			if !ok {
+				return object.ErrorExpected(object.INTEGER_OBJ)
+			}
+			return wg.add(env.Context(), n.Value)
+		},
+	}
+	builtin["wg_done"] = &object.Builtin{
+		Fn: func(env *object.Environment, args ...object.Object) object.Object {
+			if len(args) != 1 {
+				return object.NewError(object.ArgumentError, "wg_done() accepts 1 argument, got %d", len(args))
+			}
+			wg, ok := args[0].(*WaitGroup)
+			if !ok {
+				return object.NewError(object.TypeError, "wg_done() not supported for objects of type %s", args[0].Type())
+			}
+			return wg.add(env.Context(), -1)
+		},
+	}
+	builtin["wg_wait"] = &object.Builtin{
+		Fn: func(env *object.Environment, args ...object.Object) object.Object {
+			if len(args) != 1 {
+				return object.NewError(object.ArgumentError, "wg_wait() accepts 1 argument, got %d", len(args))
+			}
+			wg, ok := args[0].(*WaitGroup)
+			if !ok {
+				return object.NewError(object.TypeError, "wg_wait() not supported for objects of type %s", args[0].Type())
+			}
+			return wg.wait(env.Context())
+		},
+	}
+}
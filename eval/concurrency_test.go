@@ -0,0 +1,118 @@
+package eval_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/kvalv/monkey/eval"
+	"github.com/kvalv/monkey/object"
+)
+
+func TestSpawnAndAwait(t *testing.T) {
+	cases := []struct {
+		input    string
+		expected any
+	}{
+		{"await(spawn(fn() { 1 + 2 }))", 3},
+		{"let add = fn(a, b) { a + b }; await(spawn(add, 2, 3))", 5},
+		{"let t = spawn(fn() { 1 }); await(t) + await(t)", 2},
+		{"await(spawn(fn() { 1 + true }))", fmt.Errorf("type mismatch: INTEGER + BOOLEAN")},
+		{"spawn(1)", fmt.Errorf("spawn() not supported for objects of type INTEGER")},
+		{`
+			let fib = fn(n) { if (n < 2) { return n }; fib(n - 1) + fib(n - 2) };
+			let tasks = [spawn(fib, 10), spawn(fib, 11), spawn(fib, 12)];
+			await(tasks[0]) + await(tasks[1]) + await(tasks[2])
+		`, 55 + 89 + 144},
+	}
+	for _, tc := range cases {
+		t.Run(tc.input, func(t *testing.T) {
+			got := expectEval(t, expectParse(t, tc.input))
+			expectLiteral(t, got, tc.expected)
+		})
+	}
+}
+
+func TestChannels(t *testing.T) {
+	cases := []struct {
+		input    string
+		expected any
+	}{
+		{"let ch = channel(1); send(ch, 4); recv(ch)", 4},
+		{"let ch = channel(); spawn(send, ch, 5); recv(ch)", 5},
+		{"let ch = channel(); spawn(fn() { send(ch, 1); send(ch, 2) }); recv(ch) + recv(ch)", 3},
+		{"let ch = channel(); close(ch); recv(ch)", nil},
+		{"let ch = channel(1); close(ch); send(ch, 1)", fmt.Errorf("send on closed channel")},
+		{"let ch = channel(); close(ch); close(ch)", fmt.Errorf("close of closed channel")},
+		{"let a = channel(); let b = channel(1); send(b, 7); select(a, b)", []any{1, 7, true}},
+		{"let a = channel(); let b = channel(); spawn(send, a, 8); select(a, b)", []any{0, 8, true}},
+		{"let a = channel(); close(a); select(a)", []any{0, nil, false}},
+		{"recv(1)", fmt.Errorf("recv() not supported for objects of type INTEGER")},
+	}
+	for _, tc := range cases {
+		t.Run(tc.input, func(t *testing.T) {
+			got := expectEval(t, expectParse(t, tc.input))
+			expectLiteral(t, got, tc.expected)
+		})
+	}
+}
+
+func TestWaitGroup(t *testing.T) {
+	input := `
+		let results = {};
+		let wg = wait_group();
+		let work = fn(i) { results[i] = i * i; wg_done(wg) };
+		wg_add(wg, 3);
+		spawn(work, 1); spawn(work, 2); spawn(work, 3);
+		wg_wait(wg);
+		results[1] + results[2] + results[3]
+	`
+	got := expectEval(t, expectParse(t, input))
+	expectLiteral(t, got, 14)
+}
+
+func TestDeterministicScheduler(t *testing.T) {
+	input := `
+		let ch = channel();
+		let producer = fn(id) { send(ch, id) };
+		spawn(producer, 1); spawn(producer, 2); spawn(producer, 3);
+		[recv(ch), recv(ch), recv(ch)]
+	`
+	prog := expectParse(t, input)
+	for i := 0; i < 50; i++ {
+		ctx := eval.WithDeterministicScheduler(context.Background())
+		got := eval.Eval(prog, object.NewEnvironment().WithContext(ctx))
+		expectLiteral(t, got, []any{1, 2, 3})
+	}
+
+	t.Run("yield", func(t *testing.T) {
+		input := `
+			let log = channel(10);
+			let worker = fn(name) { send(log, name + "1"); yield(); send(log, name + "2") };
+			let a = spawn(worker, "a"); let b = spawn(worker, "b");
+			await(a); await(b); close(log);
+			[recv(log), recv(log), recv(log), recv(log)]
+		`
+		ctx := eval.WithDeterministicScheduler(context.Background())
+		got := eval.Eval(expectParse(t, input), object.NewEnvironment().WithContext(ctx))
+		expectLiteral(t, got, []any{"a1", "b1", "a2", "b2"})
+	})
+
+	t.Run("deadlock", func(t *testing.T) {
+		ctx := eval.WithDeterministicScheduler(context.Background())
+		got := eval.Eval(expectParse(t, "recv(channel())"), object.NewEnvironment().WithContext(ctx))
+		expectErrorMessage(t, got, "recv(): deadlock: all tasks are blocked")
+	})
+}
+
+func TestCancellation(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	input := "let ch = channel(); spawn(fn() { recv(ch) }); recv(ch)"
+	got := eval.Eval(expectParse(t, input), object.NewEnvironment().WithContext(ctx))
+	expectErrorMessage(t, got, "recv(): evaluation cancelled: context deadline exceeded")
+
+	got = eval.Eval(expectParse(t, "fn() { 1 }()"), object.NewEnvironment().WithContext(ctx))
+	expectErrorMessage(t, got, "evaluation cancelled: context deadline exceeded")
+}
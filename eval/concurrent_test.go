@@ -0,0 +1,99 @@
+package eval_test
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/kvalv/monkey/eval"
+	"github.com/kvalv/monkey/object"
+)
+
+// These tests are mostly useful with the race detector: go test -race ./eval
+
+func TestParallelEvaluation(t *testing.T) {
+	global := object.NewEnvironment()
+	setup := `
+		let fib = fn(n) { if (n < 2) { return n }; fib(n - 1) + fib(n - 2) };
+		let config = {"offset": 100};
+	`
+	if res := eval.Eval(expectParse(t, setup), global); object.IsError(res) {
+		t.Fatalf("setup failed: %s", res)
+	}
+	global.Freeze()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			input := fmt.Sprintf(`let n = %d; fib(n) + config["offset"]`, i%10)
+			got := eval.Eval(expectParse(t, input), global.NewScope())
+			want := []int64{0, 1, 1, 2, 3, 5, 8, 13, 21, 34}[i%10] + 100
+			if v, ok := got.(*object.Integer); !ok || v.Value != want {
+				t.Errorf("goroutine %d: expected %d, got %s", i, want, got)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestSharedProgram(t *testing.T) {
+	// the program is resolved by each evaluation rather than once, as none
+	// of them may write to it
+	prog := expectParse(t, `
+		let sum = fn(xs) { if (len(xs) == 0) { return 0 }; let [x, ...rest] = xs; x + sum(rest) };
+		sum([x * x for x in [1, 2, 3]])
+	`)
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			got := eval.Eval(prog, object.NewEnvironment())
+			if v, ok := got.(*object.Integer); !ok || v.Value != 14 {
+				t.Errorf("goroutine %d: expected 14, got %s", i, got)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestFrozenEnvironment(t *testing.T) {
+	global := object.NewEnvironment()
+	eval.Eval(expectParse(t, "let x = 1"), global)
+	global.Freeze()
+
+	got := eval.Eval(expectParse(t, "let x = 2"), global)
+	expectErrorMessage(t, got, "cannot bind 'x': environment is frozen")
+
+	// scopes derived from a frozen environment may shadow its bindings
+	got = eval.Eval(expectParse(t, "let x = 2; x"), global.NewScope())
+	expectLiteral(t, got, 2)
+	expectLiteral(t, eval.Eval(expectParse(t, "x"), global), 1)
+}
+
+func TestConcurrentHashAssignment(t *testing.T) {
+	global := object.NewEnvironment()
+	eval.Eval(expectParse(t, "let h = {}"), global)
+	global.Freeze() // the binding is frozen, the hash it points to is not
+
+	var wg sync.WaitGroup
+	for i := 0; i < 32; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			input := fmt.Sprintf("h[%d] = %d; h[%d]", i, i*i, i)
+			got := eval.Eval(expectParse(t, input), global.NewScope())
+			if v, ok := got.(*object.Integer); !ok || v.Value != int64(i*i) {
+				t.Errorf("goroutine %d: expected %d, got %s", i, i*i, got)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	h, _ := global.Get("h")
+	if n := h.(*object.Hash).Len(); n != 32 {
+		t.Fatalf("expected 32 keys, got %d", n)
+	}
+}
@@ -1,10 +1,12 @@
 package eval
 
 import (
+	"errors"
 	"io"
 
 	"github.com/kvalv/monkey/ast"
 	"github.com/kvalv/monkey/object"
+	"github.com/kvalv/monkey/resolver"
 	"github.com/kvalv/monkey/tracer"
 )
 
@@ -17,10 +19,13 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		return evalLetStatement(n, env)
 	case *ast.Program:
 		defer trace("evalStatements")(nil)
-		return evalStatements(n.Statements, env)
+		return evalProgram(n, env)
 	case *ast.BlockStatement:
 		defer trace("evalBlockStatement")(nil)
 		return evalBlockStatement(n.Statements, env)
+	case *ast.FunctionDeclaration:
+		defer trace("evalFunctionDeclaration")(nil)
+		return evalFunctionDeclaration(n, env)
 	case *ast.ExpressionStatement:
 		defer trace("evalExpressionStatement")(nil)
 		return Eval(n.Expr, env)
@@ -29,7 +34,7 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		return evalIfExpression(n, env)
 	case *ast.ReturnExpression:
 		defer trace("evalReturnExpression")(nil)
-		return &object.Return{Object: Eval(n.Value, env)}
+		return returnValue(Eval(n.Value, env))
 	case *ast.PrefixExpression:
 		defer trace("evalPrefixExpression")(nil)
 		return evalPrefixExpression(n, env)
@@ -66,14 +71,75 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 	case *ast.HashLiteral:
 		defer trace("evalHashLiteral")(nil)
 		return evalHashLiteral(n, env)
+	case *ast.ArrayComprehension:
+		defer trace("evalArrayComprehension")(nil)
+		return evalArrayComprehension(n, env)
+	case *ast.HashComprehension:
+		defer trace("evalHashComprehension")(nil)
+		return evalHashComprehension(n, env)
+	case *ast.ThrowExpression:
+		defer trace("evalThrowExpression")(nil)
+		return evalThrowExpression(n, env)
+	case *ast.TryExpression:
+		defer trace("evalTryExpression")(nil)
+		return evalTryExpression(n, env)
+	case *ast.ExportStatement:
+		defer trace("evalExportStatement")(nil)
+		return Eval(n.Stmt, env)
+	case *ast.StructDeclaration:
+		defer trace("evalStructDeclaration")(nil)
+		return evalStructDeclaration(n, env)
+	case *ast.EnumDeclaration:
+		defer trace("evalEnumDeclaration")(nil)
+		return evalEnumDeclaration(n, env)
+	case *ast.FieldExpression:
+		defer trace("evalFieldExpression")(nil)
+		return evalFieldExpression(n, env)
+	case *ast.MacroLiteral:
+		return at(object.NewError(object.RuntimeError, "macros can only be defined by a top-level let"), n.Pos)
+	case *ast.ImportExpression:
+		defer trace("evalImportExpression")(nil)
+		return evalImportExpression(n, env)
+	case *ast.MatchExpression:
+		defer trace("evalMatchExpression")(nil)
+		return evalMatchExpression(n, env)
+	case *ast.Spread:
+		return at(object.NewError(object.TypeError, "spread is only allowed in array literals and call arguments"), n.Pos)
 	case *ast.AssignExpression:
 		defer trace("evalAssignExpression")(nil)
 		return evalAssignExpression(n, env)
 	}
-	return object.Errorf("unable to evaluate node of type %T", node)
+	return object.NewError(object.RuntimeError, "unable to evaluate node of type %T", node)
+}
+
+// evalProgram resolves the names in a copy of prog, unless that has been done
+// already, and evaluates it.
+func evalProgram(prog *ast.Program, env *object.Environment) object.Object {
+	if !prog.Resolved {
+		globals := func(name string) resolver.Global {
+			if obj, ok := env.Get(name); ok {
+				if _, ok := variantOf(obj); ok {
+					return resolver.Variant
+				}
+				return resolver.Defined
+			}
+			if _, ok := builtin[name]; ok {
+				return resolver.Defined
+			}
+			return resolver.Undefined
+		}
+		var errs []error
+		if prog, errs = resolver.Resolve(prog, globals); len(errs) > 0 {
+			return object.NewError(object.NameError, "%s", errors.Join(errs...))
+		}
+	}
+	return evalStatements(prog.Statements, env)
 }
 
 func evalStatements(stmts []ast.Statement, env *object.Environment) object.Object {
+	if err := hoist(stmts, env); err != nil {
+		return err
+	}
 	var res object.Object
 	for _, s := range stmts {
 		res = Eval(s, env)
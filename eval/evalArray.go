@@ -6,13 +6,12 @@ import (
 )
 
 func evalArray(arr *ast.Array, env *object.Environment) object.Object {
-	out := &object.Array{}
+	var elems []object.Object
 	for _, elem := range arr.Elems {
-		res := Eval(elem, env)
-		if object.IsError(res) {
-			return res
+		var err object.Object
+		if elems, err = evalElement(elems, elem, env); err != nil {
+			return err
 		}
-		out.Elems = append(out.Elems, res)
 	}
-	return out
+	return object.NewArray(elems...)
 }
@@ -6,17 +6,33 @@ import (
 )
 
 func evalArrayIndex(arr *ast.ArrayIndex, env *object.Environment) object.Object {
+	var obj object.Object
+	if arr.Optional {
+		// the index is not evaluated if there is nothing to index
+		if obj = Eval(arr.Array, env); object.IsError(obj) || obj == object.NULL {
+			return obj
+		}
+	}
 
 	indexObj := Eval(arr.Index, env)
 	if object.IsError(indexObj) {
 		return indexObj
 	}
 
-	obj := Eval(arr.Array, env)
+	if obj == nil {
+		obj = Eval(arr.Array, env)
+	}
 	if object.IsError(obj) {
 		return obj
 	}
+	if res, ok := overloadIndex(arr, obj, indexObj, env); ok {
+		return at(res, arr.Pos)
+	}
+	return at(Index(obj, indexObj), arr.Pos)
+}
 
+// Index looks up indexObj in an array or hash.
+func Index(obj, indexObj object.Object) object.Object {
 	// we're either dealing with arrays or indexes. Let's check arrays first
 	if arrayObj, ok := obj.(*object.Array); ok {
 		intIndex, ok := indexObj.(*object.Integer)
@@ -24,25 +40,33 @@ func evalArrayIndex(arr *ast.ArrayIndex, env *object.Environment) object.Object
 			return object.ErrorExpected(object.INTEGER_OBJ)
 		}
 		n := int(intIndex.Value)
-		if n >= len(arrayObj.Elems) {
-			return object.Errorf("List index out of range: %d > %d", n, len(arrayObj.Elems))
+		if n >= arrayObj.Len() {
+			return object.NewError(object.IndexError, "List index out of range: %d > %d", n, arrayObj.Len())
 		}
 		if n < 0 {
-			return object.Errorf("negative indices not allowed")
+			return object.NewError(object.IndexError, "negative indices not allowed")
 		}
-		return arrayObj.Elems[n]
+		return arrayObj.At(n)
 	}
 
 	// Is it a hash?
 	if hashObj, ok := obj.(*object.Hash); ok {
-		pair, ok := hashObj.Pairs[computeStringHash(indexObj)]
+		value, ok := hashObj.Get(indexObj)
 		if !ok {
 			return object.NULL
 		}
-		return pair.Value
+		return value
+	}
+
+	if caught, ok := obj.(*object.ErrorValue); ok {
+		return errorField(caught, indexObj)
+	}
+
+	if m, ok := obj.(*object.Module); ok {
+		return moduleExport(m, indexObj)
 	}
 
-	return object.Errorf("indexing is only supported for arrays or hashes")
+	return object.NewError(object.TypeError, "indexing is only supported for arrays or hashes")
 }
 
 func evalTo[T object.Object](node ast.Expression, env *object.Environment) (T, *object.Error) {
@@ -55,7 +79,7 @@ func evalTo[T object.Object](node ast.Expression, env *object.Environment) (T, *
 
 	parsed, ok := got.(T)
 	if !ok {
-		e := object.Errorf("object type mismatch")
+		e := object.NewError(object.TypeError, "object type mismatch")
 		return empty, e
 	}
 
@@ -6,21 +6,40 @@ import (
 )
 
 func evalAssignExpression(expr *ast.AssignExpression, env *object.Environment) object.Object {
+	if fe, ok := expr.Lhs.(*ast.FieldExpression); ok {
+		return evalFieldAssignment(fe, expr.Rhs, env)
+	}
 	ai, ok := expr.Lhs.(*ast.ArrayIndex)
 	if !ok {
-		return object.Errorf("not implemented")
+		return object.NewError(object.RuntimeError, "not implemented")
 	}
 	ident, ok := ai.Array.(*ast.Identifier)
 	if !ok {
-		return object.Errorf("only support identifiers")
+		return object.NewError(object.RuntimeError, "only support identifiers")
+	}
+	arr := evalIdentifier(ident, env)
+	if object.IsError(arr) {
+		return arr
 	}
-	arr, ok := env.Get(ident.String())
+	hm, ok := arr.(*object.Hash)
 	if !ok {
-		return object.Errorf("identifier '%s' not defined", arr.String())
+		return at(object.NewError(object.TypeError, "assignment is only supported for hashes, got %s", arr.Type()), expr.Pos)
+	}
+	if hm.Frozen() {
+		return at(frozenError(hm), expr.Pos)
+	}
+	key := Eval(ai.Index, env)
+	if object.IsError(key) {
+		return key
+	}
+	value := Eval(expr.Rhs, env)
+	if object.IsError(value) {
+		return value
+	}
+	if err := hm.Set(key, value); err != nil {
+		// hm may have been frozen while the key and value were evaluated
+		return at(frozenError(hm), expr.Pos)
 	}
-	hm := arr.(*object.Hash)
-
-	hm.Set(Eval(ai.Index, env), Eval(expr.Rhs, env))
 
 	return object.NULL
 }
@@ -6,10 +6,13 @@ import (
 )
 
 func evalBlockStatement(stmts []ast.Statement, env *object.Environment) object.Object {
-	var res object.Object
+	if err := hoist(stmts, env); err != nil {
+		return err
+	}
+	var res object.Object = object.NULL
 	for _, s := range stmts {
 		res = Eval(s, env)
-		if res.Type() == object.RETURN_OBJ {
+		if res.Type() == object.RETURN_OBJ || object.IsError(res) {
 			return res
 		}
 	}
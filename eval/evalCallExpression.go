@@ -1,58 +1,268 @@
 package eval
 
 import (
+	"context"
+	"sort"
+
 	"github.com/kvalv/monkey/ast"
 	"github.com/kvalv/monkey/object"
 )
 
 func evalCallExpression(node *ast.CallExpression, env *object.Environment) object.Object {
+	if isQuote(node, env) {
+		return evalQuote(node, env)
+	}
 	obj := Eval(node.Function, env)
-	switch fn := obj.(type) {
-	case *object.Error:
+	if object.IsError(obj) || node.Optional && obj == object.NULL {
 		return obj
+	}
+	args, kwargs, err := evalArguments(node.Params, env)
+	if err != nil {
+		return err
+	}
+	frame := callFrame(node, obj)
+	return at(callFunction(obj, args, kwargs, env.WithStack(env.Stack().Push(frame))), frame.Pos)
+}
+
+// callFrame describes a call of fn for the call stack. The function is named
+// after the binding it is called through, if any, and otherwise after its
+// declaration.
+func callFrame(node *ast.CallExpression, fn object.Object) object.Frame {
+	if id, ok := node.Function.(*ast.Identifier); ok {
+		return object.Frame{Function: id.Value, Span: id.Span, Pos: id.Pos}
+	}
+	if f, ok := fn.(*object.Function); ok && f.Name != "" {
+		return object.Frame{Function: f.Name, Span: node.Span, Pos: node.Pos}
+	}
+	return object.Frame{Function: "<anonymous>", Span: node.Span, Pos: node.Pos}
+}
+
+// withStack records the call stack of env on an error that does not have one
+// yet. The error is copied, as it may be shared.
+func withStack(obj object.Object, env *object.Environment) object.Object {
+	err, ok := obj.(*object.Error)
+	if !ok || err.Stack != nil || env.Stack() == nil {
+		return obj
+	}
+	cp := *err
+	cp.Stack = env.Stack().Frames()
+	return &cp
+}
+
+// applyFunction calls fn with already evaluated arguments. env is the
+// environment of the caller; its context is passed on to the callee, and its
+// call stack, which should include this call, is recorded on errors.
+func applyFunction(obj object.Object, args []object.Object, env *object.Environment) object.Object {
+	return callFunction(obj, args, nil, env)
+}
+
+// callFunction is applyFunction with arguments passed by name as well.
+func callFunction(obj object.Object, args []object.Object, kwargs map[string]object.Object, env *object.Environment) object.Object {
+	if err := env.Context().Err(); err != nil {
+		return withStack(object.NewError(object.CancelledError, "evaluation cancelled: %v", err), env)
+	}
+	switch fn := obj.(type) {
 	case *object.Function:
-		return evalFunctionCallExpression(fn, node.Params, env)
+		if fn.Generator {
+			return newGenerator(fn, args, kwargs, env)
+		}
+		return evalFunctionCall(fn, args, kwargs, env)
 	case *object.Builtin:
-		return evalBuiltinCallExpression(fn, node.Params, env)
+		if len(kwargs) > 0 {
+			return withStack(object.NewError(object.ArgumentError, "builtin functions do not accept keyword arguments"), env)
+		}
+		return withStack(callBuiltin(fn, args, env), env)
+	case *object.StructType:
+		return withStack(construct(fn, args, kwargs), env)
+	case *object.Variant:
+		values, err := fieldValues(fn.Name, fn.Fields, args, kwargs)
+		if err != nil {
+			return withStack(err, env)
+		}
+		return &object.EnumValue{Variant: fn, Values: values}
 	default:
-		return object.Errorf("evalCallExpression: unknown type %T", obj)
+		if cb, ok := env.Context().Value(callbackKey).(Callback); ok && len(kwargs) == 0 {
+			if res, ok := cb(obj, args, env); ok {
+				return withStack(res, env)
+			}
+		}
+		return withStack(object.NewError(object.TypeError, "evalCallExpression: unknown type %T", obj), env)
+	}
+}
+
+// Callback calls the functions that the evaluator cannot call itself, such
+// as the closures of the vm, when a builtin like spawn or map_iter is handed
+// one. It reports false if fn is not one of them.
+type Callback func(fn object.Object, args []object.Object, env *object.Environment) (object.Object, bool)
+
+// WithCallback returns a context under which builtins call functions they
+// do not know through cb.
+func WithCallback(ctx context.Context, cb Callback) context.Context {
+	return context.WithValue(ctx, callbackKey, cb)
+}
+
+// callBuiltin calls a builtin, turning a panic into an error so that it can
+// be caught like any other.
+func callBuiltin(fn *object.Builtin, args []object.Object, env *object.Environment) (res object.Object) {
+	defer func() {
+		if r := recover(); r != nil {
+			res = object.NewError(object.RuntimeError, "builtin failed: %v", r)
+		}
+	}()
+	return fn.Fn(env, args...)
+}
+
+func evalFunctionCall(fn *object.Function, args []object.Object, kwargs map[string]object.Object, env *object.Environment) object.Object {
+	for {
+		var scoped *object.Environment
+		if fn.Resolved {
+			scoped = fn.Env.NewFrame(fn.Locals).WithCaller(env)
+		} else {
+			scoped = fn.Env.NewScope().WithCaller(env)
+		}
+		if err := bindArguments(fn, args, kwargs, scoped); err != nil {
+			return withStack(err, env)
+		}
+		res := evalBody(fn.Body.Statements, scoped, true)
+		if ret, ok := res.(*object.Return); ok {
+			// a return only unwinds the function it appears in
+			res = ret.Object
+		}
+		tc, ok := res.(*tailCall)
+		if !ok {
+			return withStack(res, env)
+		}
+		// make the tail call without growing the stack; it replaces this
+		// call on the call stack as well
+		caller := env.Stack()
+		if caller != nil {
+			caller = caller.Caller
+		}
+		env = env.WithStack(caller.Push(tc.frame))
+		next, ok := tc.fn.(*object.Function)
+		if !ok || next.Generator {
+			return callFunction(tc.fn, tc.args, tc.kwargs, env)
+		}
+		if err := env.Context().Err(); err != nil {
+			return withStack(object.NewError(object.CancelledError, "evaluation cancelled: %v", err), env)
+		}
+		fn, args, kwargs = next, tc.args, tc.kwargs
 	}
 }
 
-func evalFunctionCallExpression(fn *object.Function, exprs []ast.Expression, env *object.Environment) object.Object {
-	if exp, got := len(fn.Params), len(exprs); exp != got {
-		return object.Errorf("Error invoking function: expected %d arguments but received %d", exp, got)
+// bindArguments binds the parameters of fn in scoped. Arguments are matched
+// to parameters by position, then by name; the parameters that are left get
+// their default value, and any positional arguments that are left over go to
+// the rest parameter.
+func bindArguments(fn *object.Function, args []object.Object, kwargs map[string]object.Object, scoped *object.Environment) object.Object {
+	if fn.Receiver != nil {
+		bind(fn.Receiver, fn.Self, scoped)
 	}
-	scoped := fn.Env.NewScope()
-	params, ok := evalCallParams(exprs, env)
-	if !ok {
-		return params[0]
+	n := len(fn.Params)
+	if len(args) > n && fn.Rest == nil {
+		return object.NewError(object.ArgumentError, "Error invoking function: expected %d arguments but received %d", n, len(args))
 	}
-	for i, p := range fn.Params {
-		value := params[i]
+	bound := make([]bool, n)
+	for i := 0; i < n && i < len(args); i++ {
+		bind(&fn.Params[i], args[i], scoped)
+		bound[i] = true
+	}
+	if fn.Rest != nil {
+		rest := &object.Array{}
+		if len(args) > n {
+			rest = object.NewArray(args[n:]...)
+		}
+		bind(fn.Rest, rest, scoped)
+	}
+	names := make([]string, 0, len(kwargs))
+	for name := range kwargs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		i := paramIndex(fn, name)
+		if i < 0 {
+			return object.NewError(object.ArgumentError, "Error invoking function: unexpected keyword argument '%s'", name)
+		}
+		if bound[i] {
+			return object.NewError(object.ArgumentError, "Error invoking function: multiple values for parameter '%s'", name)
+		}
+		bind(&fn.Params[i], kwargs[name], scoped)
+		bound[i] = true
+	}
+	for i := range fn.Params {
+		if bound[i] {
+			continue
+		}
+		if fn.Defaults == nil || fn.Defaults[i] == nil {
+			return object.NewError(object.ArgumentError, "Error invoking function: missing argument for parameter '%s'", fn.Params[i].Value)
+		}
+		value := Eval(fn.Defaults[i], scoped)
 		if object.IsError(value) {
 			return value
 		}
-		scoped.Set(p.Literal, value)
+		bind(&fn.Params[i], value, scoped)
 	}
-	return Eval(fn.Body, scoped)
+	return nil
 }
-func evalBuiltinCallExpression(fn *object.Builtin, exprs []ast.Expression, env *object.Environment) object.Object {
-	params, ok := evalCallParams(exprs, env)
-	if !ok {
-		return params[0]
+
+func paramIndex(fn *object.Function, name string) int {
+	for i, p := range fn.Params {
+		if p.Value == name {
+			return i
+		}
 	}
-	return fn.Fn(params...)
+	return -1
 }
 
-func evalCallParams(params []ast.Expression, env *object.Environment) ([]object.Object, bool) {
-	var res []object.Object
+// evalArguments evaluates the arguments of a call, expanding spread arrays
+// into positional arguments and collecting keyword arguments by name.
+func evalArguments(params []ast.Expression, env *object.Environment) ([]object.Object, map[string]object.Object, object.Object) {
+	var args []object.Object
+	var kwargs map[string]object.Object
 	for _, p := range params {
-		value := Eval(p, env)
+		kw, ok := p.(*ast.KeywordArgument)
+		if !ok {
+			var err object.Object
+			if args, err = evalElement(args, p, env); err != nil {
+				return nil, nil, err
+			}
+			continue
+		}
+		value := Eval(kw.Value, env)
+		if object.IsError(value) {
+			return nil, nil, value
+		}
+		if kwargs == nil {
+			kwargs = make(map[string]object.Object)
+		}
+		if _, ok := kwargs[kw.Name.Value]; ok {
+			return nil, nil, at(object.NewError(object.ArgumentError, "keyword argument '%s' repeated", kw.Name.Value), kw.Pos)
+		}
+		kwargs[kw.Name.Value] = value
+	}
+	return args, kwargs, nil
+}
+
+// evalElement evaluates an element of an array literal or an argument list
+// and appends it to out. A spread element appends every element of the
+// array it evaluates to.
+func evalElement(out []object.Object, expr ast.Expression, env *object.Environment) ([]object.Object, object.Object) {
+	spread, ok := expr.(*ast.Spread)
+	if !ok {
+		value := Eval(expr, env)
 		if object.IsError(value) {
-			return []object.Object{value}, false
+			return nil, value
 		}
-		res = append(res, value)
+		return append(out, value), nil
+	}
+	value := Eval(spread.Value, env)
+	if object.IsError(value) {
+		return nil, value
+	}
+	arr, ok := value.(*object.Array)
+	if !ok {
+		return nil, at(object.NewError(object.TypeError, "cannot spread %s, expected ARRAY", value.Type()), spread.Pos)
 	}
-	return res, true
+	return append(out, arr.Elems()...), nil
 }
@@ -0,0 +1,87 @@
+package eval
+
+import (
+	"github.com/kvalv/monkey/ast"
+	"github.com/kvalv/monkey/object"
+)
+
+func evalArrayComprehension(node *ast.ArrayComprehension, env *object.Environment) object.Object {
+	var elems []object.Object
+	err := comprehend(node.Clauses, env, func(scope *object.Environment) object.Object {
+		var err object.Object
+		elems, err = evalElement(elems, node.Value, scope)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return object.NewArray(elems...)
+}
+
+func evalHashComprehension(node *ast.HashComprehension, env *object.Environment) object.Object {
+	hash := &object.Hash{}
+	err := comprehend(node.Clauses, env, func(scope *object.Environment) object.Object {
+		key := Eval(node.Key, scope)
+		if object.IsError(key) {
+			return key
+		}
+		value := Eval(node.Value, scope)
+		if object.IsError(value) {
+			return value
+		}
+		hash.Set(key, value)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return hash
+}
+
+// comprehend calls yield for every combination of values that clauses
+// produce. A for clause binds its target in a new scope for each of its
+// values, so that functions made in the comprehension capture the values of
+// their own iteration; the clauses after it are evaluated in that scope.
+func comprehend(clauses []ast.ComprehensionClause, env *object.Environment, yield func(*object.Environment) object.Object) object.Object {
+	if len(clauses) == 0 {
+		return yield(env)
+	}
+	c := clauses[0]
+	if c.Cond != nil {
+		cond := Eval(c.Cond, env)
+		if object.IsError(cond) {
+			return cond
+		}
+		if !isTruthy(cond) {
+			return nil
+		}
+		return comprehend(clauses[1:], env, yield)
+	}
+	iterable := Eval(c.Iterable, env)
+	if object.IsError(iterable) {
+		return iterable
+	}
+	it, err := iterate(iterable, env)
+	if err != nil {
+		return at(err, c.Pos)
+	}
+	for {
+		if err := env.Context().Err(); err != nil {
+			return withStack(object.NewError(object.CancelledError, "evaluation cancelled: %v", err), env)
+		}
+		value, ok, err := it.Next(env)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return nil
+		}
+		scope := env.NewFrame(c.Locals)
+		if err := destructure(c.Target, value, scope); err != nil {
+			return err
+		}
+		if err := comprehend(clauses[1:], scope, yield); err != nil {
+			return err
+		}
+	}
+}
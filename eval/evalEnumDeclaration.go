@@ -0,0 +1,145 @@
+package eval
+
+import (
+	"github.com/kvalv/monkey/ast"
+	"github.com/kvalv/monkey/object"
+)
+
+// evalEnumDeclaration binds the enum and each of its variants.
+func evalEnumDeclaration(node *ast.EnumDeclaration, env *object.Environment) object.Object {
+	t := &object.EnumType{Name: node.Name.Value}
+	if err := bind(node.Name, t, env); err != nil {
+		return at(bindError(node.Name.Value, err), node.Pos)
+	}
+	for _, v := range node.Variants {
+		variant := &object.Variant{Enum: t, Name: v.Name.Value}
+		for _, f := range v.Fields {
+			variant.Fields = append(variant.Fields, f.Value)
+		}
+		var value object.Object = variant
+		if len(variant.Fields) == 0 {
+			variant.Value = &object.EnumValue{Variant: variant}
+			value = variant.Value
+		}
+		t.Variants = append(t.Variants, variant)
+		if err := bind(v.Name, value, env); err != nil {
+			return at(bindError(v.Name.Value, err), v.Name.Pos)
+		}
+	}
+	return t
+}
+
+// variantOf returns the variant that a variant value, or the constructor of
+// one, belongs to.
+func variantOf(obj object.Object) (*object.Variant, bool) {
+	switch o := obj.(type) {
+	case *object.Variant:
+		return o, true
+	case *object.EnumValue:
+		if o.Variant.Value == o {
+			return o.Variant, true
+		}
+	}
+	return nil, false
+}
+
+// lookupVariant finds the variant a pattern refers to.
+func lookupVariant(p *ast.VariantPattern, env *object.Environment) (*object.Variant, object.Object) {
+	if p.Enum != nil {
+		obj := evalIdentifier(p.Enum, env)
+		if object.IsError(obj) {
+			return nil, obj
+		}
+		t, ok := obj.(*object.EnumType)
+		if !ok {
+			return nil, at(object.NewError(object.TypeError, "%s is not an enum, got %s", p.Enum.Value, obj.Type()), p.Enum.Pos)
+		}
+		v, ok := t.Variant(p.Name.Value)
+		if !ok {
+			return nil, at(object.NewError(object.NameError, "enum %s has no variant '%s'", t.Name, p.Name.Value), p.Name.Pos)
+		}
+		return v, nil
+	}
+	obj := evalIdentifier(p.Name, env)
+	if object.IsError(obj) {
+		return nil, obj
+	}
+	v, ok := variantOf(obj)
+	if !ok {
+		return nil, at(object.NewError(object.TypeError, "%s is not a variant, got %s", p.Name.Value, obj.Type()), p.Name.Pos)
+	}
+	return v, nil
+}
+
+// matchVariant matches a value of the variant of p whose fields match the
+// patterns of p.
+func matchVariant(p *ast.VariantPattern, value object.Object, env *object.Environment) (*object.Error, object.Object) {
+	v, err := lookupVariant(p, env)
+	if err != nil {
+		return nil, err
+	}
+	if p.Fields != nil && len(p.Fields) != len(v.Fields) {
+		return nil, at(object.NewError(object.ArgumentError, "pattern %s has %d fields but %s has %d", p, len(p.Fields), v, len(v.Fields)), p.Pos)
+	}
+	e, ok := value.(*object.EnumValue)
+	if !ok || e.Variant != v {
+		return mismatchf(p.Pos, object.MatchError, "%s does not match %s", describe(value), p), nil
+	}
+	for i, field := range p.Fields {
+		if mismatch, err := matchPattern(field, e.Values[i], env); mismatch != nil || err != nil {
+			return mismatch, err
+		}
+	}
+	return nil, nil
+}
+
+// checkExhaustive returns an error if the arms of a match on variants of an
+// enum do not cover every variant. An arm covers a variant if its pattern
+// matches every value of the variant and it has no guard; an identifier or
+// wildcard covers them all. Matches without variant patterns are not
+// checked.
+func checkExhaustive(node *ast.MatchExpression, env *object.Environment) object.Object {
+	var enum *object.EnumType
+	covered := make(map[*object.Variant]bool)
+	// the variants are looked up from the scope of an arm, which is one
+	// below env
+	env = env.NewScope()
+	for _, arm := range node.Arms {
+		switch p := arm.Pattern.(type) {
+		case *ast.Identifier, *ast.WildcardPattern:
+			if arm.Guard == nil {
+				return nil
+			}
+		case *ast.VariantPattern:
+			v, err := lookupVariant(p, env)
+			if err != nil {
+				return err
+			}
+			enum = v.Enum
+			if arm.Guard == nil && irrefutable(p.Fields) {
+				covered[v] = true
+			}
+		}
+	}
+	if enum == nil {
+		return nil
+	}
+	for _, v := range enum.Variants {
+		if !covered[v] {
+			return at(object.NewError(object.MatchError, "match on %s is not exhaustive: %s is not covered", enum.Name, v.Name), node.Pos)
+		}
+	}
+	return nil
+}
+
+// irrefutable reports whether every one of pats matches any value.
+func irrefutable(pats []ast.Pattern) bool {
+	for _, pat := range pats {
+		switch pat.(type) {
+		case *ast.Identifier, *ast.WildcardPattern:
+		default:
+			return false
+		}
+	}
+	return true
+}
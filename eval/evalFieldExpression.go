@@ -0,0 +1,74 @@
+package eval
+
+import (
+	"github.com/kvalv/monkey/ast"
+	"github.com/kvalv/monkey/object"
+)
+
+func evalFieldExpression(node *ast.FieldExpression, env *object.Environment) object.Object {
+	obj := Eval(node.Value, env)
+	if object.IsError(obj) || node.Optional && obj == object.NULL {
+		return obj
+	}
+	return at(field(obj, node.Field.Value), node.Field.Pos)
+}
+
+// field looks up a field of a struct, or one of its methods bound to it.
+func field(obj object.Object, name string) object.Object {
+	switch o := obj.(type) {
+	case *object.Struct:
+		if value, ok := o.Get(name); ok {
+			return value
+		}
+		if m, ok := o.Def.Methods[name]; ok {
+			bound := *m
+			bound.Self = o
+			return &bound
+		}
+		return object.NewError(object.NameError, "%s has no field '%s'", o.Def.Name, name)
+	case *object.EnumValue:
+		if i := o.Variant.Field(name); i >= 0 {
+			return o.Values[i]
+		}
+		return object.NewError(object.NameError, "%s has no field '%s'", o.Variant.Name, name)
+	case *object.EnumType:
+		v, ok := o.Variant(name)
+		if !ok {
+			return object.NewError(object.NameError, "enum %s has no variant '%s'", o.Name, name)
+		}
+		if v.Value != nil {
+			return v.Value
+		}
+		return v
+	case *object.Module:
+		return moduleExport(o, &object.String{Value: name})
+	}
+	return object.NewError(object.TypeError, "%s has no fields", obj.Type())
+}
+
+// evalFieldAssignment assigns to a field of a struct.
+func evalFieldAssignment(node *ast.FieldExpression, rhs ast.Expression, env *object.Environment) object.Object {
+	obj := Eval(node.Value, env)
+	if object.IsError(obj) {
+		return obj
+	}
+	s, ok := obj.(*object.Struct)
+	if !ok {
+		return at(object.NewError(object.TypeError, "cannot assign to a field of %s", obj.Type()), node.Field.Pos)
+	}
+	if s.Def.Field(node.Field.Value) < 0 {
+		return at(object.NewError(object.NameError, "%s has no field '%s'", s.Def.Name, node.Field.Value), node.Field.Pos)
+	}
+	if s.Frozen() {
+		return at(frozenError(s), node.Field.Pos)
+	}
+	value := Eval(rhs, env)
+	if object.IsError(value) {
+		return value
+	}
+	if err := s.Set(node.Field.Value, value); err != nil {
+		// s may have been frozen while the value was evaluated
+		return at(frozenError(s), node.Field.Pos)
+	}
+	return object.NULL
+}
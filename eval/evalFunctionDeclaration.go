@@ -0,0 +1,31 @@
+package eval
+
+import (
+	"github.com/kvalv/monkey/ast"
+	"github.com/kvalv/monkey/object"
+)
+
+// hoist binds the functions declared in stmts before any of the statements
+// run, so that they can be called from anywhere in the block, and functions
+// declared next to each other can call each other.
+func hoist(stmts []ast.Statement, env *object.Environment) object.Object {
+	for _, s := range stmts {
+		decl := ast.Declaration(s)
+		if decl == nil {
+			continue
+		}
+		fn := evalFunctionLiteral(decl.Fn, env)
+		if object.IsError(fn) {
+			return fn
+		}
+		if err := bind(decl.Name, fn, env); err != nil {
+			return bindError(decl.Name.Value, err)
+		}
+	}
+	return nil
+}
+
+// evalFunctionDeclaration evaluates to the function bound by hoist.
+func evalFunctionDeclaration(node *ast.FunctionDeclaration, env *object.Environment) object.Object {
+	return evalIdentifier(node.Name, env)
+}
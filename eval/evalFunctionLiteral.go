@@ -9,15 +9,27 @@ func evalFunctionLiteral(node *ast.FunctionLiteral, env *object.Environment) obj
 	paramNames := make(map[string]struct{})
 	for _, p := range node.Params {
 		if _, ok := paramNames[p.Literal]; ok {
-			return object.Errorf("repeated argument %q", p.Literal)
+			return object.NewError(object.NameError, "repeated argument %q", p.Literal)
 		}
 		paramNames[p.Literal] = struct{}{}
 	}
+	if node.Rest != nil {
+		if _, ok := paramNames[node.Rest.Literal]; ok {
+			return object.NewError(object.NameError, "repeated argument %q", node.Rest.Literal)
+		}
+	}
 
 	fn := &object.Function{
-		Env:    env,
-		Params: node.Params,
-		Body:   node.Body,
+		Name:      node.Name,
+		Env:       env,
+		Params:    node.Params,
+		Defaults:  node.Defaults,
+		Rest:      node.Rest,
+		Receiver:  node.Receiver,
+		Generator: node.Generator,
+		Body:      node.Body,
+		Resolved:  node.Resolved,
+		Locals:    node.Locals,
 	}
 	return fn
 }
@@ -6,12 +6,26 @@ import (
 )
 
 func evalIdentifier(id *ast.Identifier, env *object.Environment) object.Object {
+	if b := id.Binding; b != nil {
+		if value := env.GetSlot(b.Depth, b.Slot); value != nil {
+			return value
+		}
+		return at(object.NewError(object.NameError, "identifier '%s' not defined", id.Literal), id.Pos)
+	}
 	if value, ok := env.Get(id.Literal); ok {
 		return value
 	}
 	if value, ok := builtin[id.Literal]; ok {
 		return value
 	}
-	return object.Errorf("identifier '%s' not defined", id.Literal)
+	return at(object.NewError(object.NameError, "identifier '%s' not defined", id.Literal), id.Pos)
+}
 
+// bind sets the variable named by id, which is either a slot in the current
+// frame or a name in env.
+func bind(id *ast.Identifier, value object.Object, env *object.Environment) error {
+	if b := id.Binding; b != nil {
+		return env.SetSlot(b.Slot, value)
+	}
+	return env.Set(id.Literal, value)
 }
@@ -0,0 +1,37 @@
+package eval
+
+import (
+	"github.com/kvalv/monkey/ast"
+	"github.com/kvalv/monkey/module"
+	"github.com/kvalv/monkey/object"
+)
+
+func evalImportExpression(node *ast.ImportExpression, env *object.Environment) object.Object {
+	mods, ok := env.Context().Value(modulesKey).(*modules)
+	if !ok {
+		return at(object.NewError(object.ImportError, "import requires a module loader; see WithLoader"), node.Pos)
+	}
+	chain, _ := env.Context().Value(importKey).(*importChain)
+	from := ""
+	if chain != nil {
+		from = chain.path
+	}
+	path, err := module.Resolve(from, node.Path.Value)
+	if err != nil {
+		return at(object.NewError(object.ImportError, "import: %v", err), node.Pos)
+	}
+	return at(mods.load(path, chain, env), node.Pos)
+}
+
+// moduleExport looks up an exported binding of an imported module
+func moduleExport(m *object.Module, key object.Object) object.Object {
+	name, ok := key.(*object.String)
+	if !ok {
+		return object.ErrorExpected(object.STRING_OBJ)
+	}
+	value, ok := m.Exports[name.Value]
+	if !ok {
+		return object.NewError(object.NameError, "module %s does not export '%s'", m.Path, name.Value)
+	}
+	return value
+}
@@ -16,7 +16,7 @@ func evalStringInfixExpression(op string, left, right object.Object) object.Obje
 	case "==":
 		return nativeBoolToBoolean(a == b)
 	default:
-		return object.Errorf("unknown operator: STRING %s STRING", op)
+		return object.NewError(object.TypeError, "unknown operator: STRING %s STRING", op)
 	}
 }
 
@@ -31,35 +31,66 @@ func evalIntegerInfixExpression(op string, left, right object.Object) object.Obj
 	case "*":
 		return &object.Integer{Value: a * b}
 	case "/":
+		if b == 0 {
+			return object.NewError(object.ZeroDivisionError, "division by zero")
+		}
 		return &object.Integer{Value: a / b}
 	case ">":
-		return &object.Boolean{Value: a > b}
+		return nativeBoolToBoolean(a > b)
 	case "<":
-		return &object.Boolean{Value: a < b}
+		return nativeBoolToBoolean(a < b)
+	case "==":
+		return nativeBoolToBoolean(a == b)
+	case "!=":
+		return nativeBoolToBoolean(a != b)
 	default:
-		return object.Errorf("unknown operator: %s", op)
+		return object.NewError(object.TypeError, "unknown operator: %s", op)
 	}
 }
 
 func evalInfixExpression(node *ast.InfixExpression, env *object.Environment) object.Object {
 	lhs := Eval(node.Lhs, env)
+	if object.IsError(lhs) {
+		return lhs
+	}
+	if node.Op == "??" {
+		// the right operand is only evaluated if it is needed
+		if lhs != object.NULL {
+			return lhs
+		}
+		return Eval(node.Rhs, env)
+	}
 	rhs := Eval(node.Rhs, env)
+	if object.IsError(rhs) {
+		return rhs
+	}
+	if res, ok := overloadInfix(node, lhs, rhs, env); ok {
+		return at(res, node.Pos)
+	}
+	return at(Infix(node.Op, lhs, rhs), node.Pos)
+}
 
+// Infix applies a binary operator to two evaluated operands. It is exported so
+// that other backends, such as the bytecode vm, share the same semantics.
+func Infix(op string, lhs, rhs object.Object) object.Object {
 	if lhs.Type() != rhs.Type() {
-		return object.Errorf("type mismatch: %s %s %s", lhs.Type(), node.Op, rhs.Type())
+		return object.NewError(object.TypeError, "type mismatch: %s %s %s", lhs.Type(), op, rhs.Type())
 	}
 
 	switch {
 	case lhs.Type() == object.INTEGER_OBJ && rhs.Type() == object.INTEGER_OBJ:
-		return evalIntegerInfixExpression(node.Op, lhs, rhs)
+		return evalIntegerInfixExpression(op, lhs, rhs)
 	case lhs.Type() == object.STRING_OBJ && rhs.Type() == object.STRING_OBJ:
-		return evalStringInfixExpression(node.Op, lhs, rhs)
-	case node.Op == "==":
+		return evalStringInfixExpression(op, lhs, rhs)
+	case lhs.Type() == object.ENUM_OBJ && (op == "==" || op == "!="):
+		eq := enumEqual(lhs.(*object.EnumValue), rhs.(*object.EnumValue))
+		return nativeBoolToBoolean(eq == (op == "=="))
+	case op == "==":
 		return nativeBoolToBoolean(lhs == rhs)
-	case node.Op == "!=":
+	case op == "!=":
 		return nativeBoolToBoolean(lhs != rhs)
 	default:
-		return object.Errorf("unknown operator: %s %s %s", lhs.Type(), node.Op, rhs.Type())
+		return object.NewError(object.TypeError, "unknown operator: %s %s %s", lhs.Type(), op, rhs.Type())
 	}
 }
 func nativeBoolToBoolean(b bool) object.Object {
@@ -69,3 +100,18 @@ func nativeBoolToBoolean(b bool) object.Object {
 		return object.FALSE
 	}
 }
+
+// enumEqual reports whether a and b are the same variant with equal fields.
+// Fields are compared with ==.
+func enumEqual(a, b *object.EnumValue) bool {
+	if a.Variant != b.Variant {
+		return false
+	}
+	for i := range a.Values {
+		x, y := a.Values[i], b.Values[i]
+		if x.Type() != y.Type() || Infix("==", x, y) != object.TRUE {
+			return false
+		}
+	}
+	return true
+}
@@ -1,6 +1,8 @@
 package eval
 
 import (
+	"errors"
+
 	"github.com/kvalv/monkey/ast"
 	"github.com/kvalv/monkey/object"
 )
@@ -10,6 +12,48 @@ func evalLetStatement(node *ast.LetStatement, env *object.Environment) object.Ob
 	if object.IsError(value) {
 		return value
 	}
-	env.Set(node.Lhs.Literal, value)
+	if node.Pattern != nil {
+		if err := destructure(node.Pattern, value, env); err != nil {
+			return err
+		}
+		if node.Const {
+			for _, id := range patternIdents(node.Pattern) {
+				if id.Binding != nil {
+					continue
+				}
+				v, _ := env.Get(id.Value)
+				if err := env.SetConst(id.Value, v); err != nil {
+					return bindError(id.Value, err)
+				}
+			}
+		}
+		return value
+	}
+	set := bind
+	if node.Const {
+		set = bindConst
+	}
+	if err := set(node.Lhs, value, env); err != nil {
+		return bindError(node.Lhs.Literal, err)
+	}
 	return value
 }
+
+// bindError is the error for a failure to bind name. Binding a constant again
+// is a conflicting binding, so it is a NameError.
+func bindError(name string, err error) *object.Error {
+	kind := object.RuntimeError
+	if errors.Is(err, object.ErrConstant) {
+		kind = object.NameError
+	}
+	return object.NewError(kind, "cannot bind '%s': %v", name, err)
+}
+
+// bindConst binds id like bind, and makes a binding by name constant. A local
+// in a slot is constant already, since the resolver rejects binding it again.
+func bindConst(id *ast.Identifier, value object.Object, env *object.Environment) error {
+	if id.Binding != nil {
+		return bind(id, value, env)
+	}
+	return env.SetConst(id.Literal, value)
+}
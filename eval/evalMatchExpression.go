@@ -0,0 +1,46 @@
+package eval
+
+import (
+	"github.com/kvalv/monkey/ast"
+	"github.com/kvalv/monkey/object"
+)
+
+func evalMatchExpression(node *ast.MatchExpression, env *object.Environment) object.Object {
+	return evalMatch(node, env, func(body ast.Expression, scope *object.Environment) object.Object { return Eval(body, scope) })
+}
+
+// evalMatch evaluates the body of the first arm whose pattern matches the
+// value and whose guard, if any, is true. Each arm binds its pattern in a
+// new scope, which its guard and body are evaluated in. The body is
+// evaluated by eval, so that a body in tail position can be evaluated as
+// such.
+func evalMatch(node *ast.MatchExpression, env *object.Environment, eval func(ast.Expression, *object.Environment) object.Object) object.Object {
+	value := Eval(node.Value, env)
+	if object.IsError(value) {
+		return value
+	}
+	if err := checkExhaustive(node, env); err != nil {
+		return err
+	}
+	for _, arm := range node.Arms {
+		scope := env.NewFrame(arm.Locals)
+		mismatch, err := matchPattern(arm.Pattern, value, scope)
+		if err != nil {
+			return err
+		}
+		if mismatch != nil {
+			continue
+		}
+		if arm.Guard != nil {
+			guard := Eval(arm.Guard, scope)
+			if object.IsError(guard) {
+				return guard
+			}
+			if !isTruthy(guard) {
+				continue
+			}
+		}
+		return eval(arm.Body, scope)
+	}
+	return at(object.NewError(object.MatchError, "no arm of match matches %s", describe(value)), node.Pos)
+}
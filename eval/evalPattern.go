@@ -0,0 +1,162 @@
+package eval
+
+import (
+	"strconv"
+
+	"github.com/kvalv/monkey/ast"
+	"github.com/kvalv/monkey/object"
+	"github.com/kvalv/monkey/token"
+)
+
+// destructure binds the identifiers of pat to the corresponding parts of
+// value. It returns an error if value does not have the shape of pat.
+func destructure(pat ast.Pattern, value object.Object, env *object.Environment) object.Object {
+	mismatch, err := matchPattern(pat, value, env)
+	if err != nil {
+		return err
+	}
+	if mismatch != nil {
+		return mismatch
+	}
+	return nil
+}
+
+// matchPattern matches value against pat, binding the identifiers of pat as
+// it goes. If value does not have the shape of pat, mismatch says why; err
+// is set if evaluating a key or a default failed.
+func matchPattern(pat ast.Pattern, value object.Object, env *object.Environment) (mismatch *object.Error, err object.Object) {
+	switch p := pat.(type) {
+	case *ast.Identifier:
+		if err := bind(p, value, env); err != nil {
+			return nil, bindError(p.Value, err)
+		}
+		return nil, nil
+	case *ast.WildcardPattern:
+		return nil, nil
+	case *ast.LiteralPattern:
+		want := Eval(p.Value, env)
+		if object.IsError(want) {
+			return nil, want
+		}
+		if want.Type() != value.Type() || Infix("==", want, value) != object.TRUE {
+			return mismatchf(p.Pos, object.MatchError, "%s does not match %s", describe(value), describe(want)), nil
+		}
+		return nil, nil
+	case *ast.ArrayPattern:
+		return matchArray(p, value, env)
+	case *ast.HashPattern:
+		return matchHash(p, value, env)
+	case *ast.VariantPattern:
+		return matchVariant(p, value, env)
+	}
+	return nil, object.NewError(object.RuntimeError, "unable to match pattern of type %T", pat)
+}
+
+func matchArray(p *ast.ArrayPattern, value object.Object, env *object.Environment) (*object.Error, object.Object) {
+	arr, ok := value.(*object.Array)
+	if !ok {
+		return mismatchf(p.Pos, object.TypeError, "cannot destructure %s as an array", value.Type()), nil
+	}
+	if arr.Len() > len(p.Elems) && p.Rest == nil {
+		return mismatchf(p.Pos, object.IndexError, "cannot destructure array of length %d: expected %d elements", arr.Len(), len(p.Elems)), nil
+	}
+	for i, elem := range p.Elems {
+		var v object.Object
+		if i < arr.Len() {
+			v = arr.At(i)
+		} else if elem.Default == nil {
+			return mismatchf(p.Pos, object.IndexError, "cannot destructure array of length %d: no element at index %d", arr.Len(), i), nil
+		}
+		if mismatch, err := matchElement(elem, v, env); mismatch != nil || err != nil {
+			return mismatch, err
+		}
+	}
+	if p.Rest != nil {
+		rest := &object.Array{}
+		if arr.Len() > len(p.Elems) {
+			rest = arr.Drop(len(p.Elems))
+		}
+		return matchPattern(p.Rest, rest, env)
+	}
+	return nil, nil
+}
+
+func matchHash(p *ast.HashPattern, value object.Object, env *object.Environment) (*object.Error, object.Object) {
+	hash, ok := value.(*object.Hash)
+	if !ok {
+		return mismatchf(p.Pos, object.TypeError, "cannot destructure %s as a hash", value.Type()), nil
+	}
+	for _, pair := range p.Pairs {
+		key := Eval(pair.Key, env)
+		if object.IsError(key) {
+			return nil, key
+		}
+		v, ok := hash.Get(key)
+		if !ok && pair.Default == nil {
+			return mismatchf(p.Pos, object.IndexError, "cannot destructure hash: key %s not found", describe(key)), nil
+		}
+		if mismatch, err := matchElement(pair.PatternElement, v, env); mismatch != nil || err != nil {
+			return mismatch, err
+		}
+	}
+	return nil, nil
+}
+
+// matchElement matches value, or the default of elem if value is nil
+func matchElement(elem ast.PatternElement, value object.Object, env *object.Environment) (*object.Error, object.Object) {
+	if value == nil {
+		value = Eval(elem.Default, env)
+		if object.IsError(value) {
+			return nil, value
+		}
+	}
+	return matchPattern(elem.Target, value, env)
+}
+
+func mismatchf(pos token.Pos, kind object.ErrorKind, format string, a ...any) *object.Error {
+	err := object.NewError(kind, format, a...)
+	err.Pos = pos
+	return err
+}
+
+// describe formats a value for an error message, quoting strings
+func describe(obj object.Object) string {
+	if s, ok := obj.(*object.String); ok {
+		return strconv.Quote(s.Value)
+	}
+	return obj.String()
+}
+
+// patternNames returns the names bound by pat.
+func patternNames(pat ast.Pattern) []string {
+	var names []string
+	for _, id := range patternIdents(pat) {
+		names = append(names, id.Value)
+	}
+	return names
+}
+
+// patternIdents returns the identifiers bound by pat.
+func patternIdents(pat ast.Pattern) []*ast.Identifier {
+	var ids []*ast.Identifier
+	switch p := pat.(type) {
+	case *ast.Identifier:
+		ids = append(ids, p)
+	case *ast.ArrayPattern:
+		for _, e := range p.Elems {
+			ids = append(ids, patternIdents(e.Target)...)
+		}
+		if p.Rest != nil {
+			ids = append(ids, p.Rest)
+		}
+	case *ast.HashPattern:
+		for _, pair := range p.Pairs {
+			ids = append(ids, patternIdents(pair.Target)...)
+		}
+	case *ast.VariantPattern:
+		for _, field := range p.Fields {
+			ids = append(ids, patternIdents(field)...)
+		}
+	}
+	return ids
+}
@@ -7,7 +7,15 @@ import (
 
 func evalPrefixExpression(node *ast.PrefixExpression, env *object.Environment) object.Object {
 	rhs := Eval(node.Rhs, env)
-	switch node.Op {
+	if object.IsError(rhs) {
+		return rhs
+	}
+	return at(Prefix(node.Op, rhs), node.Pos)
+}
+
+// Prefix applies a unary operator to an evaluated operand.
+func Prefix(op string, rhs object.Object) object.Object {
+	switch op {
 	case "-":
 		return evalMinusPrefixOperator(rhs)
 	case "!":
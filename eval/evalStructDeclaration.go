@@ -0,0 +1,80 @@
+package eval
+
+import (
+	"sort"
+
+	"github.com/kvalv/monkey/ast"
+	"github.com/kvalv/monkey/object"
+)
+
+// evalStructDeclaration binds the name of the struct to its type, which is
+// also its constructor.
+func evalStructDeclaration(node *ast.StructDeclaration, env *object.Environment) object.Object {
+	t := &object.StructType{Name: node.Name.Value, Methods: make(map[string]*object.Function)}
+	for _, f := range node.Fields {
+		t.Fields = append(t.Fields, f.Value)
+	}
+	for _, m := range node.Methods {
+		fn := evalFunctionLiteral(m.Fn, env)
+		if object.IsError(fn) {
+			return at(fn, m.Pos)
+		}
+		method := fn.(*object.Function)
+		method.Name = t.Name + "." + m.Name.Value
+		t.Methods[m.Name.Value] = method
+	}
+	if err := bind(node.Name, t, env); err != nil {
+		return at(bindError(node.Name.Value, err), node.Pos)
+	}
+	return t
+}
+
+// construct creates an instance of t.
+func construct(t *object.StructType, args []object.Object, kwargs map[string]object.Object) object.Object {
+	values, err := fieldValues(t.Name, t.Fields, args, kwargs)
+	if err != nil {
+		return err
+	}
+	return object.NewStruct(t, values)
+}
+
+// fieldValues matches the arguments of a constructor to the fields of what
+// it constructs, which is called name in errors. Like the arguments of a
+// function, fields are given by position, then by name; every field must get
+// a value.
+func fieldValues(name string, fields []string, args []object.Object, kwargs map[string]object.Object) ([]object.Object, *object.Error) {
+	if len(args) > len(fields) {
+		return nil, object.NewError(object.ArgumentError, "%s has %d fields but received %d arguments", name, len(fields), len(args))
+	}
+	index := func(field string) int {
+		for i, f := range fields {
+			if f == field {
+				return i
+			}
+		}
+		return -1
+	}
+	values := make([]object.Object, len(fields))
+	copy(values, args)
+	names := make([]string, 0, len(kwargs))
+	for name := range kwargs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, field := range names {
+		i := index(field)
+		if i < 0 {
+			return nil, object.NewError(object.NameError, "%s has no field '%s'", name, field)
+		}
+		if values[i] != nil {
+			return nil, object.NewError(object.ArgumentError, "multiple values for field '%s' of %s", field, name)
+		}
+		values[i] = kwargs[field]
+	}
+	for i, v := range values {
+		if v == nil {
+			return nil, object.NewError(object.ArgumentError, "missing value for field '%s' of %s", fields[i], name)
+		}
+	}
+	return values, nil
+}
@@ -0,0 +1,98 @@
+package eval
+
+import (
+	"github.com/kvalv/monkey/ast"
+	"github.com/kvalv/monkey/object"
+	"github.com/kvalv/monkey/token"
+)
+
+func evalThrowExpression(node *ast.ThrowExpression, env *object.Environment) object.Object {
+	value := Eval(node.Value, env)
+	if object.IsError(value) {
+		return value
+	}
+	if caught, ok := value.(*object.ErrorValue); ok {
+		// throwing a caught error raises it again, with its original position
+		// and call stack
+		return caught.Err
+	}
+	return &object.Error{Kind: object.ThrownError, Message: value.String(), Value: value, Pos: node.Pos}
+}
+
+func evalTryExpression(node *ast.TryExpression, env *object.Environment) object.Object {
+	res := Eval(node.Block, env)
+	// an evaluation that has been cancelled must stop, so that error is not
+	// caught
+	if err, ok := res.(*object.Error); ok && node.Catch != nil && env.Context().Err() == nil {
+		res = evalCatch(node, err, env)
+	}
+	if node.Finally != nil {
+		// the finally block runs regardless, and only replaces the result if
+		// it fails or returns
+		fin := Eval(node.Finally, env)
+		if object.IsError(fin) || fin.Type() == object.RETURN_OBJ {
+			return fin
+		}
+	}
+	return res
+}
+
+// evalCatch evaluates the catch block in a new scope, where the parameter is
+// bound to err.
+func evalCatch(node *ast.TryExpression, err *object.Error, env *object.Environment) object.Object {
+	scope := env.NewFrame(node.Locals)
+	if node.Param != nil {
+		if err := bind(node.Param, &object.ErrorValue{Err: err}, scope); err != nil {
+			return bindError(node.Param.Value, err)
+		}
+	}
+	return Eval(node.Catch, scope)
+}
+
+// at records pos as the position of an error that does not have one yet.
+// The error is copied, as it may be shared.
+func at(obj object.Object, pos token.Pos) object.Object {
+	err, ok := obj.(*object.Error)
+	if !ok || err.Pos != (token.Pos{}) {
+		return obj
+	}
+	cp := *err
+	cp.Pos = pos
+	return &cp
+}
+
+// errorField looks up one of the fields that scripts can inspect on a caught
+// error.
+func errorField(e *object.ErrorValue, key object.Object) object.Object {
+	name, ok := key.(*object.String)
+	if !ok {
+		return object.ErrorExpected(object.STRING_OBJ)
+	}
+	err := e.Err
+	switch name.Value {
+	case "message":
+		return &object.String{Value: err.Message}
+	case "kind":
+		return &object.String{Value: err.Kind.String()}
+	case "value":
+		if err.Value == nil {
+			return object.NULL
+		}
+		return err.Value
+	case "line", "col":
+		if err.Pos == (token.Pos{}) {
+			return object.NULL
+		}
+		if name.Value == "line" {
+			return &object.Integer{Value: int64(err.Pos.Line)}
+		}
+		return &object.Integer{Value: int64(err.Pos.Col)}
+	case "stack":
+		var frames []object.Object
+		for _, f := range err.Stack {
+			frames = append(frames, &object.String{Value: f.String()})
+		}
+		return object.NewArray(frames...)
+	}
+	return object.NULL
+}
@@ -1,13 +1,21 @@
 package eval_test
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"runtime"
+	"runtime/debug"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/kvalv/monkey/ast"
 	"github.com/kvalv/monkey/eval"
+	"github.com/kvalv/monkey/module"
 	"github.com/kvalv/monkey/object"
 	"github.com/kvalv/monkey/parser"
+	"github.com/kvalv/monkey/token"
 )
 
 func TestIntegerExpression(t *testing.T) {
@@ -88,7 +96,7 @@ func TestBuiltinFunction(t *testing.T) {
 		{`len("1234")`, 4},
 		{`len("ab" + "cd")`, 4},
 		// {`len("")`, 0}, // TODO :S
-		{`len(2)`, fmt.Errorf("type error: expected STRING but got INTEGER")},
+		{`len(2)`, fmt.Errorf("len() not supported for objects of type INTEGER")},
 	}
 	for _, tc := range cases {
 		prog := expectParse(t, tc.input)
@@ -303,6 +311,1023 @@ func TestHashAssignment(t *testing.T) {
 	}
 }
 
+func TestScopes(t *testing.T) {
+	cases := []struct {
+		input    string
+		expected any
+	}{
+		{"let adder = fn(a) { fn(b) { a + b } }; adder(2)(3)", 5},
+		{"let f = fn() { let g = fn() { h() }; let h = fn() { 2 }; g() }; f()", 2},
+		{"let f = fn(x) { if x > 0 { let y = 1 } else { let y = 2 }; y }; f(0)", 2},
+		{"let f = fn() { g() }; f(); let g = fn() { 1 }", fmt.Errorf("identifier 'g' not defined")},
+		{"let f = fn() { x }", fmt.Errorf("identifier 'x' not defined")},
+		{"let a = 1; let a = 2", fmt.Errorf("identifier 'a' already defined")},
+		{"let h = {}; let f = fn(k) { h[k] = k }; f(1); h[1]", 1},
+	}
+	for _, tc := range cases {
+		t.Run(tc.input, func(t *testing.T) {
+			prog := expectParse(t, tc.input)
+			got := expectEval(t, prog)
+			expectLiteral(t, got, tc.expected)
+		})
+	}
+}
+
+func TestTailCalls(t *testing.T) {
+	// without tail calls, these would need far more stack than this
+	defer debug.SetMaxStack(debug.SetMaxStack(8 << 20))
+
+	xs := make([]object.Object, 100000)
+	for i := range xs {
+		xs[i] = &object.Integer{Value: 1}
+	}
+	cases := []struct {
+		input    string
+		expected any
+	}{
+		{"let count = fn(n) { if n == 0 { 0 } else { count(n - 1) } }; count(100000)", 0},
+		{"let count = fn(n) { if n == 0 { return 0 }; return count(n - 1) }; count(100000)", 0},
+		{`let even = fn(n) { if n == 0 { true } else { odd(n - 1) } };
+		  let odd = fn(n) { if n == 0 { false } else { even(n - 1) } };
+		  even(100001)`, false},
+		{"let sum = fn(i, acc) { if i == len(xs) { acc } else { sum(i + 1, acc + xs[i]) } }; sum(0, 0)", 100000},
+		{"let f = fn(n) { if n == 0 { len(\"done\") } else { f(n - 1) } }; f(100000)", 4},
+		{"let f = fn(n) { f(n, n) }; f(1)", fmt.Errorf("Error invoking function: expected 1 arguments but received 2")},
+	}
+	for _, tc := range cases {
+		t.Run(tc.input, func(t *testing.T) {
+			env := object.NewEnvironment()
+			env.Set("xs", object.NewArray(xs...))
+			got := eval.Eval(expectParse(t, tc.input), env)
+			expectLiteral(t, got, tc.expected)
+		})
+	}
+}
+
+func TestStackTrace(t *testing.T) {
+	input := `let f = fn(x) { x + true }
+let g = fn(x) {
+  1 + f(x)
+}
+let h = fn() { g(1) + 1 }
+h()`
+	cases := []struct {
+		input    string
+		expected []string
+	}{
+		{input, []string{"f (called at 3:7)", "g (called at 5:16)", "h (called at 6:1)"}},
+		{"1 + true", nil},
+		{"let f = fn() { len(1) + 1 }; 1 + f()", []string{"len (called at 1:16)", "f (called at 1:34)"}},
+		{"fn(x) { x + true }(1)", []string{"<anonymous> (called at 1:19)"}},
+		// the tail call to f replaces the call to g
+		{"let f = fn() { 1 + true }; let g = fn() { f() }; 1 + g()", []string{"f (called at 1:43)"}},
+		// a declared function keeps its name when called through a hash
+		{`fn f() { 1 + true }; let h = {"k": f}; 1 + h["k"]()`, []string{"f (called at 1:50)"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.input, func(t *testing.T) {
+			_, err := eval.NewInterpreter().Run(context.Background(), tc.input)
+			if err == nil {
+				t.Fatalf("expected an error")
+			}
+			var got []string
+			for _, f := range eval.CallStack(err) {
+				got = append(got, f.String())
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tc.expected) {
+				t.Fatalf("expected stack %q, got %q", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestFunctionDeclarations(t *testing.T) {
+	cases := []struct {
+		input    string
+		expected any
+	}{
+		{"fn f() { 1 }; f()", 1},
+		{"let x = f(2); fn f(n) { n * 2 }; x", 4},
+		{"fn even(n) { if n == 0 { true } else { odd(n - 1) } }; fn odd(n) { if n == 0 { false } else { even(n - 1) } }; odd(7)", true},
+		{"fn() { let x = even(4); fn even(n) { if n == 0 { true } else { odd(n - 1) } }; fn odd(n) { if n == 0 { false } else { even(n - 1) } }; x }()", true},
+		{"fn() { return f(); fn f() { 3 } }()", 3},
+		{"if true { let x = g(); fn g() { 5 }; x }", 5},
+		{"let h = fn() { 1 }; fn h() { 2 }", fmt.Errorf("identifier 'h' already defined")},
+		{"fn f() { 1 }; fn f() { 2 }", fmt.Errorf("identifier 'f' already defined")},
+	}
+	for _, tc := range cases {
+		prog := expectParse(t, tc.input)
+		got := eval.Eval(prog, object.NewEnvironment())
+		expectLiteral(t, got, tc.expected)
+	}
+
+	got := eval.Eval(expectParse(t, "fn add(a, b) { a + b }"), object.NewEnvironment())
+	if fn, ok := got.(*object.Function); !ok || fn.Name != "add" {
+		t.Fatalf("expected the function add, got %v", got)
+	}
+	if s := got.String(); !strings.HasPrefix(s, "fn add(a, b)") {
+		t.Fatalf("expected the name to be printed, got %q", s)
+	}
+}
+
+func TestArguments(t *testing.T) {
+	cases := []struct {
+		input    string
+		expected any
+	}{
+		{"fn(a, b = 2) { a + b }(1)", 3},
+		{"fn(a, b = 2) { a + b }(1, 5)", 6},
+		{"fn(a, b = a * 10) { b }(3)", 30},
+		{"let n = 4; fn(a = n) { a }()", 4},
+		{"fn(a, ...rest) { rest }(1, 2, 3)", []any{2, 3}},
+		{"fn(a, ...rest) { rest }(1)", []any{}},
+		{"fn(a, b) { a - b }(b: 1, a: 3)", 2},
+		{"fn(a, b = 2, c = 3) { [a, b, c] }(1, c: 4)", []any{1, 2, 4}},
+		{"let xs = [1, 2]; fn(a, b) { a - b }(...xs)", -1},
+		{"let xs = [2]; fn(...r) { r }(1, ...xs, 3)", []any{1, 2, 3}},
+		{"let a = [1, 2]; let b = [3]; [0, ...a, ...b]", []any{0, 1, 2, 3}},
+		{"[...[]]", []any{}},
+		{"len(...[[1, 2]])", 2},
+		{"let f = fn(n, acc = 0) { if n == 0 { acc } else { f(n - 1, acc: acc + n) } }; f(10)", 55},
+		{"fn(a, b) { a }(1)", fmt.Errorf("Error invoking function: missing argument for parameter 'b'")},
+		{"fn(a) { a }(1, 2)", fmt.Errorf("Error invoking function: expected 1 arguments but received 2")},
+		{"fn(a) { a }(b: 1)", fmt.Errorf("Error invoking function: unexpected keyword argument 'b'")},
+		{"fn(a) { a }(1, a: 1)", fmt.Errorf("Error invoking function: multiple values for parameter 'a'")},
+		{"fn(a) { a }(a: 1, a: 2)", fmt.Errorf("keyword argument 'a' repeated")},
+		{"fn(...r) { r }(r: 1)", fmt.Errorf("Error invoking function: unexpected keyword argument 'r'")},
+		{"len(a: 1)", fmt.Errorf("builtin functions do not accept keyword arguments")},
+		{"[...1]", fmt.Errorf("cannot spread INTEGER, expected ARRAY")},
+		{"...[1]", fmt.Errorf("spread is only allowed in array literals and call arguments")},
+	}
+	for _, tc := range cases {
+		t.Run(tc.input, func(t *testing.T) {
+			prog := expectParse(t, tc.input)
+			got := eval.Eval(prog, object.NewEnvironment())
+			expectLiteral(t, got, tc.expected)
+		})
+	}
+}
+
+func TestDestructuring(t *testing.T) {
+	cases := []struct {
+		input    string
+		expected any
+	}{
+		{"let [a, b] = [1, 2]; a - b", -1},
+		{"let [a, b = 5] = [1]; a + b", 6},
+		{"let [a, b = a * 2] = [3]; b", 6},
+		{"let [a, ...rest] = [1, 2, 3]; rest", []any{2, 3}},
+		{"let [...all] = []; all", []any{}},
+		{"let [a, [b, c]] = [1, [2, 3]]; a + b + c", 6},
+		{`let {"name": n, "age": a} = {"name": "x", "age": 3}; [n, a]`, []any{"x", 3}},
+		{`let {"age": a = 18} = {}; a`, 18},
+		{`let {"xs": [x, y]} = {"xs": [1, 2]}; x + y`, 3},
+		{`let [{"v": v}, w] = [{"v": 1}, 2]; v + w`, 3},
+		{"let [a, b] = [1, 2]", []any{1, 2}},
+		{"fn() { let [a, b] = [1, 2]; fn() { a + b } }()()", 3},
+		{"let [a, b] = 1", fmt.Errorf("cannot destructure INTEGER as an array")},
+		{`let {"a": a} = []`, fmt.Errorf("cannot destructure ARRAY as a hash")},
+		{"let [a, b] = [1]", fmt.Errorf("cannot destructure array of length 1: no element at index 1")},
+		{"let [a] = [1, 2]", fmt.Errorf("cannot destructure array of length 2: expected 1 elements")},
+		{`let {"a": a} = {"b": 1}`, fmt.Errorf(`cannot destructure hash: key "a" not found`)},
+		{"let [a, [b]] = [1, 2]", fmt.Errorf("cannot destructure INTEGER as an array")},
+		{"let [a, a] = [1, 2]", fmt.Errorf("identifier 'a' already defined")},
+	}
+	for _, tc := range cases {
+		t.Run(tc.input, func(t *testing.T) {
+			prog := expectParse(t, tc.input)
+			got := eval.Eval(prog, object.NewEnvironment())
+			expectLiteral(t, got, tc.expected)
+		})
+	}
+}
+
+func TestMatchExpression(t *testing.T) {
+	cases := []struct {
+		input    string
+		expected any
+	}{
+		{`match 1 { 1 => "one", _ => "other" }`, "one"},
+		{`match 5 { 1 => "one", _ => "other" }`, "other"},
+		{`match -1 { -1 => "minus one", _ => "other" }`, "minus one"},
+		{`match "a" { "b" => 1, "a" => 2 }`, 2},
+		{`match true { false => 1, true => 2 }`, 2},
+		{`match 1 { "1" => "string", 1 => "int" }`, "int"},
+		{"match 7 { n => n * 2 }", 14},
+		{"match [1, 2, 3] { [] => 0, [a] => a, [a, ...rest] => rest }", []any{2, 3}},
+		{"match [1] { [] => 0, [a] => a, [a, ...rest] => rest }", 1},
+		{"match [1, [2, 3]] { [1, [x, 3]] => x, _ => 0 }", 2},
+		{"match [1, [2, 4]] { [1, [x, 3]] => x, _ => 0 }", 0},
+		{`match {"kind": "circle", "r": 2} { {"kind": "square", "side": s} => s * s, {"kind": "circle", "r": r} => 3 * r * r }`, 12},
+		{`match {"a": 1} { {"b": b} => b, {"a": a, "b": b = 10} => a + b }`, 11},
+		{"match 5 { n if n > 10 => 1, n if n > 3 => 2, _ => 3 }", 2},
+		{"match [2, 1] { [a, b] if a < b => a, [a, b] => b }", 1},
+		{"let f = fn(x) { match x { 0 => 0, n => f(n - 1) } }; f(100000)", 0},
+		{"fn() { let x = 3; match x { 1 => 1, y => x + y } }()", 6},
+		// each arm binds its names in a scope of its own
+		{"let n = 1; match 2 { n => n }; n", 1},
+		{"const n = 1; match 2 { n => n }", 2},
+		{"fn() { let n = 1; match 2 { n => n }; n }()", 1},
+		{"match 1 { a => a }; a", fmt.Errorf("identifier 'a' not defined")},
+		{"let fs = [match x { n => fn() { n } } for x in [1, 2]]; fs[0]() + fs[1]()", 3},
+		{"match 3 { 1 => 1, 2 => 2 }", fmt.Errorf("no arm of match matches 3")},
+		{`match "x" { "y" => 1 }`, fmt.Errorf(`no arm of match matches "x"`)},
+		{"match [1] { [a, b] => 1 }", fmt.Errorf("no arm of match matches [1]")},
+		{"match 1 { n if n + true => 1 }", fmt.Errorf("type mismatch: INTEGER + BOOLEAN")},
+		{"let [1, a] = [2, 3]", fmt.Errorf("2 does not match 1")},
+		{"let [_, a] = [2, 3]; a", 3},
+	}
+	for _, tc := range cases {
+		t.Run(tc.input, func(t *testing.T) {
+			prog := expectParse(t, tc.input)
+			got := eval.Eval(prog, object.NewEnvironment())
+			expectLiteral(t, got, tc.expected)
+		})
+	}
+}
+
+func TestErrorKinds(t *testing.T) {
+	cases := []struct {
+		input    string
+		expected object.ErrorKind
+	}{
+		{"1 + true", object.TypeError},
+		{`"a" - "b"`, object.TypeError},
+		{"[1][true]", object.TypeError},
+		{"1[0]", object.TypeError},
+		{"len(1)", object.TypeError},
+		{"x", object.NameError},
+		{"let f = fn() { y }; f()", object.NameError},
+		{"[1][5]", object.IndexError},
+		{"[1][-1]", object.IndexError},
+		{"let [a] = []", object.IndexError},
+		{"let [a] = 1", object.TypeError},
+		{"match 1 { 2 => 2 }", object.MatchError},
+		{"len(1, 2)", object.ArgumentError},
+		{"fn(x) { x }()", object.ArgumentError},
+		{"fn(x) { x }(y: 1)", object.ArgumentError},
+		{"[...1]", object.TypeError},
+		{"1 / 0", object.ZeroDivisionError},
+		{`throw "x"`, object.ThrownError},
+		{`try { 1 / 0 } catch (e) { throw e }`, object.ZeroDivisionError},
+	}
+	for _, tc := range cases {
+		t.Run(tc.input, func(t *testing.T) {
+			_, err := eval.NewInterpreter().Run(context.Background(), tc.input)
+			if !errors.Is(err, tc.expected) {
+				t.Fatalf("expected a %s, got %v", tc.expected, err)
+			}
+			var kind object.ErrorKind
+			if !errors.As(err, &kind) || kind != tc.expected {
+				t.Fatalf("expected kind %s, got %s", tc.expected, kind)
+			}
+		})
+	}
+	_, err := eval.NewInterpreter().Run(context.Background(), "[1][5]")
+	if errors.Is(err, object.TypeError) {
+		t.Fatalf("an index error is not a type error")
+	}
+}
+
+func TestModules(t *testing.T) {
+	files := module.Map{
+		"math.monkey": `
+			export let pi = 3;
+			export fn area(r) { pi * r * r }
+			let hidden = 1;
+		`,
+		"state.monkey":         `export let state = {"n": 0}`,
+		"lib/util.monkey":      `let m = import "../math"; export let [a, b] = [m["pi"], helper()]; fn helper() { 2 }`,
+		"lib/nested.monkey":    `export let u = import "./util"`,
+		"cycle/a.monkey":       `import "./b"`,
+		"cycle/b.monkey":       `import "./a"`,
+		"broken.monkey":        `let = 1`,
+		"failing.monkey":       `export let x = 1 / 0`,
+		"self.monkey":          `import "./self"`,
+		"imports/main.monkey":  `export let v = (import "../lib/util")["a"]`,
+		"imports/other.monkey": `export let v = (import "./main")["v"]`,
+		"shapes.monkey":        `export struct Point { x, y, fn sum() { self.x + self.y } }; export enum Shape { Circle(r), Empty }; struct Hidden { a }`,
+	}
+	cases := []struct {
+		input    string
+		expected any
+	}{
+		{`let m = import "./math"; m["pi"]`, 3},
+		{`let m = import "./math"; m["area"](2)`, 12},
+		{`let m = import "./math"; m.area(m.pi)`, 27},
+		{`(import "./math.monkey")["pi"]`, 3},
+		{`let m = import "./lib/util"; [m["a"], m["b"]]`, []any{3, 2}},
+		{`(import "./lib/nested")["u"]["b"]`, 2},
+		{`(import "./imports/other")["v"]`, 3},
+		{`let a = import "./state"; let b = import "./state"; let s = a["state"]; s["n"] = 5; b["state"]["n"]`, 5},
+		{`let f = fn() { import "./math" }; f()["pi"]`, 3},
+		{`(import "./math")["hidden"]`, fmt.Errorf(`module math.monkey does not export 'hidden'`)},
+		{`let s = import "./shapes"; s.Point(1, 2).sum()`, 3},
+		{`let s = import "./shapes"; s.Point(y: 5, x: 1).y`, 5},
+		{`let s = import "./shapes"; let Shape = s.Shape; match s.Circle(2) { Shape.Circle(r) => r, Shape.Empty => 0 }`, 2},
+		{`let s = import "./shapes"; s.Empty == s.Shape.Empty`, true},
+		{`(import "./shapes")["Hidden"]`, fmt.Errorf(`module shapes.monkey does not export 'Hidden'`)},
+		{`import "./missing"`, fmt.Errorf("import: module not found: missing.monkey")},
+		{`import "../outside"`, fmt.Errorf(`import: invalid module path "../outside"`)},
+		{`import "./cycle/a"`, fmt.Errorf("module cycle/a.monkey: module cycle/b.monkey: import cycle: cycle/a.monkey -> cycle/b.monkey -> cycle/a.monkey")},
+		{`import "./self"`, fmt.Errorf("module self.monkey: import cycle: self.monkey -> self.monkey")},
+		{`import "./failing"`, fmt.Errorf("module failing.monkey: division by zero")},
+	}
+	for _, tc := range cases {
+		t.Run(tc.input, func(t *testing.T) {
+			in := eval.NewInterpreter(eval.WithLoader(files))
+			got, err := in.Run(context.Background(), tc.input)
+			if err != nil {
+				if e, ok := tc.expected.(error); !ok || err.Error() != e.Error() {
+					t.Fatalf("expected %v, got error %q", tc.expected, err)
+				}
+				return
+			}
+			expectLiteral(t, got, tc.expected)
+		})
+	}
+
+	t.Run("once", func(t *testing.T) {
+		// the module is evaluated once per interpreter, also across programs
+		in := eval.NewInterpreter(eval.WithLoader(files))
+		if _, err := in.Run(context.Background(), `let s = (import "./state")["state"]; s["n"] = 7`); err != nil {
+			t.Fatal(err)
+		}
+		got, err := in.Run(context.Background(), `(import "./state")["state"]["n"]`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expectLiteral(t, got, 7)
+	})
+	t.Run("kind", func(t *testing.T) {
+		for _, src := range []string{`import "./missing"`, `import "./broken"`, `import "./cycle/a"`} {
+			_, err := eval.NewInterpreter(eval.WithLoader(files)).Run(context.Background(), src)
+			if !errors.Is(err, object.ImportError) {
+				t.Fatalf("%s: expected an import error, got %v", src, err)
+			}
+		}
+	})
+	t.Run("no loader", func(t *testing.T) {
+		_, err := eval.NewInterpreter().Run(context.Background(), `import "./math"`)
+		if !errors.Is(err, object.ImportError) {
+			t.Fatalf("expected an import error, got %v", err)
+		}
+	})
+}
+
+func TestStructs(t *testing.T) {
+	point := `struct Point {
+		x, y,
+		fn normsq() { self.x * self.x + self.y * self.y }
+		fn add(o) { Point(self.x + o.x, self.y + o.y) },
+		fn scale(k = 2) { Point(x: self.x * k, y: self.y * k) }
+		fn moved(dx) { self.x = self.x + dx; self }
+	};`
+	cases := []struct {
+		input    string
+		expected any
+	}{
+		{point + "Point(1, 2).x", 1},
+		{point + "Point(y: 2, x: 1).y", 2},
+		{point + "Point(1, y: 5).y", 5},
+		{point + "Point(3, 4).normsq()", 25},
+		{point + "Point(1, 2).add(Point(10, 20)).y", 22},
+		{point + "let p = Point(1, 2); p.scale().x + p.scale(k: 10).y", 22},
+		{point + "let f = Point(3, 4).normsq; f()", 25},
+		{point + "let p = Point(1, 2); p.x = 7; p.x", 7},
+		{point + "let p = Point(1, 2); p.moved(3); p.x", 4},
+		{point + `Point(1, "a")`, `Point{x: 1, y: a}`},
+		{point + "Point", "struct Point"},
+		{point + "let self = 100; Point(1, 2).normsq() + self", 105},
+		{"struct Empty {}; Empty()", "Empty{}"},
+		{"struct Node { value, next }; let n = Node(1, Node(2, 0)); n.next.value", 2},
+		{"fn() { struct P { a, fn get() { self.a } }; P(5).get() }()", 5},
+		{`struct C { n, fn down() { if (self.n == 0) { return 0 }; C(self.n - 1).down() } }; C(10000).down()`, 0},
+		{point + "Point(1, 2).z", fmt.Errorf("Point has no field 'z'")},
+		{point + "let p = Point(1, 2); p.z = 1", fmt.Errorf("Point has no field 'z'")},
+		{point + "Point(1, z: 2)", fmt.Errorf("Point has no field 'z'")},
+		{point + "Point(1)", fmt.Errorf("missing value for field 'y' of Point")},
+		{point + "Point(1, 2, 3)", fmt.Errorf("Point has 2 fields but received 3 arguments")},
+		{point + "Point(1, x: 2)", fmt.Errorf("multiple values for field 'x' of Point")},
+		{point + "Point(1, 2).normsq(1)", fmt.Errorf("Error invoking function: expected 0 arguments but received 1")},
+		{"1.x", fmt.Errorf("INTEGER has no fields")},
+		{"let h = {}; h.x = 1", fmt.Errorf("cannot assign to a field of HASH")},
+	}
+	for _, tc := range cases {
+		t.Run(tc.input, func(t *testing.T) {
+			prog := expectParse(t, tc.input)
+			got := eval.Eval(prog, object.NewEnvironment())
+			if s, ok := got.(*object.Struct); ok {
+				got = &object.String{Value: s.String()}
+			}
+			if s, ok := got.(*object.StructType); ok {
+				got = &object.String{Value: s.String()}
+			}
+			expectLiteral(t, got, tc.expected)
+		})
+	}
+	_, err := eval.NewInterpreter().Run(context.Background(), "struct P { a }; P(1).b")
+	if !errors.Is(err, object.NameError) {
+		t.Fatalf("expected a name error, got %v", err)
+	}
+}
+
+func TestEnums(t *testing.T) {
+	shape := `enum Shape { Circle(r), Rect(w, h), Empty };
+	fn area(s) {
+		match s {
+			Circle(r) => 3 * r * r,
+			Rect(w, h) => w * h,
+			Empty() => 0,
+		}
+	};`
+	cases := []struct {
+		input    string
+		expected any
+	}{
+		{shape + "area(Circle(2))", 12},
+		{shape + "area(Rect(h: 3, w: 4))", 12},
+		{shape + "area(Empty)", 0},
+		{shape + "area(Shape.Rect(2, 5))", 10},
+		{shape + "Rect(2, 5).h", 5},
+		{shape + "Rect(2, 5)", "Rect(2, 5)"},
+		{shape + `Circle("a")`, "Circle(a)"},
+		{shape + "Empty", "Empty"},
+		{shape + "Shape", "enum Shape"},
+		{shape + "Circle", "Shape.Circle"},
+		{shape + "Circle(1) == Circle(1)", true},
+		{shape + "Circle(1) == Circle(2)", false},
+		{shape + "Circle(1) != Rect(1, 1)", true},
+		{shape + "Empty == Shape.Empty", true},
+		{shape + `Circle(1) == Circle("1")`, false},
+		{shape + "Rect(Circle(1), 2) == Rect(Circle(1), 2)", true},
+		{shape + "[Empty, Circle(3)]", "[Empty, Circle(3)]"},
+		{shape + "match Rect(1, 2) { Rect(1, h) => h, _ => 0 }", 2},
+		{shape + "match Rect(2, 2) { Rect(1, h) => h, _ => 0 }", 0},
+		{shape + "match Circle(5) { Circle(r) if r > 3 => 1, Circle(r) => 2, Rect(w, h) => 3, Shape.Empty => 4 }", 1},
+		{shape + "match Empty { Circle(r) => 1, Rect(w, h) => 3, Empty => 2 }", 2},
+		// the name of a variant matches the variant instead of binding it
+		{shape + "match Circle(1) { Empty => 1, _ => 2 }", 2},
+		{shape + "match Circle(1) { Empty => 1, _ => 2 }; Empty", "Empty"},
+		{shape + "match Rect(1, 2) { Circle => 1, Rect => 2, Empty => 3 }", 2},
+		{shape + "match [Empty] { [Empty] => 1, _ => 2 }", 1},
+		{shape + "let [Empty, x] = [Empty, 3]; x", 3},
+		{shape + "match Circle(1) { Circle(r) => 1, Empty => 3 }", fmt.Errorf("match on Shape is not exhaustive: Rect is not covered")},
+		{"fn() { enum T { A, B }; match B { A => 1, B => 2 } }()", 2},
+		{"enum E { A }; fn(A) { match 2 { A => A } }(1)", 2},
+		{"enum Option { Some(value), None }; match Some(Some(4)) { Some(Some(x)) => x, Some(None()) => 1, Some(_) => 2, None() => 0 }", 4},
+		{"enum Result { Ok(value), Err(reason) }; let Ok(v) = Ok(9); v", 9},
+		{"fn() { enum T { A, B(x) }; B(1).x }()", 1},
+		{"fn() { enum T { A, B(x) }; match B(1) { T.A => 0, B(x) => x } }()", 1},
+		{shape + "match Circle(1) { Circle(r) => r, Empty() => 0 }", fmt.Errorf("match on Shape is not exhaustive: Rect is not covered")},
+		{shape + "match Circle(1) { Circle(r) if r > 0 => r, Rect(w, h) => 0, Empty() => 0 }", fmt.Errorf("match on Shape is not exhaustive: Circle is not covered")},
+		{shape + "match Rect(1, 2) { Circle(r) => r, Rect(1, h) => 0, Empty() => 0 }", fmt.Errorf("match on Shape is not exhaustive: Rect is not covered")},
+		{shape + "match Circle(1) { Circle(r, x) => r, _ => 0 }", fmt.Errorf("pattern Circle(r, x) has 2 fields but Shape.Circle has 1")},
+		{shape + "let Circle(r) = Rect(1, 2)", fmt.Errorf("Rect(1, 2) does not match Circle(r)")},
+		{shape + "Shape.Square", fmt.Errorf("enum Shape has no variant 'Square'")},
+		{shape + "Circle(1).w", fmt.Errorf("Circle has no field 'w'")},
+		{shape + "Circle(1, 2)", fmt.Errorf("Circle has 1 fields but received 2 arguments")},
+		{shape + "Rect(1)", fmt.Errorf("missing value for field 'h' of Rect")},
+		{shape + "Circle(1) == 1", fmt.Errorf("type mismatch: ENUM == INTEGER")},
+	}
+	for _, tc := range cases {
+		t.Run(tc.input, func(t *testing.T) {
+			prog := expectParse(t, tc.input)
+			got := eval.Eval(prog, object.NewEnvironment())
+			switch got.(type) {
+			case *object.EnumValue, *object.EnumType, *object.Variant, *object.Array:
+				got = &object.String{Value: got.String()}
+			}
+			expectLiteral(t, got, tc.expected)
+		})
+	}
+	_, err := eval.NewInterpreter().Run(context.Background(), "enum E { A, B }; match A { A() => 1 }")
+	if !errors.Is(err, object.MatchError) {
+		t.Fatalf("expected a match error, got %v", err)
+	}
+	// variants bound by an earlier program are matched as well
+	in := eval.NewInterpreter()
+	if _, err := in.Run(context.Background(), "enum E { A, B }"); err != nil {
+		t.Fatal(err)
+	}
+	got, err := in.Run(context.Background(), "match B { A => 1, B => 2 }")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectLiteral(t, got, 2)
+}
+
+func TestOperatorOverloading(t *testing.T) {
+	vec := `struct Vec {
+		x, y,
+		fn __add(o) { Vec(self.x + o.x, self.y + o.y) }
+		fn __sub(o) { Vec(self.x - o.x, self.y - o.y) }
+		fn __mul(k) { Vec(self.x * k, self.y * k) }
+		fn __div(k) { Vec(self.x / k, self.y / k) }
+		fn __eq(o) { if (self.x == o.x) { self.y == o.y } else { false } }
+		fn __lt(o) { self.x * self.x + self.y * self.y < o.x * o.x + o.y * o.y }
+		fn __index(i) { if (i == 0) { self.x } else { self.y } }
+		fn __len() { 2 }
+	};`
+	money := `let meta = {
+		"__add": fn(a, b) { money(a["cents"] + b["cents"]) },
+		"__eq": fn(a, b) { a["cents"] == b["cents"] },
+		"__index": fn(m, key) { key + "?" },
+		"__len": fn(m) { m["cents"] },
+	};
+	let money = fn(cents) { setmeta({"cents": cents}, meta) };`
+	cases := []struct {
+		input    string
+		expected any
+	}{
+		{vec + "Vec(1, 2) + Vec(10, 20)", "Vec{x: 11, y: 22}"},
+		{vec + "Vec(5, 5) - Vec(1, 2)", "Vec{x: 4, y: 3}"},
+		{vec + "Vec(1, 2) * 3", "Vec{x: 3, y: 6}"},
+		{vec + "Vec(4, 6) / 2", "Vec{x: 2, y: 3}"},
+		{vec + "Vec(1, 2) == Vec(1, 2)", true},
+		{vec + "Vec(1, 2) != Vec(1, 2)", false},
+		{vec + "Vec(1, 2) != Vec(2, 1)", true},
+		{vec + "Vec(1, 1) < Vec(2, 2)", true},
+		{vec + "Vec(1, 1) > Vec(2, 2)", false},
+		{vec + "Vec(3, 4)[1]", 4},
+		{vec + "len(Vec(3, 4))", 2},
+		{vec + "Vec(1, 1) + Vec(1, 1) + Vec(1, 1) == Vec(1, 1) * 3", true},
+		{"struct P { a }; let p = P(1); p == p", true},
+		{"struct P { a }; P(1) == P(1)", false},
+		{money + `(money(150) + money(25))["cents"]`, 175},
+		{money + "money(150) == money(150)", true},
+		{money + "money(150) != money(1)", true},
+		{money + `money(1)["cents"]`, 1},
+		{money + `money(1)["euros"]`, "euros?"},
+		{money + "len(money(42))", 42},
+		{money + "getmeta(money(1)) == meta", true},
+		{"getmeta({})", nil},
+		{`let h = setmeta({}, {"__add": fn(a, b) { 1 }}); setmeta(h, first([])); h + h`, fmt.Errorf("unknown operator: HASH + HASH")},
+		{vec + "Vec(1, 2) * Vec(1, 2)", fmt.Errorf("type mismatch: INTEGER * STRUCT")},
+		{"struct P { a }; P(1) + P(2)", fmt.Errorf("unknown operator: STRUCT + STRUCT")},
+		{"struct P { a }; P(1)[0]", fmt.Errorf("indexing is only supported for arrays or hashes")},
+		{"struct P { a }; len(P(1))", fmt.Errorf("len() not supported for objects of type STRUCT")},
+		{"setmeta(1, {})", fmt.Errorf("setmeta() not supported for objects of type INTEGER")},
+		{"setmeta({}, 1)", fmt.Errorf("setmeta(): meta table must be a HASH, got INTEGER")},
+		{`let m = setmeta({}, {"__len": fn(m) { "x" }}); len(m)`, fmt.Errorf("handler __len must return INTEGER, got STRING")},
+		{`let m = setmeta({}, {"__eq": fn(a, b) { 1 }}); m == m`, fmt.Errorf("handler __eq must return BOOLEAN, got INTEGER")},
+		{`let m = setmeta({}, {"__eq": fn(a, b) { 1 }}); m != m`, fmt.Errorf("handler __eq must return BOOLEAN, got INTEGER")},
+		{"struct P { a, fn __lt(o) { 0 } }; P(1) > P(2)", fmt.Errorf("handler __lt must return BOOLEAN, got INTEGER")},
+		{`let m = setmeta({}, {"__add": 5}); m + m`, fmt.Errorf("handler __add must be a function, got INTEGER")},
+		{`let m = setmeta({}, {"__len": fn(m) { 1 / 0 }}); len(m)`, fmt.Errorf("division by zero")},
+		{`let m = setmeta({}, {"__add": fn(a, b) { "any" }}); m + m`, "any"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.input, func(t *testing.T) {
+			prog := expectParse(t, tc.input)
+			got := eval.Eval(prog, object.NewEnvironment())
+			if s, ok := got.(*object.Struct); ok {
+				got = &object.String{Value: s.String()}
+			}
+			expectLiteral(t, got, tc.expected)
+		})
+	}
+}
+
+func TestIterators(t *testing.T) {
+	cases := []struct {
+		input    string
+		expected any
+	}{
+		{"collect(range(4))", []any{0, 1, 2, 3}},
+		{"collect(range(2, 5))", []any{2, 3, 4}},
+		{"collect(range(10, 0, -3))", []any{10, 7, 4, 1}},
+		{"collect(range(3, 3))", []any{}},
+		{"collect(take(range(1000000000), 3))", []any{0, 1, 2}},
+		{"collect(map_iter([1, 2, 3], fn(x) { x * x }))", []any{1, 4, 9}},
+		{"collect(take(map_iter(range(1000000000), fn(x) { x * 2 }), 2))", []any{0, 2}},
+		{"let it = range(3); next(it); collect(it)", []any{1, 2}},
+		{"let it = iter([7]); [next(it), next(it)]", []any{[]any{7, true}, []any{nil, false}}},
+		{"fn* count() { yield(1); yield(2); yield(3) }; collect(count())", []any{1, 2, 3}},
+		{"fn* from(n) { let loop = fn(i) { yield(i); loop(i + 1) }; loop(n) }; collect(take(from(5), 3))", []any{5, 6, 7}},
+		{"fn* fib() { let f = fn(a, b) { yield(a); f(b, a + b) }; f(0, 1) }; collect(take(fib(), 8))", []any{0, 1, 1, 2, 3, 5, 8, 13}},
+		{"let g = fn*(xs) { yield(len(xs)) }; collect(g([1, 2]))", []any{2}},
+		{"fn* inner() { yield(1); yield(2) }; fn* outer() { let it = inner(); yield(next(it)[0] * 10); yield(next(it)[0] * 10) }; collect(outer())", []any{10, 20}},
+		{"fn* g() { yield(1); throw \"never\" }; next(take(g(), 1))", []any{1, true}},
+		{"fn* g() { 1 }; collect(g())", []any{}},
+		{"fn* g() { yield(1) }; let it = g(); collect(it); collect(it)", []any{}},
+		{"fn* count() { yield(1) }; count()", "iterator(count)"},
+		{"range(2)", "iterator(range)"},
+		{`struct Pair { a, b, fn* __iter() { yield(self.a); yield(self.b) } }; collect(Pair(1, 2))`, []any{1, 2}},
+		{`struct Bag { items, fn __iter() { self.items } }; collect(map_iter(Bag([1, 2]), fn(x) { x + 1 }))`, []any{2, 3}},
+		{`collect(setmeta({}, {"__iter": fn(h) { range(2) }}))`, []any{0, 1}},
+		{"fn* g() { yield(1); 1 + true }; collect(g())", fmt.Errorf("type mismatch: INTEGER + BOOLEAN")},
+		{"collect(map_iter([1], fn(x) { x + true }))", fmt.Errorf("type mismatch: INTEGER + BOOLEAN")},
+		{"fn* g(a) { yield(a) }; collect(g())", fmt.Errorf("Error invoking function: missing argument for parameter 'a'")},
+		{"yield(1)", fmt.Errorf("yield() with a value is only allowed in a generator")},
+		{"fn* g() { yield(1, 2) }; collect(g())", fmt.Errorf("yield() accepts at most 1 argument, got 2")},
+		{`let box = {}; fn* g() { yield(next(box["it"])) }; box["it"] = g(); collect(box["it"])`, fmt.Errorf("iterator(g) is already running")},
+		{"collect(1)", fmt.Errorf("INTEGER is not iterable")},
+		{"next([1])", fmt.Errorf("next() not supported for objects of type ARRAY")},
+		{"range(0, 1, 0)", fmt.Errorf("range() step must not be zero")},
+		{`range("a")`, fmt.Errorf("range() expects integers, got STRING")},
+		{"take(range(3), -1)", fmt.Errorf("take() expects a non-negative count")},
+		{`struct S { fn __iter() { 1 } }; collect(S())`, fmt.Errorf("__iter of STRUCT returned INTEGER, expected ITERATOR or ARRAY")},
+	}
+	for _, tc := range cases {
+		t.Run(tc.input, func(t *testing.T) {
+			prog := expectParse(t, tc.input)
+			got := eval.Eval(prog, object.NewEnvironment())
+			if it, ok := got.(*eval.Iterator); ok {
+				got = &object.String{Value: it.String()}
+			}
+			expectLiteral(t, got, tc.expected)
+		})
+	}
+	t.Run("cancel", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err := eval.NewInterpreter().Run(ctx, "fn* g() { yield(1); yield(2) }; let it = g(); next(it); next(it)")
+		if !errors.Is(err, object.CancelledError) {
+			t.Fatalf("expected a cancelled error, got %v", err)
+		}
+	})
+	t.Run("closed", func(t *testing.T) {
+		// a generator left suspended is closed when the program finishes
+		in := eval.NewInterpreter()
+		if _, err := in.Run(context.Background(), "fn* g() { yield(1); yield(2) }; let it = g(); next(it)"); err != nil {
+			t.Fatal(err)
+		}
+		got, err := in.Run(context.Background(), "[next(it), next(g())]")
+		if err != nil {
+			t.Fatal(err)
+		}
+		expectLiteral(t, got, []any{[]any{nil, false}, []any{1, true}})
+	})
+	t.Run("goroutines", func(t *testing.T) {
+		before := runtime.NumGoroutine()
+		for i := 0; i < 100; i++ {
+			in := eval.NewInterpreter()
+			if _, err := in.Run(context.Background(), "fn* from(n) { yield(n); from(n + 1) }; let it = from(1); next(it)"); err != nil {
+				t.Fatal(err)
+			}
+		}
+		// the goroutines of the closed generators exit on their own time
+		deadline := time.Now().Add(5 * time.Second)
+		for runtime.NumGoroutine() > before+5 {
+			if time.Now().After(deadline) {
+				t.Fatalf("expected the generators to be closed, %d goroutines are left of %d", runtime.NumGoroutine(), before)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	})
+}
+
+func TestConstAndFreeze(t *testing.T) {
+	cases := []struct {
+		input    string
+		expected any
+	}{
+		{"const x = 5; x", 5},
+		{"const [a, b] = [1, 2]; a + b", 3},
+		{"const {\"a\": a} = {\"a\": 4}; a", 4},
+		{"let f = fn() { const y = 1; y }; f() + f()", 2},
+		{"const x = 1; let x = 2", fmt.Errorf("identifier 'x' already defined")},
+		{"const [a] = [1]; let a = 2", fmt.Errorf("identifier 'a' already defined")},
+		{"freeze(1)", 1},
+		{"is_frozen({})", false},
+		{"is_frozen(freeze({}))", true},
+		{`let h = {"a": 1}; freeze(h); is_frozen(h)`, true},
+		{`let h = freeze({"a": 1}); h["a"] = 2`, fmt.Errorf("cannot modify a frozen HASH")},
+		{`let h = freeze({"a": 1}); h["b"] = 2`, fmt.Errorf("cannot modify a frozen HASH")},
+		{`let h = freeze({"a": [{"b": 1}]}); let inner = h["a"][0]; inner["b"] = 2`, fmt.Errorf("cannot modify a frozen HASH")},
+		{`let h = freeze({"a": {"b": 1}}); let g = {"x": h}; g["x"] = 2; g["x"]`, 2},
+		{`let h = {}; h["self"] = h; freeze(h); is_frozen(h["self"])`, true},
+		{"struct P { a }; let p = freeze(P({})); is_frozen(p.a)", true},
+		{"struct P { a }; let p = freeze(P(1)); p.a = 2", fmt.Errorf("cannot modify a frozen STRUCT")},
+		{"enum E { V(h) }; let E.V(h) = freeze(E.V({})); is_frozen(h)", true},
+		{"setmeta(freeze({}), {})", fmt.Errorf("cannot modify a frozen HASH")},
+		{`let f = fn(h) { h["n"] = 1 }; f(freeze({}))`, fmt.Errorf("cannot modify a frozen HASH")},
+	}
+	for _, tc := range cases {
+		t.Run(tc.input, func(t *testing.T) {
+			prog := expectParse(t, tc.input)
+			expectLiteral(t, expectEval(t, prog), tc.expected)
+		})
+	}
+	t.Run("rebind", func(t *testing.T) {
+		in := eval.NewInterpreter()
+		if _, err := in.Run(context.Background(), "const limit = 10; const [lo, hi] = [1, 2];"); err != nil {
+			t.Fatal(err)
+		}
+		for _, src := range []string{"let limit = 20;", "fn limit() { 20 }", "let hi = 3;"} {
+			_, err := in.Run(context.Background(), src)
+			if err == nil || !strings.Contains(err.Error(), "it is a constant") {
+				t.Fatalf("%s: expected a constant error, got %v", src, err)
+			}
+			if !errors.Is(err, object.NameError) {
+				t.Fatalf("%s: expected a name error, got %v", src, err)
+			}
+		}
+		got, err := in.Run(context.Background(), "limit")
+		if err != nil {
+			t.Fatal(err)
+		}
+		expectLiteral(t, got, 10)
+	})
+}
+
+func TestNullSafeOperators(t *testing.T) {
+	config := `let config = {"db": {"host": "localhost", "ports": [5432]}, "debug": false};`
+	cases := []struct {
+		input    string
+		expected any
+	}{
+		{config + `config?.["db"]?.["host"]`, "localhost"},
+		{config + `config?.["cache"]?.["host"]`, nil},
+		{config + `config?.["db"]?.["ports"]?.[0]`, 5432},
+		{config + `config?.["cache"]?.["ports"]?.[0]`, nil},
+		{config + `config["cache"]?.["host"] ?? "none"`, "none"},
+		{config + `config["debug"] ?? true`, false},
+		{config + `config["cache"]["host"]`, fmt.Errorf("indexing is only supported for arrays or hashes")},
+		{`first([])?.[1 / 0]`, nil},
+		{`[1, 2]?.[5]`, fmt.Errorf("List index out of range: 5 > 2")},
+		{`let f = fn(x) { x * 2 }; f?.(21)`, 42},
+		{`let h = {}; h["f"]?.(1)`, nil},
+		{`let h = {}; h["f"]?.(1 / 0)`, nil},
+		{`let f = fn(h) { h["f"]?.(1) }; f({})`, nil},
+		{`let f = fn(h) { h["f"]?.(1) }; f({"f": fn(x) { x + 1 }})`, 2},
+		{`struct P { x }; let p = P(1); p?.x`, 1},
+		{`struct P { x }; first([])?.x`, nil},
+		{`struct P { x }; P(first([]))?.x?.y`, nil},
+		{"1 ?? 2", 1},
+		{"first([]) ?? 2", 2},
+		{"first([]) ?? first([]) ?? 3", 3},
+		{"1 ?? 1 / 0", 1},
+		{"first([]) ?? 1 / 0", fmt.Errorf("division by zero")},
+		{"first([]) ?? 1 == 1", true},
+		{"(1 / 0) ?? 1", fmt.Errorf("division by zero")},
+	}
+	for _, tc := range cases {
+		t.Run(tc.input, func(t *testing.T) {
+			prog := expectParse(t, tc.input)
+			expectLiteral(t, expectEval(t, prog), tc.expected)
+		})
+	}
+}
+
+func TestPipelineAndArrowFunctions(t *testing.T) {
+	lists := `fn map(xs, f) { if (len(xs) == 0) { [] } else { [f(first(xs)), ...map(rest(xs), f)] } }
+	fn filter(xs, f) {
+		if (len(xs) == 0) { return [] }
+		let tail = filter(rest(xs), f);
+		if (f(first(xs))) { [first(xs), ...tail] } else { tail }
+	}
+	`
+	cases := []struct {
+		input    string
+		expected any
+	}{
+		{lists + "[1, 2, 3] |> map((x) => x * 2) |> filter((x) => x > 2)", []any{4, 6}},
+		{lists + "filter(map([1, 2, 3], (x) => x * 2), (x) => x > 2)", []any{4, 6}},
+		{"range(1, 4) |> map_iter((x) => x * x) |> collect", []any{1, 4, 9}},
+		{"let add = (a, b) => a + b; 1 |> add(2)", 3},
+		{"let inc = (x) => x + 1; 1 |> inc |> inc", 3},
+		{`"abc" |> len`, 3},
+		{"1 + 2 |> ((x) => x * 10)", 30},
+		{"let adder = (x) => (y) => x + y; adder(2)(3)", 5},
+		{"let f = (x) => { let y = x * 2; y + 1 }; f(3)", 7},
+		{`let f = (x) => ({"x": x}); f(1)["x"]`, 1},
+		{"let f = (a, b = 10) => a + b; 1 |> f(b: 2)", 3},
+		{"let f = (a, b = 10) => a + b; 1 |> f", 11},
+		{"let f = (...xs) => len(xs); 1 |> f(2, 3)", 3},
+		{"(() => 42)()", 42},
+		{"let count = (n) => if (n == 0) { 0 } else { count(n - 1) }; count(10000)", 0},
+		{`match 3 { n if (n > 1) => "big", _ => "small" }`, "big"},
+		{"let f = (x) => x / 0; 1 |> f", fmt.Errorf("division by zero")},
+		{"1 |> 2", fmt.Errorf("evalCallExpression: unknown type *object.Integer")},
+	}
+	for _, tc := range cases {
+		t.Run(tc.input, func(t *testing.T) {
+			prog := expectParse(t, tc.input)
+			expectLiteral(t, expectEval(t, prog), tc.expected)
+		})
+	}
+}
+
+func TestComprehensions(t *testing.T) {
+	cases := []struct {
+		input    string
+		expected any
+	}{
+		{"[x * 2 for x in [1, 2, 3]]", []any{2, 4, 6}},
+		{"[x * 2 for x in [-1, 2, -3, 4] if x > 0]", []any{4, 8}},
+		{"[x for x in []]", []any{}},
+		{"[[x, y] for x in [1, 2] for y in [3, 4]]", []any{[]any{1, 3}, []any{1, 4}, []any{2, 3}, []any{2, 4}}},
+		{"[x + y for x in [1, 2] if x > 1 for y in [10, 20] if y < 20]", []any{12}},
+		{"[y for xs in [[1, 2], [3]] for y in xs]", []any{1, 2, 3}},
+		{"[...xs for xs in [[1, 2], [3]]]", []any{1, 2, 3}},
+		{"[a + b for a, b in [[1, 2], [3, 4]]]", []any{3, 7}},
+		{"[a for [a, b = 5] in [[1], [2, 3]] if b == 5]", []any{1}},
+		{"[x for x in range(4)]", []any{0, 1, 2, 3}},
+		{"fn* g() { yield(1); yield(2) }; [x * 10 for x in g()]", []any{10, 20}},
+		{`[[k, v] for k, v in {"a": 1}]`, []any{[]any{"a", 1}}},
+		{`let h = {x: x * x for x in [1, 2, 3]}; [h[1], h[2], h[3], len([p for p in h])]`, []any{1, 4, 9, 3}},
+		{`let h = {"a": 1, "b": 2}; let g = {k: v + 1 for k, v in h}; [g["a"], g["b"]]`, []any{2, 3}},
+		{`{v: k for k, v in {"a": 1} if v > 5}`, "{}"},
+		{"let fs = [fn() { x } for x in [1, 2]]; [fs[0](), fs[1]()]", []any{1, 2}},
+		{"let f = fn(n) { [x * n for x in [1, 2]] }; f(3)", []any{3, 6}},
+		{"let f = fn(xs) { let n = 2; [x * n + m for x in xs for m in [0, 1]] }; f([1, 2])", []any{2, 3, 4, 5}},
+		{"let x = 10; [x for x in [1]]; x", 10},
+		{"[if (x > 1) { let y = x; y } else { 0 } for x in [1, 2]]", []any{0, 2}},
+		{"[x for x in 5]", fmt.Errorf("INTEGER is not iterable")},
+		{"[x / 0 for x in [1]]", fmt.Errorf("division by zero")},
+		{"[x for [x] in [1]]", fmt.Errorf("cannot destructure INTEGER as an array")},
+		{"[x for x in [1]]; x", fmt.Errorf("identifier 'x' not defined")},
+		{"[x for x, x in [[1, 2]]]", fmt.Errorf("identifier 'x' already defined")},
+	}
+	for _, tc := range cases {
+		t.Run(tc.input, func(t *testing.T) {
+			prog := expectParse(t, tc.input)
+			got := expectEval(t, prog)
+			if h, ok := got.(*object.Hash); ok {
+				got = &object.String{Value: h.String()}
+			}
+			expectLiteral(t, got, tc.expected)
+		})
+	}
+}
+
+func TestMacros(t *testing.T) {
+	cases := []struct {
+		input    string
+		expected any
+	}{
+		{"quote(1 + x)", "QUOTE((1 + x))"},
+		{"quote(unquote(1 + 2) + x)", "QUOTE((3 + x))"},
+		{"let a = 4; quote(unquote(a) * unquote(true))", "QUOTE((4 * true))"},
+		{`quote(unquote("s"))`, `QUOTE("s")`},
+		{"let q = quote(1 + 2); quote(unquote(q) * 3)", "QUOTE(((1 + 2) * 3))"},
+		{"quote(unquote([1, quote(x)]))", "QUOTE([1, x])"},
+		{"let quote = fn(x) { x * 2 }; quote(2)", 4},
+		// quote may be the value of a function
+		{"let q = fn() { quote(1 + 1) }; q()", "QUOTE((1 + 1))"},
+		{"let q = fn(x) { return quote(unquote(x) * 2) }; q(3)", "QUOTE((3 * 2))"},
+		{`
+			let unless = macro(cond, then, otherwise) {
+				quote(if (!(unquote(cond))) { unquote(then) } else { unquote(otherwise) })
+			};
+			unless(10 > 5, "not greater", "greater")`, "greater"},
+		// the template is expanded afresh for every call
+		{"let inc = macro(x) { quote(unquote(x) + 1) }; inc(1) * inc(2)", 6},
+		{"let inc = macro(x) { quote(unquote(x) + 1) }; let double = macro(x) { quote(inc(unquote(x)) * 2) }; double(inc(1))", 6},
+		// arguments are not evaluated unless the expansion does so
+		{"let ignore = macro(x) { quote(1) }; ignore(1 / 0)", 1},
+		{"let twice = macro(x) { quote([unquote(x), unquote(x)]) }; let n = 2; twice(n + 1)", []any{3, 3}},
+		// bindings made by the expansion don't capture the caller's
+		{"let twice = macro(x) { quote(fn() { let v = unquote(x); v + v }()) }; let v = 10; twice(v + 1)", 22},
+		{"let at_zero = macro(e) { quote(fn(x) { unquote(e) }(0)) }; let x = 5; at_zero(x * 2)", 10},
+		{"let m = macro(x) { if (true) { return quote(unquote(x) - 1) }; quote(0) }; m(5)", 4},
+		// field names are not variables, so they are not renamed
+		{"struct P { x }; let getx = macro(p) { quote(fn() { let x = unquote(p); x.x }()) }; getx(P(7))", 7},
+		// keyword arguments follow the parameters they name
+		{"let m = macro(v) { quote(fn(a, b = 2) { a + b }(unquote(v), b: 10)) }; let b = 1; m(b)", 11},
+		{"let f = fn(a, b) { a - b }; let m = macro(v) { quote(fn(a) { f(b: a, a: unquote(v)) }(1)) }; m(5)", 4},
+		{"let m = macro(v) { quote(fn() { let g = fn(a) { a * 2 }; g(a: unquote(v)) }()) }; let a = 4; m(a)", 8},
+		{"m(1); let m = macro(x) { x }", 1},
+		{"fn() { let m = macro(x) { x }; 1 }()", fmt.Errorf("macros can only be defined by a top-level let")},
+		{"unquote(1)", fmt.Errorf("unquote() is only allowed inside quote()")},
+		{"let m = macro(x) { x }; m(1, 2)", fmt.Errorf("macro m expects 1 arguments but received 2")},
+		{"let m = macro(x) { x }; m(x: 1)", fmt.Errorf("macro m does not accept keyword arguments")},
+		{"let m = macro() { 1 }; m()", fmt.Errorf("macro m returned INTEGER, expected QUOTE")},
+		{"let m = macro() { 1 + true }; m()", fmt.Errorf("type mismatch: INTEGER + BOOLEAN")},
+		{"let m = macro() { quote(unquote(fn() { 1 })) }; m()", fmt.Errorf("cannot unquote a value of type FUNCTION")},
+		{"let loop = macro(x) { quote(loop(unquote(x))) }; loop(1)", fmt.Errorf("macro expansion of loop is nested too deeply")},
+	}
+	for _, tc := range cases {
+		t.Run(tc.input, func(t *testing.T) {
+			got, err := eval.NewInterpreter().Run(context.Background(), tc.input)
+			if err != nil {
+				if e, ok := tc.expected.(error); !ok || err.Error() != e.Error() {
+					t.Fatalf("expected %v, got error %q", tc.expected, err)
+				}
+				return
+			}
+			if q, ok := got.(*object.Quote); ok {
+				got = &object.String{Value: q.String()}
+			}
+			expectLiteral(t, got, tc.expected)
+		})
+	}
+
+	t.Run("persist", func(t *testing.T) {
+		in := eval.NewInterpreter()
+		if _, err := in.Run(context.Background(), "let m = macro(x) { quote(unquote(x) * 10) }"); err != nil {
+			t.Fatal(err)
+		}
+		got, err := in.Run(context.Background(), "m(4)")
+		if err != nil {
+			t.Fatal(err)
+		}
+		expectLiteral(t, got, 40)
+	})
+	t.Run("positions", func(t *testing.T) {
+		cases := []struct {
+			input string
+			pos   token.Pos
+		}{
+			// errors in expansion point at the call
+			{"let m = macro(x) { 1 };\nlet a = m(1)", token.Pos{Line: 2, Col: 9}},
+			// errors in the expanded code point at the source it came from
+			{"let id = macro(x) { x };\nid(1 + true)", token.Pos{Line: 2, Col: 6}},
+			{"let m = macro(x) { quote(unquote(x) / 0) };\nm(1)", token.Pos{Line: 1, Col: 37}},
+		}
+		for _, tc := range cases {
+			_, err := eval.NewInterpreter().Run(context.Background(), tc.input)
+			var e *object.Error
+			if !errors.As(err, &e) {
+				t.Fatalf("%q: expected an error, got %v", tc.input, err)
+			}
+			if e.Pos != tc.pos {
+				t.Fatalf("%q: expected the error at %s, got %s", tc.input, tc.pos, e.Pos)
+			}
+		}
+	})
+}
+
+func TestTryCatch(t *testing.T) {
+	cases := []struct {
+		input    string
+		expected any
+	}{
+		{`try { 1 } catch (e) { 2 }`, 1},
+		{`try { 1 + true } catch (e) { 2 }`, 2},
+		{`try { throw "boom" } catch (e) { e["message"] }`, "boom"},
+		{`try { throw 42 } catch (e) { e["value"] + 1 }`, 43},
+		{`try { throw 42 } catch (e) { e["kind"] }`, "ThrownError"},
+		{`try { [1][5] } catch (e) { e["message"] }`, "List index out of range: 5 > 1"},
+		{`try { [1][5] } catch (e) { e["kind"] }`, "IndexError"},
+		{"try {\n  1 +\n true } catch (e) { [e[\"line\"], e[\"col\"]] }", []any{2, 5}},
+		{`try { len(1) } catch (e) { e["message"] }`, "len() not supported for objects of type INTEGER"},
+		{`let f = fn() { throw "inner" }; try { f() } catch (e) { len(e["stack"]) }`, 1},
+		{`try { throw "x" } catch { 3 }`, 3},
+		{`throw "boom"`, fmt.Errorf("boom")},
+		{`try { throw "a" } catch (e) { throw e }`, fmt.Errorf("a")},
+		{`try { throw "a" } catch (e) { 1 + true }`, fmt.Errorf("type mismatch: INTEGER + BOOLEAN")},
+		{`let h = {}; try { 1 } finally { h["done"] = true }; h["done"]`, true},
+		{`let h = {}; try { throw "a" } finally { h["done"] = true }`, fmt.Errorf("a")},
+		{`let h = {}; try { throw "a" } catch (e) { 1 } finally { h["done"] = true }; h["done"]`, true},
+		{`let f = fn() { try { return 1 } finally { 2 } }; f()`, 1},
+		{`let f = fn() { try { return 1 } finally { return 2 } }; f()`, 2},
+		{`let f = fn(x) { try { if x { throw "no" }; x } catch (e) { e["message"] } }; f(true)`, "no"},
+		{`let f = fn() { try { return 1 + "a" } catch (e) { "caught" } }; f()`, "caught"},
+		{`let f = fn() { try { return throw "a" } catch (e) { e["message"] } }; f()`, "a"},
+		{`let g = fn() { 1 / 0 }; let f = fn() { try { return g() } catch (e) { e["kind"] } }; f()`, "ZeroDivisionError"},
+		{`try { return 1 / 0 } catch (e) { 1 }`, 1},
+		// the parameter is only bound in the catch block
+		{`let e = 1; try { throw 2 } catch (e) { 0 }; e`, 1},
+		{`const e = 1; try { throw 2 } catch (e) { e["value"] }`, 2},
+		{`fn() { let e = 1; try { throw 2 } catch (e) { 0 }; e }()`, 1},
+		{`let fs = [try { throw x } catch (e) { fn() { e["value"] } } for x in [1, 2]]; fs[0]() + fs[1]()`, 3},
+		{`try { throw 2 } catch (e) { 0 }; e`, fmt.Errorf("identifier 'e' not defined")},
+		{`let f = fn() { try { return 1 / 0 } finally { 2 } }; f()`, fmt.Errorf("division by zero")},
+		{`let f = fn(x) { try { g(x) } catch (e) { -1 } }; let g = fn(x) { x / 0 }; f(1)`, -1},
+	}
+	for _, tc := range cases {
+		t.Run(tc.input, func(t *testing.T) {
+			prog := expectParse(t, tc.input)
+			got := expectEval(t, prog)
+			expectLiteral(t, got, tc.expected)
+		})
+	}
+}
+
+func TestTryDoesNotCatchCancellation(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	env := object.NewEnvironment().WithContext(ctx)
+	got := eval.Eval(expectParse(t, `let f = fn() { 1 }; try { f() } catch (e) { 2 }`), env)
+	expectErrorMessage(t, got, "evaluation cancelled: context canceled")
+}
+
+func BenchmarkFib(b *testing.B) {
+	prog, _ := parser.New("let fib = fn(n) { if n < 2 { n } else { fib(n - 1) + fib(n - 2) } }; fib(15)").Parse()
+	for i := 0; i < b.N; i++ {
+		eval.Eval(prog, object.NewEnvironment())
+	}
+}
+
+// BenchmarkBuildList builds a list with push and walks it with rest, which
+// take constant time, so the time per element should not grow with n.
+func BenchmarkBuildList(b *testing.B) {
+	for _, n := range []int{100, 1000, 10000} {
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			prog, _ := parser.New(fmt.Sprintf(`
+				let build = fn(xs, n) { if n == 0 { xs } else { build(push(xs, n), n - 1) } };
+				let sum = fn(xs, acc) { if len(xs) == 0 { acc } else { sum(rest(xs), acc + first(xs)) } };
+				sum(build([], %d), 0)`, n)).Parse()
+			for i := 0; i < b.N; i++ {
+				eval.Eval(prog, object.NewEnvironment())
+			}
+		})
+	}
+}
+
 func expectParse(t *testing.T, input string) *ast.Program {
 	t.Helper()
 	prog, errs := parser.New(input).Parse()
@@ -344,11 +1369,11 @@ func expectLiteral(t *testing.T, got object.Object, expected any) {
 		if !ok {
 			t.Fatalf("not an array, got %T", got)
 		}
-		if len(arr.Elems) != len(e) {
-			t.Fatalf("length mismatch - got %d expected %d elements", len(arr.Elems), len(e))
+		if arr.Len() != len(e) {
+			t.Fatalf("length mismatch - got %d expected %d elements", arr.Len(), len(e))
 		}
 		for i, exp := range e {
-			got := arr.Elems[i]
+			got := arr.At(i)
 			expectLiteral(t, got, exp)
 		}
 	default:
@@ -0,0 +1,450 @@
+package eval
+
+import (
+	"container/heap"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/kvalv/monkey/object"
+)
+
+// Clock is the source of time for the event loop.
+type Clock interface {
+	Now() time.Time
+	// Sleep blocks until d has passed or ctx is done.
+	Sleep(ctx context.Context, d time.Duration) error
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+func (realClock) Sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// VirtualClock is a Clock that never waits: sleeping simply moves its time
+// forward. It lets tests run timers instantly and deterministically.
+type VirtualClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func NewVirtualClock(start time.Time) *VirtualClock { return &VirtualClock{now: start} }
+
+func (c *VirtualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+func (c *VirtualClock) Sleep(ctx context.Context, d time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	c.Advance(d)
+	return nil
+}
+
+// Advance moves the clock forward by d.
+func (c *VirtualClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+type timer struct {
+	id       int64
+	when     time.Time
+	seq      int64 // orders timers that are due at the same time
+	fn       object.Object
+	interval time.Duration // zero for timeouts
+}
+
+type timerHeap []*timer
+
+func (h timerHeap) Len() int { return len(h) }
+func (h timerHeap) Less(i, j int) bool {
+	if h[i].when.Equal(h[j].when) {
+		return h[i].seq < h[j].seq
+	}
+	return h[i].when.Before(h[j].when)
+}
+func (h timerHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *timerHeap) Push(x any)   { *h = append(*h, x.(*timer)) }
+func (h *timerHeap) Pop() any {
+	old := *h
+	t := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return t
+}
+
+// eventLoop runs callbacks one at a time on the goroutine that calls run.
+// Callbacks may be scheduled from any goroutine. Promise reactions are queued
+// as microtasks, which all run before the next timer fires.
+type eventLoop struct {
+	clock Clock
+
+	mu         sync.Mutex
+	seq        int64
+	timers     timerHeap
+	cleared    map[int64]bool
+	microtasks []func(env *object.Environment)
+}
+
+func newEventLoop(clock Clock) *eventLoop {
+	return &eventLoop{clock: clock, cleared: make(map[int64]bool)}
+}
+
+// WithEventLoop returns a context under which the timer and promise builtins
+// work, and a function that runs the event loop until no timers or promise
+// callbacks are pending. Interpreter.RunProgram does this itself; it is for
+// hosts that run programs by other means, such as the vm. A nil clock is the
+// real clock.
+func WithEventLoop(ctx context.Context, clock Clock) (context.Context, func(env *object.Environment) error) {
+	if clock == nil {
+		clock = realClock{}
+	}
+	loop := newEventLoop(clock)
+	return context.WithValue(ctx, loopKey, loop), func(env *object.Environment) error {
+		if err := loop.run(env); err != nil {
+			return err
+		}
+		return nil
+	}
+}
+
+func loopFrom(ctx context.Context) (*eventLoop, bool) {
+	loop, ok := ctx.Value(loopKey).(*eventLoop)
+	return loop, ok
+}
+
+func (l *eventLoop) schedule(fn object.Object, after time.Duration, repeat bool) int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.seq++
+	t := &timer{id: l.seq, seq: l.seq, fn: fn, when: l.clock.Now().Add(after)}
+	if repeat {
+		t.interval = after
+	}
+	heap.Push(&l.timers, t)
+	return t.id
+}
+
+func (l *eventLoop) clear(id int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.cleared[id] = true
+}
+
+func (l *eventLoop) enqueue(task func(env *object.Environment)) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.microtasks = append(l.microtasks, task)
+}
+
+// run drains the loop: it runs pending microtasks, then waits for the next
+// timer and runs its callback, until nothing is left to do.
+func (l *eventLoop) run(env *object.Environment) *object.Error {
+	ctx := env.Context()
+	for {
+		l.runMicrotasks(env)
+		t := l.next()
+		if t == nil {
+			return nil
+		}
+		if d := t.when.Sub(l.clock.Now()); d > 0 {
+			if err := l.clock.Sleep(ctx, d); err != nil {
+				return object.NewError(object.CancelledError, "evaluation cancelled: %v", err)
+			}
+		}
+		if l.isCleared(t.id) {
+			continue
+		}
+		if res := applyFunction(t.fn, nil, env); object.IsError(res) {
+			return res.(*object.Error)
+		}
+		if t.interval > 0 && !l.isCleared(t.id) {
+			l.mu.Lock()
+			l.seq++
+			t.when, t.seq = t.when.Add(t.interval), l.seq
+			heap.Push(&l.timers, t)
+			l.mu.Unlock()
+		}
+	}
+}
+
+// runMicrotasks runs promise reactions until none are left. Reactions never
+// fail; errors in handlers reject the promise returned by then.
+func (l *eventLoop) runMicrotasks(env *object.Environment) {
+	for {
+		l.mu.Lock()
+		if len(l.microtasks) == 0 {
+			l.mu.Unlock()
+			return
+		}
+		task := l.microtasks[0]
+		l.microtasks = l.microtasks[1:]
+		l.mu.Unlock()
+		task(env)
+	}
+}
+
+// next pops the earliest timer that hasn't been cleared
+func (l *eventLoop) next() *timer {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for l.timers.Len() > 0 {
+		t := heap.Pop(&l.timers).(*timer)
+		if !l.cleared[t.id] {
+			return t
+		}
+	}
+	return nil
+}
+
+func (l *eventLoop) isCleared(id int64) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.cleared[id]
+}
+
+type promiseState int
+
+const (
+	pending promiseState = iota
+	fulfilled
+	rejected
+)
+
+// Promise is the eventual result of an asynchronous operation. Reactions
+// registered with then run on the event loop once the promise settles.
+type Promise struct {
+	loop *eventLoop
+
+	mu        sync.Mutex
+	state     promiseState
+	value     object.Object
+	reactions []func(state promiseState, value object.Object)
+}
+
+func newPromise(loop *eventLoop) *Promise { return &Promise{loop: loop} }
+
+func (p *Promise) Type() object.Type { return object.PROMISE_OBJ }
+func (p *Promise) String() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	switch p.state {
+	case fulfilled:
+		return fmt.Sprintf("promise(fulfilled: %s)", p.value)
+	case rejected:
+		return fmt.Sprintf("promise(rejected: %s)", p.value)
+	default:
+		return "promise(pending)"
+	}
+}
+
+func (p *Promise) settle(state promiseState, value object.Object) {
+	p.mu.Lock()
+	if p.state != pending {
+		p.mu.Unlock()
+		return
+	}
+	p.state, p.value = state, value
+	reactions := p.reactions
+	p.reactions = nil
+	p.mu.Unlock()
+	for _, r := range reactions {
+		r(state, value)
+	}
+}
+
+// resolve fulfills p with value, or adopts the state of value if it is
+// itself a promise.
+func (p *Promise) resolve(value object.Object) {
+	if other, ok := value.(*Promise); ok {
+		other.onSettled(func(state promiseState, v object.Object) { p.settle(state, v) })
+		return
+	}
+	p.settle(fulfilled, value)
+}
+
+func (p *Promise) onSettled(fn func(state promiseState, value object.Object)) {
+	p.mu.Lock()
+	if p.state == pending {
+		p.reactions = append(p.reactions, fn)
+		p.mu.Unlock()
+		return
+	}
+	state, value := p.state, p.value
+	p.mu.Unlock()
+	fn(state, value)
+}
+
+// then schedules a handler once p settles and returns a promise for the
+// handler's result. A missing handler passes the outcome through.
+func (p *Promise) then(onFulfilled, onRejected object.Object) *Promise {
+	next := newPromise(p.loop)
+	p.onSettled(func(state promiseState, value object.Object) {
+		p.loop.enqueue(func(env *object.Environment) {
+			handler := onFulfilled
+			if state == rejected {
+				handler = onRejected
+			}
+			if handler == nil {
+				next.settle(state, value)
+				return
+			}
+			if err, ok := value.(*object.Error); ok {
+				// the handler has caught the error, so it gets it as a value
+				value = &object.ErrorValue{Err: err}
+			}
+			res := applyFunction(handler, []object.Object{value}, env)
+			if object.IsError(res) {
+				next.settle(rejected, res)
+				return
+			}
+			next.resolve(res)
+		})
+	})
+	return next
+}
+
+func settleFn(p *Promise, state promiseState) *object.Builtin {
+	return &object.Builtin{
+		Fn: func(env *object.Environment, args ...object.Object) object.Object {
+			value := object.Object(object.NULL)
+			if len(args) > 0 {
+				value = args[0]
+			}
+			if state == fulfilled {
+				p.resolve(value)
+			} else {
+				p.settle(rejected, value)
+			}
+			return object.NULL
+		},
+	}
+}
+
+func requireLoop(name string, env *object.Environment) (*eventLoop, *object.Error) {
+	loop, ok := loopFrom(env.Context())
+	if !ok {
+		return nil, object.NewError(object.RuntimeError, "%s() requires an event loop; evaluate with Interpreter.Run", name)
+	}
+	return loop, nil
+}
+
+func timerBuiltin(name string, repeat bool) *object.Builtin {
+	return &object.Builtin{
+		Fn: func(env *object.Environment, args ...object.Object) object.Object {
+			loop, err := requireLoop(name, env)
+			if err != nil {
+				return err
+			}
+			if len(args) != 2 {
+				return object.NewError(object.ArgumentError, "%s() accepts 2 arguments, got %d", name, len(args))
+			}
+			ms, ok := args[1].(*object.Integer)
+			if !ok || ms.Value < 0 || (repeat && ms.Value == 0) {
+				return object.NewError(object.ArgumentError, "%s() expects a positive delay in milliseconds", name)
+			}
+			id := loop.schedule(args[0], time.Duration(ms.Value)*time.Millisecond, repeat)
+			return &object.Integer{Value: id}
+		},
+	}
+}
+
+func init() {
+	builtin["set_timeout"] = timerBuiltin("set_timeout", false)
+	builtin["set_interval"] = timerBuiltin("set_interval", true)
+	builtin["clear_timer"] = &object.Builtin{
+		Fn: func(env *object.Environment, args ...object.Object) object.Object {
+			loop, err := requireLoop("clear_timer", env)
+			if err != nil {
+				return err
+			}
+			if len(args) != 1 {
+				return object.NewError(object.ArgumentError, "clear_timer() accepts 1 argument, got %d", len(args))
+			}
+			id, ok := args[0].(*object.Integer)
+			if !ok {
+				return object.ErrorExpected(object.INTEGER_OBJ)
+			}
+			loop.clear(id.Value)
+			return object.NULL
+		},
+	}
+	builtin["now"] = &object.Builtin{
+		Fn: func(env *object.Environment, args ...object.Object) object.Object {
+			loop, err := requireLoop("now", env)
+			if err != nil {
+				return err
+			}
+			return &object.Integer{Value: loop.clock.Now().UnixMilli()}
+		},
+	}
+	builtin["promise"] = &object.Builtin{
+		Fn: func(env *object.Environment, args ...object.Object) object.Object {
+			loop, err := requireLoop("promise", env)
+			if err != nil {
+				return err
+			}
+			if len(args) != 1 {
+				return object.NewError(object.ArgumentError, "promise() accepts 1 argument, got %d", len(args))
+			}
+			p := newPromise(loop)
+			res := applyFunction(args[0], []object.Object{settleFn(p, fulfilled), settleFn(p, rejected)}, env)
+			if object.IsError(res) {
+				p.settle(rejected, res)
+			}
+			return p
+		},
+	}
+	builtin["delay"] = &object.Builtin{
+		Fn: func(env *object.Environment, args ...object.Object) object.Object {
+			loop, err := requireLoop("delay", env)
+			if err != nil {
+				return err
+			}
+			if len(args) < 1 || len(args) > 2 {
+				return object.NewError(object.ArgumentError, "delay() accepts 1 or 2 arguments, got %d", len(args))
+			}
+			ms, ok := args[0].(*object.Integer)
+			if !ok || ms.Value < 0 {
+				return object.NewError(object.ArgumentError, "delay() expects a non-negative delay in milliseconds")
+			}
+			p := newPromise(loop)
+			resolve := settleFn(p, fulfilled)
+			loop.schedule(&object.Builtin{
+				Fn: func(env *object.Environment, _ ...object.Object) object.Object {
+					return resolve.Fn(env, args[1:]...)
+				},
+			}, time.Duration(ms.Value)*time.Millisecond, false)
+			return p
+		},
+	}
+	builtin["then"] = &object.Builtin{
+		Fn: func(env *object.Environment, args ...object.Object) object.Object {
+			if len(args) < 2 || len(args) > 3 {
+				return object.NewError(object.ArgumentError, "then() accepts 2 or 3 arguments, got %d", len(args))
+			}
+			p, ok := args[0].(*Promise)
+			if !ok {
+				return object.NewError(object.TypeError, "then() not supported for objects of type %s", args[0].Type())
+			}
+			var onRejected object.Object
+			if len(args) == 3 {
+				onRejected = args[2]
+			}
+			return p.then(args[1], onRejected)
+		},
+	}
+}
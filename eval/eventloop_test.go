@@ -0,0 +1,106 @@
+package eval_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/kvalv/monkey/eval"
+	"github.com/kvalv/monkey/object"
+)
+
+func TestEventLoop(t *testing.T) {
+	cases := []struct {
+		input    string
+		expected any
+	}{
+		{`
+			let log = {"calls": []};
+			let record = fn(x) { log["calls"] = push(log["calls"], [x, now()]) };
+			set_timeout(fn() { record("b") }, 200);
+			set_timeout(fn() { record("a") }, 100);
+			set_timeout(fn() { record("c") }, 200);
+			log
+		`, `{calls: [[a, 100], [b, 200], [c, 200]]}`},
+		{`
+			let log = {"n": 0};
+			let id = set_interval(fn() {
+				log["n"] = log["n"] + 1;
+				if (log["n"] == 3) { clear_timer(id) }
+			}, 50);
+			log
+		`, `{n: 3}`},
+		{`
+			let log = {};
+			let p = promise(fn(resolve, reject) { set_timeout(fn() { resolve(20) }, 10) });
+			let q = then(p, fn(x) { x + 1 });
+			then(q, fn(x) { log["result"] = [x, now()] });
+			log
+		`, `{result: [21, 10]}`},
+		{`
+			let log = {};
+			let p = then(delay(5, 1), fn(x) { x + true });
+			then(p, fn(x) { log["ok"] = x }, fn(e) { log["err"] = e });
+			log
+		`, `{err: error: type mismatch: INTEGER + BOOLEAN}`},
+		{`
+			let log = {};
+			let p = promise(fn(resolve, reject) { reject("nope") });
+			then(then(p, fn(x) { x }), fn(x) { log["ok"] = x }, fn(e) { log["err"] = e });
+			log
+		`, `{err: nope}`},
+		{`
+			let log = {};
+			let p = then(delay(1), fn(_) { delay(10, "inner") });
+			then(p, fn(x) { log["result"] = [x, now()] });
+			log
+		`, `{result: [inner, 11]}`},
+	}
+	for _, tc := range cases {
+		t.Run(tc.input, func(t *testing.T) {
+			in := eval.NewInterpreter(eval.WithClock(eval.NewVirtualClock(time.UnixMilli(0))))
+			got, err := in.Run(context.Background(), tc.input)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got.String() != tc.expected {
+				t.Fatalf("expected %s, got %s", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestEventLoopErrors(t *testing.T) {
+	cases := []struct {
+		input    string
+		expected string
+	}{
+		{`set_timeout(fn() { 1 + true }, 10); 1`, "type mismatch: INTEGER + BOOLEAN"},
+		{`set_timeout(1, 10)`, "evalCallExpression: unknown type *object.Integer"},
+		{`set_interval(fn() { 1 }, 0)`, "set_interval() expects a positive delay in milliseconds"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.input, func(t *testing.T) {
+			in := eval.NewInterpreter(eval.WithClock(eval.NewVirtualClock(time.UnixMilli(0))))
+			_, err := in.Run(context.Background(), tc.input)
+			if err == nil || err.Error() != tc.expected {
+				t.Fatalf("expected error %q, got %v", tc.expected, err)
+			}
+		})
+	}
+
+	t.Run("without interpreter", func(t *testing.T) {
+		got := eval.Eval(expectParse(t, "set_timeout(fn() { 1 }, 1)"), object.NewEnvironment())
+		expectLiteral(t, got, fmt.Errorf("set_timeout() requires an event loop; evaluate with Interpreter.Run"))
+	})
+
+	t.Run("cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		_, err := eval.NewInterpreter().Run(ctx, "set_timeout(fn() { 1 }, 60000)")
+		if err == nil || err.Error() != "evaluation cancelled: context deadline exceeded" {
+			t.Fatalf("expected cancellation, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,75 @@
+package eval
+
+import "github.com/kvalv/monkey/object"
+
+func init() {
+	builtin["freeze"] = &object.Builtin{
+		Fn: func(env *object.Environment, args ...object.Object) object.Object {
+			if len(args) != 1 {
+				return object.NewError(object.ArgumentError, "freeze() accepts 1 argument, got %d", len(args))
+			}
+			freeze(args[0])
+			return args[0]
+		},
+	}
+	builtin["is_frozen"] = &object.Builtin{
+		Fn: func(env *object.Environment, args ...object.Object) object.Object {
+			if len(args) != 1 {
+				return object.NewError(object.ArgumentError, "is_frozen() accepts 1 argument, got %d", len(args))
+			}
+			return nativeBoolToBoolean(frozen(args[0]))
+		},
+	}
+}
+
+// freeze makes obj and every value reachable from it immutable. Arrays
+// cannot be modified to begin with, so only their elements are frozen. A
+// value that is frozen already is skipped, along with everything it holds,
+// which is what stops freeze on a cycle.
+func freeze(obj object.Object) {
+	switch o := obj.(type) {
+	case *object.Hash:
+		if o.Frozen() {
+			return
+		}
+		o.Freeze()
+		for _, pair := range o.Pairs() {
+			freeze(pair.Key)
+			freeze(pair.Value)
+		}
+	case *object.Struct:
+		if o.Frozen() {
+			return
+		}
+		o.Freeze()
+		for _, v := range o.Values() {
+			freeze(v)
+		}
+	case *object.Array:
+		for i := 0; i < o.Len(); i++ {
+			freeze(o.At(i))
+		}
+	case *object.EnumValue:
+		for _, v := range o.Values {
+			freeze(v)
+		}
+	}
+}
+
+// frozen reports whether obj itself cannot be modified: hashes and structs
+// once they are frozen, and every other value, which has no way to be
+// modified. The values it holds are not looked at.
+func frozen(obj object.Object) bool {
+	switch o := obj.(type) {
+	case *object.Hash:
+		return o.Frozen()
+	case *object.Struct:
+		return o.Frozen()
+	}
+	return true
+}
+
+// frozenError is the error for an attempt to modify obj, which is frozen.
+func frozenError(obj object.Object) *object.Error {
+	return object.NewError(object.TypeError, "cannot modify a frozen %s", obj.Type())
+}
@@ -0,0 +1,155 @@
+package eval
+
+import (
+	"context"
+	"sync"
+
+	"github.com/kvalv/monkey/object"
+)
+
+// generator is the body of a call of a generator function. The body runs in
+// a goroutine of its own, which takes turns with whoever consumes the
+// iterator: next resumes the body and waits until it yields a value or
+// finishes, and yield hands the value over and waits to be resumed. Only
+// one of them runs at a time, so the body runs as part of the task that
+// consumes it.
+//
+// A generator that is abandoned before it finishes keeps its goroutine
+// until it is closed, which Interpreter.RunProgram does to the generators
+// the program started once it finishes, or until the context of the
+// evaluation is done. A closed generator is exhausted, as if it had returned.
+type generator struct {
+	resume   chan struct{}
+	yields   chan object.Object
+	finished chan object.Object
+
+	cancel    context.CancelFunc
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// close stops the body of g, if it has not finished yet.
+func (g *generator) close() {
+	g.closeOnce.Do(func() {
+		close(g.closed)
+		g.cancel()
+	})
+}
+
+// generators are the generators that a program has started and that have
+// not finished yet.
+type generators struct {
+	mu   sync.Mutex
+	open map[*generator]bool
+}
+
+// withGenerators returns a context that keeps track of the generators started
+// under it, and a function that closes the ones that have not finished.
+func withGenerators(ctx context.Context) (context.Context, func()) {
+	gs := &generators{open: make(map[*generator]bool)}
+	return context.WithValue(ctx, generatorsKey, gs), func() {
+		gs.mu.Lock()
+		open := gs.open
+		gs.open = make(map[*generator]bool)
+		gs.mu.Unlock()
+		for g := range open {
+			g.close()
+		}
+	}
+}
+
+func (gs *generators) add(g *generator) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	gs.open[g] = true
+}
+
+func (gs *generators) remove(g *generator) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	delete(gs.open, g)
+}
+
+// newGenerator returns the iterator over the values yielded by a call of
+// fn. The body does not start running until the first value is asked for.
+func newGenerator(fn *object.Function, args []object.Object, kwargs map[string]object.Object, env *object.Environment) *Iterator {
+	g := &generator{
+		resume:   make(chan struct{}),
+		yields:   make(chan object.Object),
+		finished: make(chan object.Object, 1),
+		closed:   make(chan struct{}),
+	}
+	name := fn.Name
+	if name == "" {
+		name = "generator"
+	}
+	started := false
+	return newIterator(name, func(caller *object.Environment) (object.Object, bool, object.Object) {
+		ctx := caller.Context()
+		if !started {
+			started = true
+			gctx, cancel := context.WithCancel(context.WithValue(env.Context(), generatorKey, g))
+			g.cancel = cancel
+			gs, track := ctx.Value(generatorsKey).(*generators)
+			if track {
+				gs.add(g)
+			}
+			go func() {
+				defer cancel()
+				res := evalFunctionCall(fn, args, kwargs, env.WithContext(gctx))
+				if track {
+					gs.remove(g)
+				}
+				g.finished <- res
+			}()
+		} else {
+			select {
+			case g.resume <- struct{}{}:
+			case <-g.closed:
+				return nil, false, nil
+			case <-ctx.Done():
+				return nil, false, object.NewError(object.CancelledError, "next(): evaluation cancelled: %v", ctx.Err())
+			}
+		}
+		select {
+		case value := <-g.yields:
+			return value, true, nil
+		case res := <-g.finished:
+			if object.IsError(res) {
+				return nil, false, res
+			}
+			return nil, false, nil
+		case <-ctx.Done():
+			return nil, false, object.NewError(object.CancelledError, "next(): evaluation cancelled: %v", ctx.Err())
+		}
+	})
+}
+
+// yield hands value to the consumer of g and waits until it asks for the
+// next one.
+func (g *generator) yield(ctx context.Context, value object.Object) object.Object {
+	select {
+	case g.yields <- value:
+	case <-ctx.Done():
+		return object.NewError(object.CancelledError, "yield(): evaluation cancelled: %v", ctx.Err())
+	}
+	select {
+	case <-g.resume:
+		return object.NULL
+	case <-ctx.Done():
+		return object.NewError(object.CancelledError, "yield(): evaluation cancelled: %v", ctx.Err())
+	}
+}
+
+// yieldValue is yield(value), which yields value from the generator that is
+// running under ctx. yield() without a value lets other tasks run instead.
+func yieldValue(ctx context.Context, args []object.Object) object.Object {
+	if len(args) != 1 {
+		return object.NewError(object.ArgumentError, "yield() accepts at most 1 argument, got %d", len(args))
+	}
+	g, ok := ctx.Value(generatorKey).(*generator)
+	if !ok {
+		return object.NewError(object.RuntimeError, "yield() with a value is only allowed in a generator")
+	}
+	return g.yield(ctx, args[0])
+}
@@ -0,0 +1,107 @@
+package eval
+
+import (
+	"context"
+	"errors"
+
+	"github.com/kvalv/monkey/ast"
+	"github.com/kvalv/monkey/module"
+	"github.com/kvalv/monkey/object"
+	"github.com/kvalv/monkey/parser"
+)
+
+// Interpreter evaluates programs against a global environment that persists
+// between runs, and owns the event loop that runs timers and promise
+// callbacks.
+type Interpreter struct {
+	env     *object.Environment
+	clock   Clock
+	modules *modules
+}
+
+type interpreterOpt func(in *Interpreter)
+
+// WithClock sets the clock used by timers. Tests typically pass a
+// VirtualClock so that timers fire without actually waiting.
+func WithClock(c Clock) interpreterOpt {
+	return func(in *Interpreter) { in.clock = c }
+}
+
+// WithLoader makes the modules that programs import available through l.
+// Modules are cached by the interpreter, so each is evaluated once.
+func WithLoader(l module.Loader) interpreterOpt {
+	return func(in *Interpreter) { in.modules = newModules(l) }
+}
+
+// WithEnvironment makes the interpreter use env as its global environment.
+func WithEnvironment(env *object.Environment) interpreterOpt {
+	return func(in *Interpreter) { in.env = env }
+}
+
+func NewInterpreter(opts ...interpreterOpt) *Interpreter {
+	in := &Interpreter{
+		env:   object.NewEnvironment(),
+		clock: realClock{},
+	}
+	for _, o := range opts {
+		o(in)
+	}
+	return in
+}
+
+// Env returns the global environment of the interpreter.
+func (in *Interpreter) Env() *object.Environment { return in.env }
+
+// Run parses and evaluates src. See RunProgram.
+func (in *Interpreter) Run(ctx context.Context, src string) (object.Object, error) {
+	prog, errs := parser.New(src).Parse()
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return in.RunProgram(ctx, prog)
+}
+
+// RunProgram expands the macros of prog, evaluates it, and then runs the event loop until no timers or
+// promise callbacks are pending. It returns the value of the program, or the
+// first error raised by the program or one of its callbacks. Runtime errors
+// are *object.Error; use errors.Is with an object.ErrorKind to classify them,
+// and see CallStack.
+//
+// The generators that the program started and that have not finished are
+// closed when it returns, so that their goroutines do not outlive it; a
+// later run sees them as exhausted.
+func (in *Interpreter) RunProgram(ctx context.Context, prog *ast.Program) (object.Object, error) {
+	ctx, runLoop := WithEventLoop(ctx, in.clock)
+	ctx, closeGenerators := withGenerators(ctx)
+	defer closeGenerators()
+	if in.modules != nil {
+		ctx = context.WithValue(ctx, modulesKey, in.modules)
+	}
+	env := in.env.WithContext(ctx)
+	prog, err := Expand(prog, env)
+	if err != nil {
+		return nil, err
+	}
+	res := Eval(prog, env)
+	if err, ok := res.(*object.Error); ok {
+		return nil, err
+	}
+	if err := runLoop(env); err != nil {
+		return nil, err
+	}
+	if res == nil {
+		res = object.NULL
+	}
+	return res, nil
+}
+
+// CallStack returns the call stack recorded on a runtime error returned by
+// Run or RunProgram, innermost call first. It is nil if err was not raised
+// inside a function call.
+func CallStack(err error) []object.Frame {
+	var e *object.Error
+	if errors.As(err, &e) {
+		return e.Stack
+	}
+	return nil
+}
@@ -0,0 +1,235 @@
+package eval
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/kvalv/monkey/object"
+)
+
+// Iteration protocol
+//
+// Arrays, iterators, and structs and hashes with an __iter handler that
+// returns one of those are iterable. Other hashes are iterable as well: their
+// values are their pairs, as [key, value] arrays, in no particular order. An iterator produces its values one at
+// a time and only when asked, so that
+//
+//	take(map_iter(range(1000000), f), 3)
+//
+// calls f three times. Iterators are consumed as they go: a value that has
+// been produced is not produced again.
+
+// Iterator is a lazy sequence of values. It is returned by the iterator
+// builtins and by calls of generator functions.
+type Iterator struct {
+	name string
+	next func(env *object.Environment) (value object.Object, ok bool, err object.Object)
+
+	mu      sync.Mutex
+	running bool
+	done    bool
+}
+
+func newIterator(name string, next func(env *object.Environment) (object.Object, bool, object.Object)) *Iterator {
+	return &Iterator{name: name, next: next}
+}
+
+func (it *Iterator) Type() object.Type { return object.ITERATOR_OBJ }
+func (it *Iterator) String() string    { return fmt.Sprintf("iterator(%s)", it.name) }
+
+// Next returns the next value of it; ok is false once it is exhausted. An
+// iterator that fails is exhausted as well. env is that of the caller.
+func (it *Iterator) Next(env *object.Environment) (value object.Object, ok bool, err object.Object) {
+	it.mu.Lock()
+	if it.running {
+		it.mu.Unlock()
+		return nil, false, object.NewError(object.RuntimeError, "%s is already running", it)
+	}
+	if it.done {
+		it.mu.Unlock()
+		return nil, false, nil
+	}
+	it.running = true
+	it.mu.Unlock()
+
+	value, ok, err = it.next(env)
+
+	it.mu.Lock()
+	it.running = false
+	it.done = !ok || err != nil
+	it.mu.Unlock()
+	return value, ok && err == nil, err
+}
+
+// iterate returns an iterator over the values of obj.
+func iterate(obj object.Object, env *object.Environment) (*Iterator, object.Object) {
+	switch o := obj.(type) {
+	case *Iterator:
+		return o, nil
+	case *object.Array:
+		i := 0
+		return newIterator("array", func(*object.Environment) (object.Object, bool, object.Object) {
+			if i >= o.Len() {
+				return nil, false, nil
+			}
+			i++
+			return o.At(i - 1), true, nil
+		}), nil
+	}
+	res, ok := callHandler(obj, "__iter", nil, env)
+	if !ok {
+		if h, isHash := obj.(*object.Hash); isHash {
+			var pairs []object.Object
+			for _, pair := range h.Pairs() {
+				pairs = append(pairs, object.NewArray(pair.Key, pair.Value))
+			}
+			return iterate(object.NewArray(pairs...), env)
+		}
+		return nil, object.NewError(object.TypeError, "%s is not iterable", obj.Type())
+	}
+	if object.IsError(res) {
+		return nil, res
+	}
+	switch res.(type) {
+	case *Iterator, *object.Array:
+		return iterate(res, env)
+	}
+	return nil, object.NewError(object.TypeError, "__iter of %s returned %s, expected %s or %s", obj.Type(), res.Type(), object.ITERATOR_OBJ, object.ARRAY_OBJ)
+}
+
+// collect returns the values of obj that are left as an array.
+func collect(obj object.Object, env *object.Environment) object.Object {
+	it, err := iterate(obj, env)
+	if err != nil {
+		return err
+	}
+	var elems []object.Object
+	for {
+		value, ok, err := it.Next(env)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return object.NewArray(elems...)
+		}
+		elems = append(elems, value)
+	}
+}
+
+func init() {
+	builtin["range"] = &object.Builtin{
+		Fn: func(env *object.Environment, args ...object.Object) object.Object {
+			bounds := []int64{0, 0, 1}
+			switch len(args) {
+			case 1:
+				args = append([]object.Object{&object.Integer{Value: 0}}, args...)
+			case 2, 3:
+			default:
+				return object.NewError(object.ArgumentError, "range() accepts 1 to 3 arguments, got %d", len(args))
+			}
+			for i, arg := range args {
+				n, ok := arg.(*object.Integer)
+				if !ok {
+					return object.NewError(object.TypeError, "range() expects integers, got %s", arg.Type())
+				}
+				bounds[i] = n.Value
+			}
+			start, end, step := bounds[0], bounds[1], bounds[2]
+			if step == 0 {
+				return object.NewError(object.ArgumentError, "range() step must not be zero")
+			}
+			return newIterator("range", func(*object.Environment) (object.Object, bool, object.Object) {
+				if step > 0 && start >= end || step < 0 && start <= end {
+					return nil, false, nil
+				}
+				start += step
+				return &object.Integer{Value: start - step}, true, nil
+			})
+		},
+	}
+	builtin["take"] = &object.Builtin{
+		Fn: func(env *object.Environment, args ...object.Object) object.Object {
+			if len(args) != 2 {
+				return object.NewError(object.ArgumentError, "take() accepts 2 arguments, got %d", len(args))
+			}
+			n, ok := args[1].(*object.Integer)
+			if !ok || n.Value < 0 {
+				return object.NewError(object.ArgumentError, "take() expects a non-negative count")
+			}
+			it, err := iterate(args[0], env)
+			if err != nil {
+				return err
+			}
+			left := n.Value
+			return newIterator("take", func(env *object.Environment) (object.Object, bool, object.Object) {
+				if left == 0 {
+					return nil, false, nil
+				}
+				left--
+				return it.Next(env)
+			})
+		},
+	}
+	builtin["map_iter"] = &object.Builtin{
+		Fn: func(env *object.Environment, args ...object.Object) object.Object {
+			if len(args) != 2 {
+				return object.NewError(object.ArgumentError, "map_iter() accepts 2 arguments, got %d", len(args))
+			}
+			it, err := iterate(args[0], env)
+			if err != nil {
+				return err
+			}
+			fn := args[1]
+			return newIterator("map_iter", func(env *object.Environment) (object.Object, bool, object.Object) {
+				value, ok, err := it.Next(env)
+				if !ok || err != nil {
+					return nil, false, err
+				}
+				res := applyFunction(fn, []object.Object{value}, env)
+				if object.IsError(res) {
+					return nil, false, res
+				}
+				return res, true, nil
+			})
+		},
+	}
+	builtin["iter"] = &object.Builtin{
+		Fn: func(env *object.Environment, args ...object.Object) object.Object {
+			if len(args) != 1 {
+				return object.NewError(object.ArgumentError, "iter() accepts 1 argument, got %d", len(args))
+			}
+			it, err := iterate(args[0], env)
+			if err != nil {
+				return err
+			}
+			return it
+		},
+	}
+	builtin["next"] = &object.Builtin{
+		Fn: func(env *object.Environment, args ...object.Object) object.Object {
+			if len(args) != 1 {
+				return object.NewError(object.ArgumentError, "next() accepts 1 argument, got %d", len(args))
+			}
+			it, ok := args[0].(*Iterator)
+			if !ok {
+				return object.NewError(object.TypeError, "next() not supported for objects of type %s", args[0].Type())
+			}
+			value, ok, err := it.Next(env)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				value = object.NULL
+			}
+			return object.NewArray(value, nativeBoolToBoolean(ok))
+		},
+	}
+	builtin["collect"] = &object.Builtin{
+		Fn: func(env *object.Environment, args ...object.Object) object.Object {
+			if len(args) != 1 {
+				return object.NewError(object.ArgumentError, "collect() accepts 1 argument, got %d", len(args))
+			}
+			return collect(args[0], env)
+		},
+	}
+}
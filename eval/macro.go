@@ -0,0 +1,382 @@
+package eval
+
+import (
+	"fmt"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/kvalv/monkey/ast"
+	"github.com/kvalv/monkey/object"
+	"github.com/kvalv/monkey/token"
+)
+
+// maxExpansionDepth limits how deeply macros may expand to calls of macros,
+// so that a macro that expands to a call of itself is an error rather than
+// a hang.
+const maxExpansionDepth = 100
+
+func init() {
+	// quote and unquote are special forms, handled by evalCallExpression
+	// and evalQuote. They are builtins only so that they are defined names;
+	// calling them indirectly is an error.
+	builtin["quote"] = &object.Builtin{
+		Fn: func(env *object.Environment, args ...object.Object) object.Object {
+			return object.NewError(object.RuntimeError, "quote() must be called directly")
+		},
+	}
+	builtin["unquote"] = &object.Builtin{
+		Fn: func(env *object.Environment, args ...object.Object) object.Object {
+			return object.NewError(object.RuntimeError, "unquote() is only allowed inside quote()")
+		},
+	}
+}
+
+// Expand is the macro expansion phase, which runs between parsing and
+// evaluation. It defines the macros of prog in env, which are the top-level
+// lets bound to a macro literal, and replaces every call of a macro defined
+// in env with the code the macro returns when called with the quoted
+// arguments. prog is left untouched; the program returned has no macro
+// definitions left.
+//
+// Expansion errors are *object.Error positioned at the macro call, or in the
+// macro itself if it failed. The expanded code keeps the positions it had in
+// the source, so later errors point at the macro or at the caller's
+// arguments.
+func Expand(prog *ast.Program, env *object.Environment) (*ast.Program, error) {
+	var stmts []ast.Statement
+	for _, stmt := range prog.Statements {
+		let, ok := stmt.(*ast.LetStatement)
+		if !ok || let.Lhs == nil {
+			stmts = append(stmts, stmt)
+			continue
+		}
+		lit, ok := let.Rhs.(*ast.MacroLiteral)
+		if !ok {
+			stmts = append(stmts, stmt)
+			continue
+		}
+		m := &object.Macro{Params: lit.Params, Env: env, Body: lit.Body}
+		if err := env.Set(let.Lhs.Value, m); err != nil {
+			return nil, at(bindError(let.Lhs.Value, err), let.Pos).(*object.Error)
+		}
+	}
+	if len(stmts) < len(prog.Statements) {
+		cp := *prog
+		cp.Statements = stmts
+		prog = &cp
+	}
+	out, expanded, err := expand(prog, env, 0)
+	if err != nil {
+		return nil, err
+	}
+	if !expanded {
+		return prog, nil
+	}
+	res := out.(*ast.Program)
+	res.Resolved = false
+	return res, nil
+}
+
+// expand replaces the macro calls in node with their expansion, which is
+// itself expanded. depth is the number of expansions node is nested in.
+func expand(node ast.Node, env *object.Environment, depth int) (out ast.Node, expanded bool, err *object.Error) {
+	out = ast.Modify(node, func(n ast.Node) (ast.Node, bool) {
+		if err != nil {
+			return n, false
+		}
+		call, m := macroCall(n, env)
+		if m == nil {
+			return n, true
+		}
+		expanded = true
+		if depth >= maxExpansionDepth {
+			err = object.NewError(object.LimitError, "macro expansion of %s is nested too deeply", call.Function)
+			err.Pos = call.Function.(*ast.Identifier).Pos
+			return n, false
+		}
+		code, e := callMacro(m, call, env)
+		if e != nil {
+			err = e
+			return n, false
+		}
+		code, _, err = expand(code, env, depth+1)
+		return code, false
+	})
+	return out, expanded, err
+}
+
+// macroCall returns the call and the macro if n is a call of a macro
+// defined in env.
+func macroCall(n ast.Node, env *object.Environment) (*ast.CallExpression, *object.Macro) {
+	call, ok := n.(*ast.CallExpression)
+	if !ok {
+		return nil, nil
+	}
+	id, ok := call.Function.(*ast.Identifier)
+	if !ok {
+		return nil, nil
+	}
+	obj, _ := env.Get(id.Value)
+	m, ok := obj.(*object.Macro)
+	if !ok {
+		return nil, nil
+	}
+	return call, m
+}
+
+// callMacro evaluates the body of m with its parameters bound to the quoted
+// arguments of call, and returns the code it expands to.
+func callMacro(m *object.Macro, call *ast.CallExpression, env *object.Environment) (ast.Node, *object.Error) {
+	id := call.Function.(*ast.Identifier)
+	name := id.Value
+	fail := func(obj object.Object) (ast.Node, *object.Error) {
+		return nil, at(obj, id.Pos).(*object.Error)
+	}
+	if len(call.Params) != len(m.Params) {
+		return fail(object.NewError(object.ArgumentError, "macro %s expects %d arguments but received %d", name, len(m.Params), len(call.Params)))
+	}
+	frame := object.Frame{Function: name, Span: id.Span, Pos: id.Pos}
+	scope := m.Env.NewScope().WithCaller(env.WithStack(env.Stack().Push(frame)))
+	for i, arg := range call.Params {
+		if _, ok := arg.(*ast.KeywordArgument); ok {
+			return fail(object.NewError(object.ArgumentError, "macro %s does not accept keyword arguments", name))
+		}
+		if err := scope.Set(m.Params[i].Value, &object.Quote{Node: arg}); err != nil {
+			return fail(bindError(m.Params[i].Value, err))
+		}
+	}
+	res := Eval(m.Body, scope)
+	if ret, ok := res.(*object.Return); ok {
+		res = ret.Object
+	}
+	if object.IsError(res) {
+		return fail(withStack(res, scope))
+	}
+	q, ok := res.(*object.Quote)
+	if !ok {
+		return fail(object.NewError(object.TypeError, "macro %s returned %s, expected %s", name, typeOf(res), object.QUOTE_OBJ))
+	}
+	return q.Node, nil
+}
+
+// typeOf is the type of obj, which may be nil for an empty body.
+func typeOf(obj object.Object) object.Type {
+	if obj == nil {
+		return object.NULL_OBJ
+	}
+	return obj.Type()
+}
+
+// isQuote reports whether call is a call of the quote special form, rather
+// than of a binding that shadows it.
+func isQuote(call *ast.CallExpression, env *object.Environment) bool {
+	id, ok := call.Function.(*ast.Identifier)
+	if !ok || id.Value != "quote" || id.Binding != nil {
+		return false
+	}
+	_, shadowed := env.Get(id.Value)
+	return !shadowed
+}
+
+// isUnquote reports whether n is a call of unquote.
+func isUnquote(n ast.Node) bool {
+	call, ok := n.(*ast.CallExpression)
+	if !ok {
+		return false
+	}
+	id, ok := call.Function.(*ast.Identifier)
+	return ok && id.Value == "unquote"
+}
+
+// evalQuote evaluates quote(code): the code is returned unevaluated, except
+// that every unquote(expr) in it is replaced by the value of expr.
+func evalQuote(call *ast.CallExpression, env *object.Environment) object.Object {
+	if len(call.Params) != 1 {
+		return at(object.NewError(object.ArgumentError, "quote() accepts 1 argument, got %d", len(call.Params)), call.Pos)
+	}
+	var err object.Object
+	node := ast.Modify(hygienic(call.Params[0]), func(n ast.Node) (ast.Node, bool) {
+		if err != nil || !isUnquote(n) {
+			return n, err == nil
+		}
+		unquote := n.(*ast.CallExpression)
+		if len(unquote.Params) != 1 {
+			err = at(object.NewError(object.ArgumentError, "unquote() accepts 1 argument, got %d", len(unquote.Params)), unquote.Pos)
+			return n, false
+		}
+		value := Eval(unquote.Params[0], env)
+		if object.IsError(value) {
+			err = value
+			return n, false
+		}
+		out, e := unquoted(value, unquote.Token)
+		if e != nil {
+			err = at(e, unquote.Pos)
+			return n, false
+		}
+		return out, false
+	})
+	if err != nil {
+		return err
+	}
+	return &object.Quote{Node: node}
+}
+
+// unquoted converts the value of an unquote to the code that is spliced in
+// its place. Code is copied, so that every splice of it can be resolved on
+// its own; tok positions the literals.
+func unquoted(obj object.Object, tok token.Token) (ast.Expression, *object.Error) {
+	switch o := obj.(type) {
+	case *object.Quote:
+		if e, ok := copyNode(o.Node).(ast.Expression); ok {
+			return e, nil
+		}
+		return nil, object.NewError(object.TypeError, "cannot unquote %s, it is not an expression", o.Node)
+	case *object.Integer:
+		tok.Type, tok.Literal = token.INT, strconv.FormatInt(o.Value, 10)
+		return &ast.Number{Token: tok, Value: int(o.Value)}, nil
+	case *object.Boolean:
+		tok.Type, tok.Literal = token.FALSE, "false"
+		if o.Value {
+			tok.Type, tok.Literal = token.TRUE, "true"
+		}
+		return &ast.Boolean{Token: tok, Value: o.Value}, nil
+	case *object.String:
+		tok.Type, tok.Literal = token.STRING, `"`+o.Value+`"`
+		return &ast.String{Token: tok, Value: o.Value}, nil
+	case *object.Array:
+		tok.Type, tok.Literal = token.SOPEN, "["
+		arr := &ast.Array{Token: tok, Elems: []ast.Expression{}}
+		for _, el := range o.Elems() {
+			e, err := unquoted(el, tok)
+			if err != nil {
+				return nil, err
+			}
+			arr.Elems = append(arr.Elems, e)
+		}
+		return arr, nil
+	}
+	return nil, object.NewError(object.TypeError, "cannot unquote a value of type %s", typeOf(obj))
+}
+
+// isLocal reports whether fn is a function of the quoted code: a function
+// literal, or a name that the quoted code binds and so is renamed.
+func isLocal(fn ast.Expression, renamed map[string]string) bool {
+	switch fn := fn.(type) {
+	case *ast.FunctionLiteral:
+		return true
+	case *ast.Identifier:
+		_, ok := renamed[fn.Value]
+		return ok
+	}
+	return false
+}
+
+func copyNode(n ast.Node) ast.Node {
+	return ast.Modify(n, func(n ast.Node) (ast.Node, bool) { return n, true })
+}
+
+var gensyms atomic.Int64
+
+// gensym returns a name based on name that no other identifier has; it
+// cannot be written in source code.
+func gensym(name string) string {
+	return fmt.Sprintf("%s@%d", name, gensyms.Add(1))
+}
+
+// hygienic renames the names that quoted code binds, so that the code a
+// macro expands to cannot capture names at the call site, nor have its own
+// bindings shadowed by code spliced into it. Code inside unquote is left
+// alone: it belongs to the macro, and what it splices in to the caller.
+func hygienic(node ast.Node) ast.Node {
+	renamed := make(map[string]string)
+	bind := func(names ...string) {
+		for _, name := range names {
+			if _, ok := renamed[name]; !ok {
+				renamed[name] = gensym(name)
+			}
+		}
+	}
+	ast.Modify(node, func(n ast.Node) (ast.Node, bool) {
+		switch n := n.(type) {
+		case *ast.LetStatement:
+			bind(declaredNames(n)...)
+		case *ast.FunctionDeclaration:
+			bind(n.Name.Value)
+		case *ast.FunctionLiteral:
+			for _, p := range n.Params {
+				bind(p.Value)
+			}
+			if n.Rest != nil {
+				bind(n.Rest.Value)
+			}
+		case *ast.TryExpression:
+			if n.Param != nil {
+				bind(n.Param.Value)
+			}
+		case *ast.MatchExpression:
+			for _, arm := range n.Arms {
+				bind(patternNames(arm.Pattern)...)
+			}
+		case *ast.ArrayComprehension:
+			for _, c := range n.Clauses {
+				bind(patternNames(c.Target)...)
+			}
+		case *ast.HashComprehension:
+			for _, c := range n.Clauses {
+				bind(patternNames(c.Target)...)
+			}
+		}
+		return n, !isUnquote(n)
+	})
+	if len(renamed) == 0 {
+		return node
+	}
+	return rename(node, renamed)
+}
+
+// rename renames the identifiers of node outside of unquote.
+func rename(node ast.Node, renamed map[string]string) ast.Node {
+	return ast.Modify(node, func(n ast.Node) (ast.Node, bool) {
+		switch n := n.(type) {
+		case *ast.CallExpression:
+			if !isLocal(n.Function, renamed) {
+				break
+			}
+			// the callee is a function of the quoted code, whose parameters
+			// are renamed, so the keyword arguments naming them are too
+			cp := *n
+			cp.Function = rename(n.Function, renamed).(ast.Expression)
+			cp.Params = make([]ast.Expression, len(n.Params))
+			for i, p := range n.Params {
+				kw, ok := p.(*ast.KeywordArgument)
+				if !ok {
+					cp.Params[i] = rename(p, renamed).(ast.Expression)
+					continue
+				}
+				arg := *kw
+				if name, ok := renamed[kw.Name.Value]; ok {
+					id := *kw.Name
+					id.Value = name
+					arg.Name = &id
+				}
+				arg.Value = rename(kw.Value, renamed).(ast.Expression)
+				cp.Params[i] = &arg
+			}
+			return &cp, false
+		case *ast.Identifier:
+			if name, ok := renamed[n.Value]; ok {
+				cp := *n
+				cp.Value = name
+				return &cp, false
+			}
+		case *ast.KeywordArgument:
+			// the name of a keyword argument is that of a parameter of
+			// the callee, which is usually not the macro's
+			cp := *n
+			cp.Value = rename(n.Value, renamed).(ast.Expression)
+			return &cp, false
+		}
+		return n, !isUnquote(n)
+	})
+}
@@ -0,0 +1,149 @@
+package eval
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+
+	"github.com/kvalv/monkey/ast"
+	"github.com/kvalv/monkey/module"
+	"github.com/kvalv/monkey/object"
+	"github.com/kvalv/monkey/parser"
+)
+
+// modules loads and caches the modules imported by the programs of an
+// interpreter, so that each module is evaluated once.
+type modules struct {
+	loader module.Loader
+	mu     sync.Mutex
+	cache  map[string]*moduleEntry
+}
+
+// moduleEntry is a module that has been imported. done is closed once the
+// module has been evaluated; tasks that import it meanwhile wait for it.
+type moduleEntry struct {
+	done chan struct{}
+	mod  *object.Module
+	err  object.Object
+}
+
+// importChain is the chain of imports that led to the module being
+// evaluated, innermost first. It is nil for the main program.
+type importChain struct {
+	path   string
+	parent *importChain
+}
+
+func newModules(l module.Loader) *modules {
+	return &modules{loader: l, cache: make(map[string]*moduleEntry)}
+}
+
+// load returns the module at path, evaluating it unless it has been already.
+// chain is the chain of imports that led to this one.
+func (m *modules) load(path string, chain *importChain, env *object.Environment) object.Object {
+	for c := chain; c != nil; c = c.parent {
+		if c.path == path {
+			cycle := []string{path}
+			for c := chain; c.path != path; c = c.parent {
+				cycle = append(cycle, c.path)
+			}
+			cycle = append(cycle, path)
+			// the chain is innermost first
+			for i, j := 0, len(cycle)-1; i < j; i, j = i+1, j-1 {
+				cycle[i], cycle[j] = cycle[j], cycle[i]
+			}
+			return object.NewError(object.ImportError, "import cycle: %s", strings.Join(cycle, " -> "))
+		}
+	}
+	m.mu.Lock()
+	e, ok := m.cache[path]
+	if ok {
+		m.mu.Unlock()
+		select {
+		case <-e.done:
+		case <-env.Context().Done():
+			return object.NewError(object.CancelledError, "evaluation cancelled: %v", env.Context().Err())
+		}
+		if e.err != nil {
+			return e.err
+		}
+		return e.mod
+	}
+	e = &moduleEntry{done: make(chan struct{})}
+	m.cache[path] = e
+	m.mu.Unlock()
+
+	e.mod, e.err = m.eval(path, chain, env)
+	if e.err != nil {
+		// don't keep the failure around, so that a fixed module can be
+		// imported again
+		m.mu.Lock()
+		delete(m.cache, path)
+		m.mu.Unlock()
+	}
+	close(e.done)
+	if e.err != nil {
+		return e.err
+	}
+	return e.mod
+}
+
+// eval evaluates the module at path in a global environment of its own.
+func (m *modules) eval(path string, chain *importChain, env *object.Environment) (*object.Module, object.Object) {
+	src, err := m.loader.Load(path)
+	if err != nil {
+		return nil, object.NewError(object.ImportError, "import: %v", err)
+	}
+	prog, errs := parser.New(src).Parse()
+	if len(errs) > 0 {
+		return nil, object.NewError(object.ImportError, "import %s: %v", path, errors.Join(errs...))
+	}
+	ctx := context.WithValue(env.Context(), importKey, &importChain{path: path, parent: chain})
+	modEnv := object.NewEnvironment().WithContext(ctx).WithStack(env.Stack())
+	var res object.Object
+	if prog, err = Expand(prog, modEnv); err != nil {
+		res = err.(*object.Error)
+	} else {
+		res = Eval(prog, modEnv)
+	}
+	if object.IsError(res) {
+		err := *res.(*object.Error)
+		err.Message = "module " + path + ": " + err.Message
+		return nil, &err
+	}
+	mod := &object.Module{Path: path, Exports: make(map[string]object.Object)}
+	for _, stmt := range prog.Statements {
+		exp, ok := stmt.(*ast.ExportStatement)
+		if !ok {
+			continue
+		}
+		for _, name := range declaredNames(exp.Stmt) {
+			mod.Exports[name], _ = modEnv.Get(name)
+		}
+	}
+	return mod, nil
+}
+
+// declaredNames returns the names bound by a let statement or a function,
+// struct or enum declaration. An enum binds each of its variants as well.
+func declaredNames(stmt ast.Statement) []string {
+	switch s := stmt.(type) {
+	case *ast.LetStatement:
+		if s.Pattern != nil {
+			return patternNames(s.Pattern)
+		}
+		return []string{s.Lhs.Value}
+	case *ast.FunctionDeclaration:
+		return []string{s.Name.Value}
+	case *ast.StructDeclaration:
+		return []string{s.Name.Value}
+	case *ast.EnumDeclaration:
+		names := []string{s.Name.Value}
+		for _, v := range s.Variants {
+			names = append(names, v.Name.Value)
+		}
+		return names
+	}
+	return nil
+}
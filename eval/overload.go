@@ -0,0 +1,151 @@
+package eval
+
+import (
+	"github.com/kvalv/monkey/ast"
+	"github.com/kvalv/monkey/object"
+)
+
+// handlers are the names of the handlers of the operators that structs and
+// hashes can overload. A struct defines a handler as a method, which is
+// called with the other operands. A hash defines it in its meta table, set
+// with setmeta, as a function that is called with the hash followed by the
+// other operands.
+//
+// > is handled by the __lt of the right operand, and != by the __eq of the
+// left operand, negated. __index is the handler of [] and __len that of len().
+var handlers = map[string]string{
+	"+":  "__add",
+	"-":  "__sub",
+	"*":  "__mul",
+	"/":  "__div",
+	"==": "__eq",
+	"<":  "__lt",
+}
+
+// results are the types that handlers must return, for the handlers whose
+// result is used as is.
+var results = map[string]object.Type{
+	"__eq":  object.BOOLEAN_OBJ,
+	"__lt":  object.BOOLEAN_OBJ,
+	"__len": object.INTEGER_OBJ,
+}
+
+func init() {
+	// len is wrapped here as the builtins cannot refer to callFunction in
+	// their initializer.
+	length := builtin["len"].Fn
+	builtin["len"] = &object.Builtin{
+		Fn: func(env *object.Environment, args ...object.Object) object.Object {
+			if len(args) == 1 {
+				if res, ok := callHandler(args[0], "__len", nil, env); ok {
+					return res
+				}
+			}
+			return length(env, args...)
+		},
+	}
+	builtin["setmeta"] = &object.Builtin{
+		Fn: func(env *object.Environment, args ...object.Object) object.Object {
+			if len(args) != 2 {
+				return object.NewError(object.ArgumentError, "setmeta() accepts 2 arguments, got %d", len(args))
+			}
+			h, ok := args[0].(*object.Hash)
+			if !ok {
+				return object.NewError(object.TypeError, "setmeta() not supported for objects of type %s", args[0].Type())
+			}
+			var meta *object.Hash
+			switch m := args[1].(type) {
+			case *object.Hash:
+				meta = m
+			case *object.Null:
+			default:
+				return object.NewError(object.TypeError, "setmeta(): meta table must be a HASH, got %s", m.Type())
+			}
+			if err := h.SetMeta(meta); err != nil {
+				return frozenError(h)
+			}
+			return h
+		},
+	}
+	builtin["getmeta"] = &object.Builtin{
+		Fn: func(env *object.Environment, args ...object.Object) object.Object {
+			if len(args) != 1 {
+				return object.NewError(object.ArgumentError, "getmeta() accepts 1 argument, got %d", len(args))
+			}
+			h, ok := args[0].(*object.Hash)
+			if !ok {
+				return object.NewError(object.TypeError, "getmeta() not supported for objects of type %s", args[0].Type())
+			}
+			if meta := h.Meta(); meta != nil {
+				return meta
+			}
+			return object.NULL
+		},
+	}
+}
+
+// callHandler calls the handler of obj called name with args, and reports
+// whether obj has such a handler.
+func callHandler(obj object.Object, name string, args []object.Object, env *object.Environment) (object.Object, bool) {
+	var res object.Object
+	switch o := obj.(type) {
+	case *object.Struct:
+		if _, ok := o.Def.Methods[name]; !ok {
+			return nil, false
+		}
+		res = applyFunction(field(o, name), args, env)
+	case *object.Hash:
+		meta := o.Meta()
+		if meta == nil {
+			return nil, false
+		}
+		fn, ok := meta.Get(&object.String{Value: name})
+		if !ok {
+			return nil, false
+		}
+		switch fn.(type) {
+		case *object.Function, *object.Builtin, *object.Closure:
+		default:
+			return withStack(object.NewError(object.TypeError, "handler %s must be a function, got %s", name, fn.Type()), env), true
+		}
+		res = applyFunction(fn, append([]object.Object{o}, args...), env)
+	default:
+		return nil, false
+	}
+	if want, ok := results[name]; ok && !object.IsError(res) && res.Type() != want {
+		return withStack(object.NewError(object.TypeError, "handler %s must return %s, got %s", name, want, res.Type()), env), true
+	}
+	return res, true
+}
+
+// overloadInfix applies op with the handler of an operand, if it has one.
+func overloadInfix(node *ast.InfixExpression, lhs, rhs object.Object, env *object.Environment) (object.Object, bool) {
+	env = env.WithStack(env.Stack().Push(object.Frame{Function: node.Op, Span: node.Span, Pos: node.Pos}))
+	switch node.Op {
+	case ">":
+		return callHandler(rhs, "__lt", []object.Object{lhs}, env)
+	case "!=":
+		res, ok := callHandler(lhs, "__eq", []object.Object{rhs}, env)
+		if ok && !object.IsError(res) {
+			res = nativeBoolToBoolean(!isTruthy(res))
+		}
+		return res, ok
+	}
+	name, ok := handlers[node.Op]
+	if !ok {
+		return nil, false
+	}
+	return callHandler(lhs, name, []object.Object{rhs}, env)
+}
+
+// overloadIndex looks up index with the __index handler of obj, if it has
+// one. The handler of a hash is only consulted for keys it does not have.
+func overloadIndex(node *ast.ArrayIndex, obj, index object.Object, env *object.Environment) (object.Object, bool) {
+	if h, ok := obj.(*object.Hash); ok {
+		if _, found := h.Get(index); found {
+			return nil, false
+		}
+	}
+	env = env.WithStack(env.Stack().Push(object.Frame{Function: "[]", Span: node.Span, Pos: node.Pos}))
+	return callHandler(obj, "__index", []object.Object{index}, env)
+}
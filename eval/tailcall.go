@@ -0,0 +1,90 @@
+package eval
+
+import (
+	"github.com/kvalv/monkey/ast"
+	"github.com/kvalv/monkey/object"
+)
+
+// tailCall is a call in tail position that has not been made yet. The body
+// of a function returns it instead of calling, and evalFunctionCall makes the
+// call in a loop, so that recursion in tail position runs in constant Go
+// stack space. It never escapes evalFunctionCall.
+type tailCall struct {
+	fn     object.Object
+	args   []object.Object
+	kwargs map[string]object.Object
+	frame  object.Frame
+}
+
+func (t *tailCall) Type() object.Type { return "TAIL_CALL" }
+func (t *tailCall) String() string    { return "tail call" }
+
+// evalBody evaluates the statements of a function body. Calls in tail
+// position, and calls that are returned explicitly, are not made but
+// returned as a *tailCall.
+func evalBody(stmts []ast.Statement, env *object.Environment, tail bool) object.Object {
+	if err := hoist(stmts, env); err != nil {
+		return err
+	}
+	var res object.Object = object.NULL
+	for i, s := range stmts {
+		if es, ok := s.(*ast.ExpressionStatement); ok {
+			res = evalTail(es.Expr, env, tail && i == len(stmts)-1)
+		} else {
+			res = Eval(s, env)
+		}
+		if res.Type() == object.RETURN_OBJ || object.IsError(res) {
+			return res
+		}
+	}
+	return res
+}
+
+// returnValue is the result of a return expression. An error is not
+// wrapped, so that a try around the return can still catch it.
+func returnValue(obj object.Object) object.Object {
+	if object.IsError(obj) {
+		return obj
+	}
+	return &object.Return{Object: obj}
+}
+
+// evalTail evaluates an expression statement of a function body. tail is set
+// if the value of the expression is the value of the function.
+func evalTail(expr ast.Expression, env *object.Environment, tail bool) object.Object {
+	switch n := expr.(type) {
+	case *ast.ReturnExpression:
+		return returnValue(evalTail(n.Value, env, true))
+	case *ast.IfExpression:
+		cond := Eval(n.Cond, env)
+		if object.IsError(cond) {
+			return cond
+		}
+		if isTruthy(cond) {
+			return evalBody(n.Then.Statements, env, tail)
+		}
+		if n.Else != nil {
+			return evalBody(n.Else.Statements, env, tail)
+		}
+		return object.NULL
+	case *ast.MatchExpression:
+		return evalMatch(n, env, func(body ast.Expression, scope *object.Environment) object.Object { return evalTail(body, scope, tail) })
+	case *ast.CallExpression:
+		if !tail {
+			break
+		}
+		if isQuote(n, env) {
+			return evalQuote(n, env)
+		}
+		fn := Eval(n.Function, env)
+		if object.IsError(fn) || n.Optional && fn == object.NULL {
+			return fn
+		}
+		args, kwargs, err := evalArguments(n.Params, env)
+		if err != nil {
+			return err
+		}
+		return &tailCall{fn: fn, args: args, kwargs: kwargs, frame: callFrame(n, fn)}
+	}
+	return Eval(expr, env)
+}
@@ -2,17 +2,31 @@ package lex
 
 import (
 	"fmt"
+	"sort"
 
 	"github.com/kvalv/monkey/token"
 )
 
 func New(input string) *Lex {
-	return &Lex{input: input}
+	l := &Lex{input: input, lines: []int{0}}
+	for i := 0; i < len(input); i++ {
+		if input[i] == '\n' {
+			l.lines = append(l.lines, i+1)
+		}
+	}
+	return l
 }
 
 type Lex struct {
 	input string
 	pos   int
+	lines []int // offset of the start of each line
+}
+
+// position returns the line and column of the byte at offset
+func (l *Lex) position(offset int) token.Pos {
+	line := sort.Search(len(l.lines), func(i int) bool { return l.lines[i] > offset })
+	return token.Pos{Line: line, Col: offset - l.lines[line-1] + 1}
 }
 
 func (l *Lex) create(tp token.Type, lit string) token.Token {
@@ -22,11 +36,12 @@ func (l *Lex) create(tp token.Type, lit string) token.Token {
 				Start: len(l.input),
 				End:   len(l.input),
 			},
+			Pos: l.position(len(l.input)),
 		}
 	}
 	end := l.pos + 1
 	start := end - len(lit)
-	return token.Token{Type: tp, Literal: lit, Span: token.Span{Start: start, End: end}}
+	return token.Token{Type: tp, Literal: lit, Span: token.Span{Start: start, End: end}, Pos: l.position(start)}
 }
 
 func (l *Lex) curr() byte {
@@ -58,6 +73,11 @@ func (l *Lex) Next() (t token.Token) {
 	defer l.advance() // advance one byte so we're at the start of next token when we're done here
 
 	l.takeWhile(isWhitespace, true) // ... we want to skip whitespace
+	if isWhitespace(l.curr()) {
+		// nothing but trailing whitespace left
+		l.pos = len(l.input)
+		return l.create(token.EOF, "")
+	}
 
 	c := l.curr()
 	if tp, ok := builtins[string(c)]; ok {
@@ -69,6 +89,10 @@ func (l *Lex) Next() (t token.Token) {
 			l.advance()
 			return l.create(token.EQ, "==")
 		}
+		if l.peek() == '>' {
+			l.advance()
+			return l.create(token.ARROW, "=>")
+		}
 		return l.create(token.ASSIGN, "=")
 	}
 	if c == '!' {
@@ -78,6 +102,28 @@ func (l *Lex) Next() (t token.Token) {
 		}
 		return l.create(token.BANG, "!")
 	}
+	if c == '.' && l.peek() == '.' && l.pos+2 < len(l.input) && l.input[l.pos+2] == '.' {
+		l.advance()
+		l.advance()
+		return l.create(token.ELLIPSIS, "...")
+	}
+	if c == '.' {
+		return l.create(token.DOT, ".")
+	}
+	if c == '|' && l.peek() == '>' {
+		l.advance()
+		return l.create(token.PIPE, "|>")
+	}
+	if c == '?' {
+		switch l.peek() {
+		case '.':
+			l.advance()
+			return l.create(token.OPTIONAL, "?.")
+		case '?':
+			l.advance()
+			return l.create(token.COALESCE, "??")
+		}
+	}
 	if c == '"' {
 		l.advance()
 		if l.curr() == '"' {
@@ -90,6 +136,10 @@ func (l *Lex) Next() (t token.Token) {
 	// yeah otherwise we'll check for longer tokens: digits and letters
 	if isLetter(c) {
 		word := l.takeWhile(isLetter, false)
+		if word == "fn" && l.peek() == '*' {
+			l.advance()
+			return l.create(token.GENERATOR, "fn*")
+		}
 		if typ, ok := builtins[word]; ok {
 			// it's a special keyword, such as "if" or "return"
 			return l.create(typ, word)
@@ -146,13 +196,25 @@ var builtins map[string]token.Type = map[string]token.Type{
 	"[": token.SOPEN,
 	"]": token.SCLOSE,
 
-	"if":     token.IF,
-	"else":   token.ELSE,
-	"let":    token.LET,
-	"fn":     token.FUNC,
-	"return": token.RETURN,
-	"true":   token.TRUE,
-	"false":  token.FALSE,
+	"if":      token.IF,
+	"else":    token.ELSE,
+	"let":     token.LET,
+	"const":   token.CONST,
+	"for":     token.FOR,
+	"fn":      token.FUNC,
+	"return":  token.RETURN,
+	"try":     token.TRY,
+	"catch":   token.CATCH,
+	"finally": token.FINALLY,
+	"throw":   token.THROW,
+	"match":   token.MATCH,
+	"macro":   token.MACRO,
+	"struct":  token.STRUCT,
+	"enum":    token.ENUM,
+	"import":  token.IMPORT,
+	"export":  token.EXPORT,
+	"true":    token.TRUE,
+	"false":   token.FALSE,
 }
 
 func lookupIdentifier(ident string) token.Type {
@@ -162,6 +224,6 @@ func lookupIdentifier(ident string) token.Type {
 	return token.IDENT
 }
 
-func isWhitespace(c byte) bool { return c == ' ' || c == '\n' }
-func isLetter(c byte) bool     { return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') }
+func isWhitespace(c byte) bool { return c == ' ' || c == '\n' || c == '\t' || c == '\r' }
+func isLetter(c byte) bool     { return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c == '_' }
 func isDigit(c byte) bool      { return c >= '0' && c <= '9' }
@@ -11,7 +11,7 @@ import (
 )
 
 func TestNextToken(t *testing.T) {
-	input := `=+-,!*; != == foo fn return {} () 1 11 > < true false if else "hello" "hello world" "" x arr[index] :`
+	input := `=+-,!*; != == foo fn return {} () 1 11 > < true false if else "hello" "hello world" "" x arr[index] : ...xs match => import export macro struct enum a.b fn* fn * const a?.b ?? ? |> | for in`
 	l := lex.New(input)
 	log.SetOutput(os.Stdout)
 	expected := []token.Token{
@@ -48,6 +48,31 @@ func TestNextToken(t *testing.T) {
 		{Type: token.IDENT, Literal: "index"},
 		{Type: token.SCLOSE, Literal: "]"},
 		{Type: token.COLON, Literal: ":"},
+		{Type: token.ELLIPSIS, Literal: "..."},
+		{Type: token.IDENT, Literal: "xs"},
+		{Type: token.MATCH, Literal: "match"},
+		{Type: token.ARROW, Literal: "=>"},
+		{Type: token.IMPORT, Literal: "import"},
+		{Type: token.EXPORT, Literal: "export"},
+		{Type: token.MACRO, Literal: "macro"},
+		{Type: token.STRUCT, Literal: "struct"},
+		{Type: token.ENUM, Literal: "enum"},
+		{Type: token.IDENT, Literal: "a"},
+		{Type: token.DOT, Literal: "."},
+		{Type: token.IDENT, Literal: "b"},
+		{Type: token.GENERATOR, Literal: "fn*"},
+		{Type: token.FUNC, Literal: "fn"},
+		{Type: token.MUL, Literal: "*"},
+		{Type: token.CONST, Literal: "const"},
+		{Type: token.IDENT, Literal: "a"},
+		{Type: token.OPTIONAL, Literal: "?."},
+		{Type: token.IDENT, Literal: "b"},
+		{Type: token.COALESCE, Literal: "??"},
+		{Type: token.ILLEGAL, Literal: "?"},
+		{Type: token.PIPE, Literal: "|>"},
+		{Type: token.ILLEGAL, Literal: "|"},
+		{Type: token.FOR, Literal: "for"},
+		{Type: token.IDENT, Literal: "in"},
 		{Type: token.EOF, Literal: ""},
 	}
 	for i, exp := range expected {
@@ -80,6 +105,20 @@ func TestSpan(t *testing.T) {
 	}
 }
 
+func TestPos(t *testing.T) {
+	l := lex.New("let a = 1;\n  a +\n\nb")
+	expected := []token.Pos{
+		{Line: 1, Col: 1}, {Line: 1, Col: 5}, {Line: 1, Col: 7}, {Line: 1, Col: 9}, {Line: 1, Col: 10},
+		{Line: 2, Col: 3}, {Line: 2, Col: 5},
+		{Line: 4, Col: 1},
+	}
+	for _, exp := range expected {
+		if got := l.Next(); got.Pos != exp {
+			t.Fatalf("expected %s, got %s - token = %+v", exp, got.Pos, got)
+		}
+	}
+}
+
 func TestPrefix(t *testing.T) {
 	l := lex.New("!3;")
 	expected := []token.Token{
@@ -131,3 +170,22 @@ func TestMore(t *testing.T) {
 		}
 	}
 }
+
+func TestTrailingWhitespace(t *testing.T) {
+	l := lex.New("\tlet x = y;\n\t")
+	expected := []token.Token{
+		{Type: token.LET, Literal: "let"},
+		{Type: token.IDENT, Literal: "x"},
+		{Type: token.ASSIGN, Literal: "="},
+		{Type: token.IDENT, Literal: "y"},
+		{Type: token.SEMICOLON, Literal: ";"},
+		{Type: token.EOF, Literal: ""},
+	}
+	for i, exp := range expected {
+		if got := l.Next(); got.Type != exp.Type {
+			t.Fatalf("%d: unexpected TokenType: expected %+v, got %+v", i, exp, got)
+		} else if got.Literal != exp.Literal {
+			t.Fatalf("%d: unexpected Literal: expected %+v, got %+v", i, exp, got)
+		}
+	}
+}
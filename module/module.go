@@ -0,0 +1,72 @@
+// Package module finds the source of the modules that scripts import.
+//
+// A module is named by a slash-separated path, like "lib/strings". Paths that
+// start with "./" or "../" are relative to the module that imports them;
+// other paths are relative to the root of the loader. A path without an
+// extension refers to a file with the extension Ext.
+package module
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+)
+
+// Ext is the extension of module files
+const Ext = ".monkey"
+
+// ErrNotFound is returned by loaders for modules that do not exist.
+var ErrNotFound = errors.New("module not found")
+
+// Loader returns the source of the module at a resolved path.
+type Loader interface {
+	Load(path string) (string, error)
+}
+
+// Resolve returns the path of the module imported as name by the module at
+// from, which is empty for the main program.
+func Resolve(from, name string) (string, error) {
+	p := name
+	if strings.HasPrefix(name, "./") || strings.HasPrefix(name, "../") {
+		p = path.Join(path.Dir(from), name)
+	}
+	p = path.Clean(p)
+	if p == "." || p == ".." || strings.HasPrefix(p, "../") || path.IsAbs(p) {
+		return "", fmt.Errorf("invalid module path %q", name)
+	}
+	if path.Ext(p) == "" {
+		p += Ext
+	}
+	return p, nil
+}
+
+// FS loads modules from a file system, such as an embed.FS.
+func FS(fsys fs.FS) Loader { return fsLoader{fsys} }
+
+// Dir loads modules from the directory root.
+func Dir(root string) Loader { return FS(os.DirFS(root)) }
+
+type fsLoader struct{ fsys fs.FS }
+
+func (l fsLoader) Load(path string) (string, error) {
+	b, err := fs.ReadFile(l.fsys, path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", fmt.Errorf("%w: %s", ErrNotFound, path)
+	}
+	return string(b), err
+}
+
+// Map is a loader that keeps the source of every module in memory, keyed by
+// path. It is mostly useful for tests.
+type Map map[string]string
+
+func (m Map) Load(path string) (string, error) {
+	src, ok := m[path]
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrNotFound, path)
+	}
+	return src, nil
+}
@@ -0,0 +1,55 @@
+package module_test
+
+import (
+	"errors"
+	"testing"
+	"testing/fstest"
+
+	"github.com/kvalv/monkey/module"
+)
+
+func TestResolve(t *testing.T) {
+	cases := []struct {
+		from, name, expected string
+	}{
+		{"", "lib", "lib.monkey"},
+		{"", "./lib", "lib.monkey"},
+		{"", "lib/strings", "lib/strings.monkey"},
+		{"lib/strings.monkey", "./util", "lib/util.monkey"},
+		{"lib/strings.monkey", "../main", "main.monkey"},
+		{"lib/strings.monkey", "other/x", "other/x.monkey"},
+		{"", "data.txt", "data.txt"},
+	}
+	for _, tc := range cases {
+		got, err := module.Resolve(tc.from, tc.name)
+		if err != nil {
+			t.Fatalf("Resolve(%q, %q): %v", tc.from, tc.name, err)
+		}
+		if got != tc.expected {
+			t.Fatalf("Resolve(%q, %q): expected %q, got %q", tc.from, tc.name, tc.expected, got)
+		}
+	}
+	for _, name := range []string{"../x", "/etc/passwd", ".", "./a/../.."} {
+		if _, err := module.Resolve("", name); err == nil {
+			t.Fatalf("Resolve(%q): expected an error", name)
+		}
+	}
+}
+
+func TestLoaders(t *testing.T) {
+	loaders := map[string]module.Loader{
+		"fs":  module.FS(fstest.MapFS{"lib/a.monkey": {Data: []byte("1")}}),
+		"map": module.Map{"lib/a.monkey": "1"},
+	}
+	for name, l := range loaders {
+		t.Run(name, func(t *testing.T) {
+			src, err := l.Load("lib/a.monkey")
+			if err != nil || src != "1" {
+				t.Fatalf("expected %q, got %q, %v", "1", src, err)
+			}
+			if _, err := l.Load("lib/b.monkey"); !errors.Is(err, module.ErrNotFound) {
+				t.Fatalf("expected ErrNotFound, got %v", err)
+			}
+		})
+	}
+}
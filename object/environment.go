@@ -1,28 +1,163 @@
 package object
 
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrFrozen is returned when binding a name in a frozen environment.
+var ErrFrozen = errors.New("environment is frozen")
+
+// ErrConstant is returned when binding a name that is bound by const.
+var ErrConstant = errors.New("it is a constant")
+
+// Environment holds the bindings of a scope. It is safe for concurrent use:
+// bindings are guarded by a read-write lock until the environment is frozen,
+// after which reads skip the lock entirely and writes are rejected. The usual
+// pattern for parallel evaluation is to populate a global environment, freeze
+// it, and give every goroutine its own scope via NewScope.
+//
+// An environment also carries the context of the evaluation using it, which
+// is how cancellation and per-task state reach builtins.
 type Environment struct {
-	data   map[string]Object
+	*bindings
 	parent *Environment
+	ctx    context.Context
+	stack  *Stack
+}
+
+type bindings struct {
+	mu     sync.RWMutex
+	data   map[string]Object
+	consts map[string]bool
+	slots  []Object
+	frozen atomic.Bool
 }
 
 func NewEnvironment() *Environment {
 	return &Environment{
-		data: make(map[string]Object),
+		bindings: &bindings{data: make(map[string]Object)},
+		ctx:      context.Background(),
 	}
 }
 
 func (e *Environment) Get(key string) (Object, bool) {
-	v, ok := e.data[key]
+	var (
+		v  Object
+		ok bool
+	)
+	if e.frozen.Load() {
+		v, ok = e.data[key]
+	} else {
+		e.mu.RLock()
+		v, ok = e.data[key]
+		e.mu.RUnlock()
+	}
 	if !ok && e.parent != nil {
 		return e.parent.Get(key)
 	}
 	return v, ok
 }
-func (e *Environment) Set(key string, value Object) {
+func (e *Environment) Set(key string, value Object) error {
+	return e.set(key, value, false)
+}
+
+// SetConst binds key like Set, after which it cannot be bound again in this
+// scope. Locals in slots need no such check: the resolver already rejects
+// binding a name twice in a scope.
+func (e *Environment) SetConst(key string, value Object) error {
+	return e.set(key, value, true)
+}
+
+func (e *Environment) set(key string, value Object, constant bool) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.frozen.Load() {
+		return ErrFrozen
+	}
+	if e.consts[key] {
+		return ErrConstant
+	}
 	e.data[key] = value
+	if constant {
+		if e.consts == nil {
+			e.consts = make(map[string]bool)
+		}
+		e.consts[key] = true
+	}
+	return nil
 }
+
 func (e *Environment) NewScope() *Environment {
 	env := NewEnvironment()
 	env.parent = e
+	env.ctx = e.ctx
+	env.stack = e.stack
 	return env
 }
+
+// NewFrame returns a scope for a call to a resolved function, whose
+// parameters and locals are addressed by slot instead of by name.
+func (e *Environment) NewFrame(size int) *Environment {
+	env := e.NewScope()
+	env.slots = make([]Object, size)
+	return env
+}
+
+// GetSlot returns the value in slot of the frame depth scopes up, or nil if
+// the variable has not been bound yet.
+func (e *Environment) GetSlot(depth, slot int) Object {
+	for ; depth > 0; depth-- {
+		e = e.parent
+	}
+	if e.frozen.Load() {
+		return e.slots[slot]
+	}
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.slots[slot]
+}
+func (e *Environment) SetSlot(slot int, value Object) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.frozen.Load() {
+		return ErrFrozen
+	}
+	e.slots[slot] = value
+	return nil
+}
+
+// Freeze makes the environment read-only. Scopes created from a frozen
+// environment are not frozen themselves.
+func (e *Environment) Freeze() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.frozen.Store(true)
+}
+func (e *Environment) Frozen() bool { return e.frozen.Load() }
+
+// Context returns the context of the evaluation using this environment.
+func (e *Environment) Context() context.Context { return e.ctx }
+
+// WithContext returns a view of the environment that shares its bindings but
+// evaluates under ctx.
+func (e *Environment) WithContext(ctx context.Context) *Environment {
+	return &Environment{bindings: e.bindings, parent: e.parent, ctx: ctx, stack: e.stack}
+}
+
+// Stack returns the call stack of the evaluation using this environment.
+func (e *Environment) Stack() *Stack { return e.stack }
+
+// WithStack returns a view of the environment that shares its bindings but
+// evaluates with the given call stack.
+func (e *Environment) WithStack(s *Stack) *Environment {
+	return &Environment{bindings: e.bindings, parent: e.parent, ctx: e.ctx, stack: s}
+}
+
+// WithCaller returns a view of the environment for the body of a function
+// called from caller: it runs under the context and call stack of caller.
+func (e *Environment) WithCaller(caller *Environment) *Environment {
+	return &Environment{bindings: e.bindings, parent: e.parent, ctx: caller.ctx, stack: caller.stack}
+}
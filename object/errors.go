@@ -0,0 +1,73 @@
+package object
+
+import "fmt"
+
+// ErrorKind classifies runtime errors, so that host code and scripts can
+// tell a type mismatch from, say, an index out of range without parsing the
+// message. A kind is itself an error and is what an *Error unwraps to, so
+//
+//	errors.Is(err, object.IndexError)
+//
+// reports whether err is an index error.
+type ErrorKind int
+
+const (
+	// RuntimeError is any error that has no more specific kind.
+	RuntimeError ErrorKind = iota
+	// TypeError is an operand or argument of the wrong type.
+	TypeError
+	// NameError is a use of an identifier that is not defined, or a binding
+	// that conflicts with an existing one.
+	NameError
+	// IndexError is an index outside of the bounds of an array.
+	IndexError
+	// ArgumentError is a call with the wrong number of arguments, or with an
+	// argument that has the right type but an invalid value.
+	ArgumentError
+	// ZeroDivisionError is a division by zero.
+	ZeroDivisionError
+	// LimitError is an evaluation that exceeded a resource limit, such as the
+	// depth of the stack.
+	LimitError
+	// CancelledError is an evaluation that was stopped because its context
+	// was cancelled. It cannot be caught by scripts.
+	CancelledError
+	// ThrownError is a value thrown by a script.
+	ThrownError
+	// MatchError is a value that none of the arms of a match expression
+	// match, or that does not match a literal in a pattern.
+	MatchError
+	// ImportError is a module that cannot be found or parsed, or that
+	// imports itself.
+	ImportError
+)
+
+var kindNames = [...]string{
+	RuntimeError:      "RuntimeError",
+	TypeError:         "TypeError",
+	NameError:         "NameError",
+	IndexError:        "IndexError",
+	ArgumentError:     "ArgumentError",
+	ZeroDivisionError: "ZeroDivisionError",
+	LimitError:        "LimitError",
+	CancelledError:    "CancelledError",
+	ThrownError:       "ThrownError",
+	MatchError:        "MatchError",
+	ImportError:       "ImportError",
+}
+
+func (k ErrorKind) String() string {
+	if k < 0 || int(k) >= len(kindNames) {
+		return fmt.Sprintf("ErrorKind(%d)", int(k))
+	}
+	return kindNames[k]
+}
+func (k ErrorKind) Error() string { return k.String() }
+
+// NewError returns an error of the given kind.
+func NewError(kind ErrorKind, format string, a ...any) *Error {
+	return &Error{Kind: kind, Message: fmt.Sprintf(format, a...)}
+}
+
+// Unwrap returns the kind of e, for errors.Is and errors.As.
+func (e *Error) Unwrap() error { return e.Kind }
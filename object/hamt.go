@@ -0,0 +1,143 @@
+package object
+
+import (
+	"hash/maphash"
+	"math/bits"
+)
+
+// Map is a persistent map from strings to pairs, implemented as a hash
+// array mapped trie. Like Vector it is changed by making a new map, which
+// shares all but O(log n) of its structure with the old one, so that a copy
+// of a map is as cheap as a copy of the Map value.
+//
+// The zero Map is empty and ready to use.
+type Map struct {
+	root *hnode
+	size int
+}
+
+// hnode is a node of the trie. Each holds up to 32 entries, one for each
+// 5 bits of the hash at its level that some key has; bitmap records which
+// ones are present. Keys whose hashes are equal end up in a node past the
+// last level, which is searched linearly.
+type hnode struct {
+	bitmap  uint32
+	entries []hentry
+}
+
+// hentry is either a key with its value, or a subtrie if node is set.
+type hentry struct {
+	hash  uint64
+	key   string
+	value Pair
+	node  *hnode
+}
+
+var seed = maphash.MakeSeed()
+
+// hashString is the hash of a key. It is a variable so that tests can make
+// keys collide.
+var hashString = func(s string) uint64 { return maphash.String(seed, s) }
+
+// Len returns the number of keys of m.
+func (m Map) Len() int { return m.size }
+
+// Get returns the value of key.
+func (m Map) Get(key string) (Pair, bool) {
+	hash := hashString(key)
+	node := m.root
+	for shift := uint(0); node != nil; shift += vecBits {
+		if shift >= 64 {
+			for _, e := range node.entries {
+				if e.key == key {
+					return e.value, true
+				}
+			}
+			return Pair{}, false
+		}
+		bit := uint32(1) << ((hash >> shift) & vecMask)
+		if node.bitmap&bit == 0 {
+			return Pair{}, false
+		}
+		e := node.entries[bits.OnesCount32(node.bitmap&(bit-1))]
+		if e.node == nil {
+			return e.value, e.key == key
+		}
+		node = e.node
+	}
+	return Pair{}, false
+}
+
+// Set returns m with key set to value.
+func (m Map) Set(key string, value Pair) Map {
+	root := m.root
+	if root == nil {
+		root = &hnode{}
+	}
+	root, added := root.set(hentry{hash: hashString(key), key: key, value: value}, 0)
+	m.root = root
+	if added {
+		m.size++
+	}
+	return m
+}
+
+// Range calls f for every key of m, in no particular order, until f returns
+// false.
+func (m Map) Range(f func(key string, value Pair) bool) {
+	if m.root != nil {
+		m.root.each(f)
+	}
+}
+
+// set returns a copy of n with e added, and whether its key is new.
+func (n *hnode) set(e hentry, shift uint) (*hnode, bool) {
+	cp := &hnode{bitmap: n.bitmap, entries: append([]hentry(nil), n.entries...)}
+	if shift >= 64 {
+		for i := range cp.entries {
+			if cp.entries[i].key == e.key {
+				cp.entries[i] = e
+				return cp, false
+			}
+		}
+		cp.entries = append(cp.entries, e)
+		return cp, true
+	}
+	bit := uint32(1) << ((e.hash >> shift) & vecMask)
+	i := bits.OnesCount32(n.bitmap & (bit - 1))
+	if n.bitmap&bit == 0 {
+		cp.bitmap |= bit
+		cp.entries = append(cp.entries, hentry{})
+		copy(cp.entries[i+1:], cp.entries[i:])
+		cp.entries[i] = e
+		return cp, true
+	}
+	old := cp.entries[i]
+	switch {
+	case old.node != nil:
+		child, added := old.node.set(e, shift+vecBits)
+		cp.entries[i] = hentry{node: child}
+		return cp, added
+	case old.key == e.key:
+		cp.entries[i] = e
+		return cp, false
+	}
+	// two keys share the slot at this level, so both move down a level
+	child, _ := (&hnode{}).set(old, shift+vecBits)
+	child, _ = child.set(e, shift+vecBits)
+	cp.entries[i] = hentry{node: child}
+	return cp, true
+}
+
+func (n *hnode) each(f func(key string, value Pair) bool) bool {
+	for _, e := range n.entries {
+		if e.node != nil {
+			if !e.node.each(f) {
+				return false
+			}
+		} else if !f(e.key, e.value) {
+			return false
+		}
+	}
+	return true
+}
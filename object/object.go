@@ -2,25 +2,53 @@ package object
 
 import (
 	"crypto/md5"
+	"errors"
 	"fmt"
 	"strings"
+	"sync"
+	"sync/atomic"
 
 	"github.com/kvalv/monkey/ast"
+	"github.com/kvalv/monkey/token"
 )
 
 type Type string
 
+// ErrFrozenValue is returned when modifying a frozen hash or struct.
+var ErrFrozenValue = errors.New("value is frozen")
+
+// ErrNoField is returned when assigning to a field that a struct does not
+// have.
+var ErrNoField = errors.New("no such field")
+
 const (
-	INTEGER_OBJ  = "INTEGER"
-	BOOLEAN_OBJ  = "BOOLEAN"
-	NULL_OBJ     = "NULL"
-	RETURN_OBJ   = "RETURN"
-	ERROR_OBJ    = "ERROR"
-	FUNCTION_OBJ = "FUNCTION"
-	STRING_OBJ   = "STRING"
-	BUILTIN_OBJ  = "BUILTIN"
-	ARRAY_OBJ    = "ARRAY"
-	HASH_OBJ     = "HASH"
+	INTEGER_OBJ     = "INTEGER"
+	BOOLEAN_OBJ     = "BOOLEAN"
+	NULL_OBJ        = "NULL"
+	RETURN_OBJ      = "RETURN"
+	ERROR_OBJ       = "ERROR"
+	FUNCTION_OBJ    = "FUNCTION"
+	STRING_OBJ      = "STRING"
+	BUILTIN_OBJ     = "BUILTIN"
+	ARRAY_OBJ       = "ARRAY"
+	HASH_OBJ        = "HASH"
+	TASK_OBJ        = "TASK"
+	CHANNEL_OBJ     = "CHANNEL"
+	WAITGROUP_OBJ   = "WAITGROUP"
+	PROMISE_OBJ     = "PROMISE"
+	ERROR_VALUE_OBJ = "ERROR_VALUE"
+	MODULE_OBJ      = "MODULE"
+	QUOTE_OBJ       = "QUOTE"
+	MACRO_OBJ       = "MACRO"
+	STRUCT_TYPE_OBJ = "STRUCT_TYPE"
+	STRUCT_OBJ      = "STRUCT"
+	ENUM_TYPE_OBJ   = "ENUM_TYPE"
+	VARIANT_OBJ     = "VARIANT"
+	ENUM_OBJ        = "ENUM"
+	ITERATOR_OBJ    = "ITERATOR"
+
+	COMPILED_FUNCTION_OBJ = "COMPILED_FUNCTION"
+	CLOSURE_OBJ           = "CLOSURE"
 )
 
 var (
@@ -34,24 +62,130 @@ type Object interface {
 	String() string
 }
 
-type BuiltinFunction func(args ...Object) Object
+// BuiltinFunction is called with the environment of the caller, which gives
+// access to the context of the running evaluation.
+type BuiltinFunction func(env *Environment, args ...Object) Object
 type Pair struct{ Key, Value Object }
 
 type (
-	Integer  struct{ Value int64 }
-	Boolean  struct{ Value bool }
-	Null     struct{}
-	Return   struct{ Object }
-	Error    struct{ Message string }
-	Function struct {
+	Integer struct{ Value int64 }
+	Boolean struct{ Value bool }
+	Null    struct{}
+	Return  struct{ Object }
+	// Error is a runtime error, or a value thrown by a script. Pos is where
+	// the error was raised, if known, and Stack is the call stack at that
+	// point, if it was raised inside a function call. Value is the thrown
+	// value; it is nil for errors raised by the runtime.
+	Error struct {
+		Kind    ErrorKind
+		Message string
+		Stack   []Frame
+		Pos     token.Pos
+		Value   Object
+	}
+	// ErrorValue is an error caught by a try expression. Unlike *Error it
+	// does not propagate, so a script can inspect it, pass it around and
+	// throw it again.
+	ErrorValue struct{ Err *Error }
+	Function   struct {
+		Name   string // empty for anonymous functions
 		Env    *Environment
 		Params []ast.Identifier
-		Body   *ast.BlockStatement
+		// Defaults and Rest are as in ast.FunctionLiteral; defaults are
+		// evaluated in the scope of the call.
+		Defaults []ast.Expression
+		Rest     *ast.Identifier
+		// Receiver is as in ast.FunctionLiteral. Self is the instance a
+		// method has been looked up on, which Receiver is bound to.
+		Receiver  *ast.Identifier
+		Self      Object
+		Generator bool
+		Body      *ast.BlockStatement
+		// Resolved functions keep their parameters and locals in a frame of
+		// Locals slots rather than in a map.
+		Resolved bool
+		Locals   int
 	}
 	String  struct{ Value string }
 	Builtin struct{ Fn BuiltinFunction }
-	Array   struct{ Elems []Object }
-	Hash    struct{ Pairs map[string]Pair }
+	// Array is never modified after construction; builtins such as push
+	// return a new array, so arrays can be shared freely between goroutines.
+	// The new array shares the elements of the old one, which makes push and
+	// rest take constant time.
+	Array struct{ elems Vector }
+	// Hash can be modified through assignment, so access after construction
+	// must go through Get and Set which serialize concurrent use. The zero
+	// Hash is empty.
+	Hash struct {
+		mu     sync.RWMutex
+		pairs  Map
+		meta   *Hash
+		frozen atomic.Bool
+	}
+	// Module is an imported module: the bindings it exports, by name. It is
+	// never modified after the module has been evaluated.
+	Module struct {
+		Path    string
+		Exports map[string]Object
+	}
+	// StructType is a declared struct. Calling it constructs an instance;
+	// Methods are shared by all instances.
+	StructType struct {
+		Name    string
+		Fields  []string
+		Methods map[string]*Function
+	}
+	// Struct is an instance of a struct type. Its fields can be assigned,
+	// so access after construction must go through Get and Set.
+	Struct struct {
+		Def    *StructType
+		mu     sync.RWMutex
+		values []Object // by the index of the field in Def.Fields
+		frozen atomic.Bool
+	}
+	// EnumType is a declared enum.
+	EnumType struct {
+		Name     string
+		Variants []*Variant
+	}
+	// Variant is a variant of an enum. A variant with fields is called to
+	// construct its values; a variant without fields has a single value,
+	// Value.
+	Variant struct {
+		Enum   *EnumType
+		Name   string
+		Fields []string
+		Value  *EnumValue
+	}
+	// EnumValue is a value of an enum: a variant, and the values of its
+	// fields by index. It is never modified after construction.
+	EnumValue struct {
+		Variant *Variant
+		Values  []Object
+	}
+	// Quote is unevaluated code, as returned by quote(...). Macros take
+	// their arguments as quotes and return the quote to expand to.
+	Quote struct{ Node ast.Node }
+	// Macro is a macro defined by a top-level let. It only exists during
+	// macro expansion; see eval.Expand.
+	Macro struct {
+		Params []ast.Identifier
+		Env    *Environment
+		Body   *ast.BlockStatement
+	}
+	// CompiledFunction is a function compiled to bytecode for the vm.
+	CompiledFunction struct {
+		Instructions []byte
+		NumLocals    int
+		NumParams    int
+		ParamNames   []string // for error messages
+	}
+	// Closure is a compiled function together with the free variables it
+	// captured when it was created.
+	Closure struct {
+		Fn   *CompiledFunction
+		Free []Object
+	}
 )
 
 func (i *Integer) Type() Type     { return INTEGER_OBJ }
@@ -68,23 +202,175 @@ func (r *Return) String() string { return fmt.Sprintf("return %s", r.Object.Stri
 
 func (e *Error) Type() Type                 { return ERROR_OBJ }
 func (e *Error) String() string             { return fmt.Sprintf("error: %s", e.Message) }
+func (e *Error) Error() string              { return e.Message }
 func Errorf(format string, a ...any) *Error { return &Error{Message: fmt.Sprintf(format, a...)} }
 func IsError(o Object) bool                 { return o.Type() == ERROR_OBJ }
-func ErrorExpected(s string) *Error         { return Errorf("Expected %s", s) }
+func ErrorExpected(s string) *Error         { return NewError(TypeError, "Expected %s", s) }
+
+func (e *ErrorValue) Type() Type     { return ERROR_VALUE_OBJ }
+func (e *ErrorValue) String() string { return e.Err.String() }
 
 func (f *Function) Type() Type { return FUNCTION_OBJ }
 func (f *Function) String() string {
 	var params []string
-	for _, p := range f.Params {
+	for i, p := range f.Params {
+		if f.Defaults != nil && f.Defaults[i] != nil {
+			params = append(params, fmt.Sprintf("%s = %s", p.String(), f.Defaults[i]))
+			continue
+		}
 		params = append(params, p.String())
 	}
+	if f.Rest != nil {
+		params = append(params, "..."+f.Rest.String())
+	}
 	indent2 := func(s string) string { return strings.Replace(s, "\n", "\n  ", -1) }
-	return fmt.Sprintf("fn(%s) {\n%s\n}",
+	name := f.Name
+	if name != "" {
+		name = " " + name
+	}
+	fn := "fn"
+	if f.Generator {
+		fn = "fn*"
+	}
+	return fmt.Sprintf("%s%s(%s) {\n%s\n}",
+		fn,
+		name,
 		strings.Join(params, ", "),
 		indent2(f.Body.String()),
 	)
 }
 
+func (m *Module) Type() Type     { return MODULE_OBJ }
+func (m *Module) String() string { return fmt.Sprintf("<module %s>", m.Path) }
+
+func (t *StructType) Type() Type     { return STRUCT_TYPE_OBJ }
+func (t *StructType) String() string { return fmt.Sprintf("struct %s", t.Name) }
+
+// Field returns the index of the named field, or -1 if there is none.
+func (t *StructType) Field(name string) int {
+	for i, f := range t.Fields {
+		if f == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// NewStruct returns an instance of t with the given values, one for each
+// field.
+func NewStruct(t *StructType, values []Object) *Struct {
+	return &Struct{Def: t, values: values}
+}
+func (s *Struct) Type() Type { return STRUCT_OBJ }
+func (s *Struct) String() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var fields []string
+	for i, f := range s.Def.Fields {
+		fields = append(fields, fmt.Sprintf("%s: %s", f, s.values[i]))
+	}
+	return fmt.Sprintf("%s{%s}", s.Def.Name, strings.Join(fields, ", "))
+}
+func (s *Struct) Get(field string) (Object, bool) {
+	i := s.Def.Field(field)
+	if i < 0 {
+		return nil, false
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.values[i], true
+}
+
+// Set assigns to a field. It fails with ErrNoField if the struct does not
+// have the field, and with ErrFrozenValue if the struct is frozen.
+func (s *Struct) Set(field string, value Object) error {
+	i := s.Def.Field(field)
+	if i < 0 {
+		return ErrNoField
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.frozen.Load() {
+		return ErrFrozenValue
+	}
+	s.values[i] = value
+	return nil
+}
+
+// Values returns the values of the fields, in the order of Def.Fields.
+func (s *Struct) Values() []Object {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]Object(nil), s.values...)
+}
+
+// Freeze marks s as immutable, after which Set fails. Freezing cannot be
+// undone.
+func (s *Struct) Freeze() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.frozen.Store(true)
+}
+func (s *Struct) Frozen() bool { return s.frozen.Load() }
+
+func (t *EnumType) Type() Type     { return ENUM_TYPE_OBJ }
+func (t *EnumType) String() string { return fmt.Sprintf("enum %s", t.Name) }
+
+// Variant returns the variant with the given name, if there is one.
+func (t *EnumType) Variant(name string) (*Variant, bool) {
+	for _, v := range t.Variants {
+		if v.Name == name {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+func (v *Variant) Type() Type     { return VARIANT_OBJ }
+func (v *Variant) String() string { return fmt.Sprintf("%s.%s", v.Enum.Name, v.Name) }
+
+// Field returns the index of the named field, or -1 if there is none.
+func (v *Variant) Field(name string) int {
+	for i, f := range v.Fields {
+		if f == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func (e *EnumValue) Type() Type { return ENUM_OBJ }
+func (e *EnumValue) String() string {
+	if len(e.Variant.Fields) == 0 {
+		return e.Variant.Name
+	}
+	var values []string
+	for _, v := range e.Values {
+		values = append(values, v.String())
+	}
+	return fmt.Sprintf("%s(%s)", e.Variant.Name, strings.Join(values, ", "))
+}
+
+func (q *Quote) Type() Type     { return QUOTE_OBJ }
+func (q *Quote) String() string { return fmt.Sprintf("QUOTE(%s)", q.Node) }
+
+func (m *Macro) Type() Type { return MACRO_OBJ }
+func (m *Macro) String() string {
+	var params []string
+	for _, p := range m.Params {
+		params = append(params, p.String())
+	}
+	return fmt.Sprintf("macro(%s) {\n%s\n}", strings.Join(params, ", "), m.Body.String())
+}
+
+func (f *CompiledFunction) Type() Type { return COMPILED_FUNCTION_OBJ }
+func (f *CompiledFunction) String() string {
+	return fmt.Sprintf("CompiledFunction[%p]", f)
+}
+
+func (c *Closure) Type() Type     { return CLOSURE_OBJ }
+func (c *Closure) String() string { return fmt.Sprintf("Closure[%p]", c) }
+
 func (s *String) Type() Type { return STRING_OBJ }
 func (s *String) String() string {
 	return s.Value
@@ -95,24 +381,124 @@ func (b *Builtin) String() string {
 	return "builtin function"
 }
 
+// NewArray returns an array of elems.
+func NewArray(elems ...Object) *Array { return &Array{elems: NewVector(elems)} }
+
 func (a *Array) Type() Type { return ARRAY_OBJ }
 func (a *Array) String() string {
 	var elems []string
-	for _, e := range a.Elems {
+	for _, e := range a.Elems() {
 		elems = append(elems, e.String())
 	}
 	return fmt.Sprintf("[%s]", strings.Join(elems, ", "))
 }
 
+// Len returns the number of elements of a.
+func (a *Array) Len() int { return a.elems.Len() }
+
+// At returns the element at index i, which must be in range.
+func (a *Array) At(i int) Object { return a.elems.At(i) }
+
+// Elems returns the elements of a in a new slice.
+func (a *Array) Elems() []Object { return a.elems.Slice() }
+
+// Append returns a new array of the elements of a followed by elems.
+func (a *Array) Append(elems ...Object) *Array {
+	v := a.elems
+	for _, e := range elems {
+		v = v.Append(e)
+	}
+	return &Array{elems: v}
+}
+
+// Drop returns a new array of the elements of a without the first n; n must
+// not exceed Len.
+func (a *Array) Drop(n int) *Array { return &Array{elems: a.elems.Drop(n)} }
+
 func (h *Hash) Type() Type { return HASH_OBJ }
 func (h *Hash) String() string {
 	var pairs []string
-	for _, pair := range h.Pairs {
+	for _, pair := range h.Pairs() {
 		pairs = append(pairs, fmt.Sprintf("%s: %s", pair.Key.String(), pair.Value.String()))
 	}
 	return fmt.Sprintf("{%s}", strings.Join(pairs, ", "))
 }
-func (h *Hash) Set(key Object, value Object) {
-	hstr := fmt.Sprintf("%x", md5.Sum([]byte(key.String())))
-	h.Pairs[hstr] = Pair{Key: key, Value: value}
+
+// Set sets key to value. It fails with ErrFrozenValue if h is frozen.
+func (h *Hash) Set(key Object, value Object) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.frozen.Load() {
+		return ErrFrozenValue
+	}
+	h.pairs = h.pairs.Set(HashKey(key), Pair{Key: key, Value: value})
+	return nil
+}
+func (h *Hash) Get(key Object) (Object, bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	pair, ok := h.pairs.Get(HashKey(key))
+	return pair.Value, ok
+}
+func (h *Hash) Len() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.pairs.Len()
+}
+
+// Pairs returns the pairs of h, in no particular order. They are those of
+// h at the time of the call, even if h is modified while they are used.
+func (h *Hash) Pairs() []Pair {
+	h.mu.RLock()
+	m := h.pairs
+	h.mu.RUnlock()
+	pairs := make([]Pair, 0, m.Len())
+	m.Range(func(_ string, pair Pair) bool {
+		pairs = append(pairs, pair)
+		return true
+	})
+	return pairs
+}
+
+// Copy returns a new hash with the pairs and meta table of h. It takes
+// constant time, as the two share their pairs until either is modified.
+func (h *Hash) Copy() *Hash {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return &Hash{pairs: h.pairs, meta: h.meta}
+}
+
+// Meta returns the meta table of h, which holds the handlers of the
+// operators h overloads, or nil if it has none.
+func (h *Hash) Meta() *Hash {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.meta
+}
+
+// Freeze marks h as immutable, after which Set and SetMeta fail. Freezing
+// cannot be undone, but a Copy of a frozen hash is not frozen.
+func (h *Hash) Freeze() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.frozen.Store(true)
+}
+func (h *Hash) Frozen() bool { return h.frozen.Load() }
+
+// SetMeta sets the meta table of h; nil removes it. It fails with
+// ErrFrozenValue if h is frozen.
+func (h *Hash) SetMeta(meta *Hash) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.frozen.Load() {
+		return ErrFrozenValue
+	}
+	h.meta = meta
+	return nil
+}
+
+// HashKey computes the key under which obj is stored in a Hash.
+// for now we'll just naively use md5sum on the string representation. what could go wrong
+func HashKey(obj Object) string {
+	return fmt.Sprintf("%x", md5.Sum([]byte(obj.String())))
 }
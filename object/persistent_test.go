@@ -0,0 +1,255 @@
+package object
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func ints(v Vector) []int64 {
+	var out []int64
+	for _, e := range v.Slice() {
+		out = append(out, e.(*Integer).Value)
+	}
+	return out
+}
+
+func TestVector(t *testing.T) {
+	// cover the tail, one level of the trie and a root that overflowed
+	for _, n := range []int{0, 1, 31, 32, 33, 64, 1056, 1089, 40000} {
+		t.Run(fmt.Sprint(n), func(t *testing.T) {
+			var v Vector
+			for i := 0; i < n; i++ {
+				v = v.Append(&Integer{Value: int64(i)})
+			}
+			if v.Len() != n {
+				t.Fatalf("expected %d elements, got %d", n, v.Len())
+			}
+			for i := 0; i < n; i++ {
+				if got := v.At(i).(*Integer).Value; got != int64(i) {
+					t.Fatalf("element %d: got %d", i, got)
+				}
+			}
+			got := ints(v)
+			if len(got) != n {
+				t.Fatalf("Slice returned %d elements, expected %d", len(got), n)
+			}
+			for i, x := range got {
+				if x != int64(i) {
+					t.Fatalf("Slice: element %d is %d", i, x)
+				}
+			}
+		})
+	}
+}
+
+func TestVectorIsPersistent(t *testing.T) {
+	var v Vector
+	for i := 0; i < 100; i++ {
+		v = v.Append(&Integer{Value: int64(i)})
+	}
+	a := v.Append(&Integer{Value: 100})
+	b := v.Append(&Integer{Value: -1})
+	set := v.Set(5, &Integer{Value: -5}).Set(99, &Integer{Value: -99})
+	dropped := v.Drop(40)
+
+	if v.Len() != 100 || v.At(5).(*Integer).Value != 5 || v.At(99).(*Integer).Value != 99 {
+		t.Fatalf("the original vector changed: %v", ints(v))
+	}
+	if a.At(100).(*Integer).Value != 100 || b.At(100).(*Integer).Value != -1 {
+		t.Fatalf("appends interfere: %d, %d", a.At(100).(*Integer).Value, b.At(100).(*Integer).Value)
+	}
+	if set.At(5).(*Integer).Value != -5 || set.At(99).(*Integer).Value != -99 || set.At(6).(*Integer).Value != 6 {
+		t.Fatalf("unexpected elements after Set: %v", ints(set))
+	}
+	if dropped.Len() != 60 || dropped.At(0).(*Integer).Value != 40 || ints(dropped)[59] != 99 {
+		t.Fatalf("unexpected elements after Drop: %v", ints(dropped))
+	}
+	more := dropped.Append(&Integer{Value: 100})
+	if more.Len() != 61 || more.At(60).(*Integer).Value != 100 {
+		t.Fatalf("unexpected elements after Drop and Append: %v", ints(more))
+	}
+	if empty := v.Drop(100); empty.Len() != 0 || empty.Append(&Integer{Value: 1}).At(0).(*Integer).Value != 1 {
+		t.Fatalf("dropping every element should leave an empty vector")
+	}
+}
+
+func TestMap(t *testing.T) {
+	var m Map
+	for i := 0; i < 5000; i++ {
+		m = m.Set(fmt.Sprint(i), Pair{Value: &Integer{Value: int64(i)}})
+	}
+	old := m
+	m = m.Set("7", Pair{Value: &Integer{Value: -7}})
+	if m.Len() != 5000 {
+		t.Fatalf("expected 5000 keys, got %d", m.Len())
+	}
+	for i := 0; i < 5000; i++ {
+		want := int64(i)
+		if i == 7 {
+			want = -7
+		}
+		pair, ok := m.Get(fmt.Sprint(i))
+		if !ok || pair.Value.(*Integer).Value != want {
+			t.Fatalf("key %d: got %v, %v", i, pair.Value, ok)
+		}
+	}
+	if pair, _ := old.Get("7"); pair.Value.(*Integer).Value != 7 {
+		t.Fatalf("the original map changed")
+	}
+	if _, ok := m.Get("nope"); ok {
+		t.Fatalf("found a key that was never set")
+	}
+	seen := 0
+	m.Range(func(string, Pair) bool { seen++; return true })
+	if seen != 5000 {
+		t.Fatalf("Range visited %d keys", seen)
+	}
+}
+
+func TestMapCollisions(t *testing.T) {
+	defer func(h func(string) uint64) { hashString = h }(hashString)
+	hashString = func(s string) uint64 { return uint64(len(s)) }
+	var m Map
+	for _, k := range []string{"a", "b", "c", "bb"} {
+		m = m.Set(k, Pair{Value: &String{Value: k}})
+	}
+	m = m.Set("b", Pair{Value: &String{Value: "B"}})
+	if m.Len() != 4 {
+		t.Fatalf("expected 4 keys, got %d", m.Len())
+	}
+	for k, want := range map[string]string{"a": "a", "b": "B", "c": "c", "bb": "bb"} {
+		if pair, ok := m.Get(k); !ok || pair.Value.(*String).Value != want {
+			t.Fatalf("key %s: got %v, %v", k, pair.Value, ok)
+		}
+	}
+	if _, ok := m.Get("d"); ok {
+		t.Fatalf("found a key that was never set")
+	}
+}
+
+// The benchmarks build a list one element at a time and take it apart
+// again, as a recursive script does with push and rest. With the vector
+// each step takes constant time, so the time per element stays flat as n
+// grows; copying the slice, as arrays used to, makes it grow linearly.
+
+var sizes = []int{100, 1000, 10000}
+
+func BenchmarkPush(b *testing.B) {
+	for _, n := range sizes {
+		b.Run(fmt.Sprintf("vector/%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				arr := &Array{}
+				for j := 0; j < n; j++ {
+					arr = arr.Append(TRUE)
+				}
+			}
+		})
+		b.Run(fmt.Sprintf("copy/%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				var elems []Object
+				for j := 0; j < n; j++ {
+					next := make([]Object, len(elems)+1)
+					copy(next, elems)
+					next[len(elems)] = TRUE
+					elems = next
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkRest(b *testing.B) {
+	for _, n := range sizes {
+		elems := make([]Object, n)
+		for i := range elems {
+			elems[i] = TRUE
+		}
+		arr := NewArray(elems...)
+		b.Run(fmt.Sprintf("vector/%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				for a := arr; a.Len() > 0; {
+					a = a.Drop(1)
+				}
+			}
+		})
+		b.Run(fmt.Sprintf("copy/%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				for a := elems; len(a) > 0; {
+					next := make([]Object, len(a)-1)
+					copy(next, a[1:])
+					a = next
+				}
+			}
+		})
+	}
+}
+
+func TestFrozen(t *testing.T) {
+	t.Run("hash", func(t *testing.T) {
+		h := &Hash{}
+		h.Freeze()
+		if err := h.Set(&Integer{Value: 1}, TRUE); !errors.Is(err, ErrFrozenValue) {
+			t.Fatalf("Set: expected ErrFrozenValue, got %v", err)
+		}
+		if err := h.SetMeta(&Hash{}); !errors.Is(err, ErrFrozenValue) {
+			t.Fatalf("SetMeta: expected ErrFrozenValue, got %v", err)
+		}
+		if h.Len() != 0 || h.Meta() != nil {
+			t.Fatalf("frozen hash was modified: %s", h)
+		}
+		if err := h.Copy().Set(&Integer{Value: 1}, TRUE); err != nil {
+			t.Fatalf("Set on a copy: %v", err)
+		}
+	})
+	t.Run("struct", func(t *testing.T) {
+		s := NewStruct(&StructType{Name: "P", Fields: []string{"a"}}, []Object{NULL})
+		if err := s.Set("b", TRUE); !errors.Is(err, ErrNoField) {
+			t.Fatalf("expected ErrNoField, got %v", err)
+		}
+		s.Freeze()
+		if err := s.Set("a", TRUE); !errors.Is(err, ErrFrozenValue) {
+			t.Fatalf("expected ErrFrozenValue, got %v", err)
+		}
+		if v, _ := s.Get("a"); v != NULL {
+			t.Fatalf("frozen struct was modified: %s", s)
+		}
+	})
+	t.Run("race", func(t *testing.T) {
+		// a Set that succeeds must happen before Freeze returns
+		h := &Hash{}
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				for j := 0; ; j++ {
+					if h.Set(&Integer{Value: int64(i*1000 + j)}, TRUE) != nil {
+						return
+					}
+				}
+			}(i)
+		}
+		h.Freeze()
+		n := h.Len()
+		wg.Wait()
+		if h.Len() != n {
+			t.Fatalf("hash grew from %d to %d after Freeze", n, h.Len())
+		}
+	})
+}
+
+func BenchmarkHashCopy(b *testing.B) {
+	for _, n := range sizes {
+		h := &Hash{}
+		for i := 0; i < n; i++ {
+			h.Set(&Integer{Value: int64(i)}, TRUE)
+		}
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				h.Copy().Set(&Integer{Value: 0}, FALSE)
+			}
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package object
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/kvalv/monkey/token"
+)
+
+// Frame is an entry of a call stack: the function that was called, by name
+// if it was called through a binding, and where the call was made.
+type Frame struct {
+	Function string
+	Span     token.Span
+	Pos      token.Pos
+}
+
+func (f Frame) String() string { return fmt.Sprintf("%s (called at %s)", f.Function, f.Pos) }
+
+// Stack is a call stack, innermost call first. Stacks are never modified, so
+// a stack can be shared by every environment and task that it applies to.
+type Stack struct {
+	Frame
+	Caller *Stack
+}
+
+// Push returns the stack with f called from the innermost call of s. s may
+// be nil, which is the empty stack.
+func (s *Stack) Push(f Frame) *Stack { return &Stack{Frame: f, Caller: s} }
+
+// Frames returns the frames of the stack, innermost first.
+func (s *Stack) Frames() []Frame {
+	var frames []Frame
+	for ; s != nil; s = s.Caller {
+		frames = append(frames, s.Frame)
+	}
+	return frames
+}
+
+// StackTrace formats the message and the call stack of the error, one frame
+// per line.
+func (e *Error) StackTrace() string {
+	var b strings.Builder
+	b.WriteString(e.Message)
+	for _, f := range e.Stack {
+		fmt.Fprintf(&b, "\n\tat %s", f)
+	}
+	return b.String()
+}
@@ -0,0 +1,177 @@
+package object
+
+const (
+	vecBits  = 5
+	vecWidth = 1 << vecBits
+	vecMask  = vecWidth - 1
+)
+
+// Vector is a persistent vector: an immutable sequence that is changed by
+// making a new vector, which shares all but O(log n) of its structure with
+// the old one. The elements are kept in a trie of nodes with 32 children,
+// except for the last (up to) 32, which are kept in a tail; appending
+// therefore takes amortized constant time and indexing O(log32 n), which is
+// at most 7 levels. Dropping elements from the front only moves an offset,
+// so the dropped elements stay alive as long as the vector does.
+//
+// The zero Vector is empty and ready to use.
+type Vector struct {
+	size   int // including the dropped elements
+	offset int // the number of dropped elements
+	shift  uint
+	root   *vnode
+	tail   []Object
+}
+
+// vnode is a node of the trie; leaves hold elements, other nodes children.
+type vnode struct {
+	children [vecWidth]*vnode
+	elems    []Object
+}
+
+// NewVector returns a vector of elems.
+func NewVector(elems []Object) Vector {
+	var v Vector
+	for _, e := range elems {
+		v = v.Append(e)
+	}
+	return v
+}
+
+// Len returns the number of elements of v.
+func (v Vector) Len() int { return v.size - v.offset }
+
+// At returns the element at index i, which must be in range.
+func (v Vector) At(i int) Object {
+	i += v.offset
+	return v.leaf(i)[i&vecMask]
+}
+
+// Append returns v with x added at the end.
+func (v Vector) Append(x Object) Vector {
+	if v.size-v.tailOffset() < vecWidth {
+		tail := make([]Object, len(v.tail)+1)
+		copy(tail, v.tail)
+		tail[len(v.tail)] = x
+		v.tail = tail
+		v.size++
+		return v
+	}
+	// the tail is full; it becomes a leaf of the trie
+	leaf := &vnode{elems: v.tail}
+	shift := v.levels()
+	if v.size>>vecBits > 1<<shift {
+		root := &vnode{}
+		root.children[0] = v.root
+		root.children[1] = newPath(shift, leaf)
+		v.root, v.shift = root, shift+vecBits
+	} else {
+		v.root, v.shift = pushTail(v.size, shift, v.root, leaf), shift
+	}
+	v.tail = []Object{x}
+	v.size++
+	return v
+}
+
+// Set returns v with the element at index i, which must be in range,
+// replaced by x.
+func (v Vector) Set(i int, x Object) Vector {
+	i += v.offset
+	if i >= v.tailOffset() {
+		tail := make([]Object, len(v.tail))
+		copy(tail, v.tail)
+		tail[i&vecMask] = x
+		v.tail = tail
+		return v
+	}
+	v.root = set(v.levels(), v.root, i, x)
+	return v
+}
+
+// Drop returns v without its first n elements; n must not exceed Len.
+func (v Vector) Drop(n int) Vector {
+	if n == v.Len() {
+		return Vector{}
+	}
+	v.offset += n
+	return v
+}
+
+// Slice returns the elements of v in a new slice.
+func (v Vector) Slice() []Object {
+	out := make([]Object, 0, v.Len())
+	for i := v.offset; i < v.size; {
+		leaf := v.leaf(i)
+		out = append(out, leaf[i&vecMask:]...)
+		i += len(leaf) - i&vecMask
+	}
+	return out
+}
+
+// levels is the shift of the root, which the zero Vector does not set.
+func (v Vector) levels() uint {
+	if v.shift == 0 {
+		return vecBits
+	}
+	return v.shift
+}
+
+// tailOffset is the index of the first element in the tail.
+func (v Vector) tailOffset() int {
+	if v.size < vecWidth {
+		return 0
+	}
+	return (v.size - 1) >> vecBits << vecBits
+}
+
+// leaf returns the elements of the leaf or tail that holds index i,
+// counting dropped elements.
+func (v Vector) leaf(i int) []Object {
+	if i >= v.tailOffset() {
+		return v.tail
+	}
+	node := v.root
+	for level := v.levels(); level > 0; level -= vecBits {
+		node = node.children[(i>>level)&vecMask]
+	}
+	return node.elems
+}
+
+// pushTail returns a copy of parent, which may be nil, with leaf added as
+// the last leaf. size is the number of elements before the tail was full.
+func pushTail(size int, level uint, parent, leaf *vnode) *vnode {
+	node := &vnode{}
+	if parent != nil {
+		node.children = parent.children
+	}
+	i := ((size - 1) >> level) & vecMask
+	if level == vecBits {
+		node.children[i] = leaf
+	} else {
+		node.children[i] = pushTail(size, level-vecBits, node.children[i], leaf)
+	}
+	return node
+}
+
+// newPath returns the path of nodes from a new root at level down to leaf.
+func newPath(level uint, leaf *vnode) *vnode {
+	if level == 0 {
+		return leaf
+	}
+	node := &vnode{}
+	node.children[0] = newPath(level-vecBits, leaf)
+	return node
+}
+
+func set(level uint, node *vnode, i int, x Object) *vnode {
+	cp := *node
+	if level == 0 {
+		cp.elems = make([]Object, len(node.elems))
+		copy(cp.elems, node.elems)
+		cp.elems[i&vecMask] = x
+		return &cp
+	}
+	sub := (i >> level) & vecMask
+	cp.children[sub] = set(level-vecBits, node.children[sub], i, x)
+	return &cp
+}
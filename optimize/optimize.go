@@ -0,0 +1,297 @@
+// Package optimize rewrites programs into cheaper programs that evaluate to
+// the same result.
+//
+// Constant expressions are folded with the evaluator's own operators, so an
+// expression is only replaced when the evaluator would compute the same
+// value; an expression that would fail at runtime, such as a division by
+// zero, is left as it is so that it still fails at runtime.
+package optimize
+
+import (
+	"strconv"
+
+	"github.com/kvalv/monkey/ast"
+	"github.com/kvalv/monkey/eval"
+	"github.com/kvalv/monkey/object"
+	"github.com/kvalv/monkey/token"
+)
+
+// Program optimizes prog in place and returns it. It should run before the
+// program is evaluated for the first time.
+//
+// The arguments of quote and of the macros that prog defines are code rather
+// than values, so they are left as they are.
+func Program(prog *ast.Program) *ast.Program {
+	o := &optimizer{macros: make(map[string]bool)}
+	for _, stmt := range prog.Statements {
+		if let, ok := stmt.(*ast.LetStatement); ok && let.Lhs != nil {
+			if _, ok := let.Rhs.(*ast.MacroLiteral); ok {
+				o.macros[let.Lhs.Value] = true
+			}
+		}
+	}
+	prog.Statements = o.statements(prog.Statements)
+	return prog
+}
+
+type optimizer struct {
+	// macros holds the names of the macros defined by the program
+	macros map[string]bool
+}
+
+// isCode reports whether the arguments of call are code: call is a call of
+// quote or of a macro.
+func (o *optimizer) isCode(call *ast.CallExpression) bool {
+	id, ok := call.Function.(*ast.Identifier)
+	return ok && (id.Value == "quote" || o.macros[id.Value])
+}
+
+// statements optimizes a statement list and drops the statements that follow
+// a return, except for function declarations, which are hoisted and so may
+// still be called.
+func (o *optimizer) statements(stmts []ast.Statement) []ast.Statement {
+	for i, stmt := range stmts {
+		stmts[i] = o.statement(stmt)
+		if es, ok := stmts[i].(*ast.ExpressionStatement); ok {
+			if _, ok := es.Expr.(*ast.ReturnExpression); ok {
+				return append(stmts[:i+1], o.declarations(stmts[i+1:])...)
+			}
+		}
+	}
+	return stmts
+}
+
+func (o *optimizer) declarations(stmts []ast.Statement) []ast.Statement {
+	var out []ast.Statement
+	for _, stmt := range stmts {
+		if ast.Declaration(stmt) != nil {
+			out = append(out, o.statement(stmt))
+		}
+	}
+	return out
+}
+
+func (o *optimizer) statement(stmt ast.Statement) ast.Statement {
+	switch n := stmt.(type) {
+	case *ast.LetStatement:
+		n.Rhs = o.expression(n.Rhs)
+		if n.Pattern != nil {
+			o.pattern(n.Pattern)
+		}
+	case *ast.FunctionDeclaration:
+		o.expression(n.Fn)
+	case *ast.ExportStatement:
+		o.statement(n.Stmt)
+	case *ast.StructDeclaration:
+		for _, m := range n.Methods {
+			o.expression(m.Fn)
+		}
+	case *ast.ExpressionStatement:
+		n.Expr = o.expression(n.Expr)
+	case *ast.BlockStatement:
+		n.Statements = o.statements(n.Statements)
+	}
+	return stmt
+}
+
+// clauses optimizes the clauses of a comprehension
+func (o *optimizer) clauses(cs []ast.ComprehensionClause) {
+	for i := range cs {
+		c := &cs[i]
+		if c.Cond != nil {
+			c.Cond = o.expression(c.Cond)
+			continue
+		}
+		c.Iterable = o.expression(c.Iterable)
+		o.pattern(c.Target)
+	}
+}
+
+// pattern optimizes the keys and defaults of a destructuring pattern
+func (o *optimizer) pattern(pat ast.Pattern) {
+	element := func(e *ast.PatternElement) {
+		if e.Default != nil {
+			e.Default = o.expression(e.Default)
+		}
+		o.pattern(e.Target)
+	}
+	switch p := pat.(type) {
+	case *ast.LiteralPattern:
+		p.Value = o.expression(p.Value)
+	case *ast.ArrayPattern:
+		for i := range p.Elems {
+			element(&p.Elems[i])
+		}
+	case *ast.HashPattern:
+		for i := range p.Pairs {
+			p.Pairs[i].Key = o.expression(p.Pairs[i].Key)
+			element(&p.Pairs[i].PatternElement)
+		}
+	case *ast.VariantPattern:
+		for _, field := range p.Fields {
+			o.pattern(field)
+		}
+	}
+}
+
+func (o *optimizer) expression(expr ast.Expression) ast.Expression {
+	switch n := expr.(type) {
+	case *ast.PrefixExpression:
+		n.Rhs = o.expression(n.Rhs)
+		if rhs, ok := constant(n.Rhs); ok {
+			return fold(n.Token, eval.Prefix(n.Op, rhs), n)
+		}
+	case *ast.InfixExpression:
+		n.Lhs = o.expression(n.Lhs)
+		n.Rhs = o.expression(n.Rhs)
+		if n.Op == "??" {
+			// a literal is never null
+			if _, ok := constant(n.Lhs); ok {
+				return n.Lhs
+			}
+			return n
+		}
+		lhs, lok := constant(n.Lhs)
+		rhs, rok := constant(n.Rhs)
+		if lok && rok {
+			return fold(n.Token, eval.Infix(n.Op, lhs, rhs), n)
+		}
+	case *ast.IfExpression:
+		return o.ifExpression(n)
+	case *ast.AssignExpression:
+		n.Lhs = o.expression(n.Lhs)
+		n.Rhs = o.expression(n.Rhs)
+	case *ast.ReturnExpression:
+		n.Value = o.expression(n.Value)
+	case *ast.ThrowExpression:
+		n.Value = o.expression(n.Value)
+	case *ast.TryExpression:
+		o.statement(n.Block)
+		if n.Catch != nil {
+			o.statement(n.Catch)
+		}
+		if n.Finally != nil {
+			o.statement(n.Finally)
+		}
+	case *ast.FunctionLiteral:
+		for i, d := range n.Defaults {
+			if d != nil {
+				n.Defaults[i] = o.expression(d)
+			}
+		}
+		o.statement(n.Body)
+	case *ast.CallExpression:
+		if o.isCode(n) {
+			return n
+		}
+		n.Function = o.expression(n.Function)
+		for i, p := range n.Params {
+			n.Params[i] = o.expression(p)
+		}
+	case *ast.MatchExpression:
+		n.Value = o.expression(n.Value)
+		for i := range n.Arms {
+			arm := &n.Arms[i]
+			o.pattern(arm.Pattern)
+			if arm.Guard != nil {
+				arm.Guard = o.expression(arm.Guard)
+			}
+			arm.Body = o.expression(arm.Body)
+		}
+	case *ast.Spread:
+		n.Value = o.expression(n.Value)
+	case *ast.KeywordArgument:
+		n.Value = o.expression(n.Value)
+	case *ast.Array:
+		for i, e := range n.Elems {
+			n.Elems[i] = o.expression(e)
+		}
+	case *ast.ArrayIndex:
+		n.Array = o.expression(n.Array)
+		n.Index = o.expression(n.Index)
+	case *ast.FieldExpression:
+		n.Value = o.expression(n.Value)
+	case *ast.ArrayComprehension:
+		o.clauses(n.Clauses)
+		n.Value = o.expression(n.Value)
+	case *ast.HashComprehension:
+		o.clauses(n.Clauses)
+		n.Key = o.expression(n.Key)
+		n.Value = o.expression(n.Value)
+	case *ast.HashLiteral:
+		pairs := make(map[ast.Expression]ast.Expression, len(n.Pairs))
+		for k, v := range n.Pairs {
+			pairs[o.expression(k)] = o.expression(v)
+		}
+		n.Pairs = pairs
+	}
+	return expr
+}
+
+// ifExpression drops the branch that can never be taken when the condition
+// is a constant. If the remaining branch is a single expression, that
+// expression replaces the if altogether.
+func (o *optimizer) ifExpression(n *ast.IfExpression) ast.Expression {
+	n.Cond = o.expression(n.Cond)
+	o.statement(n.Then)
+	if n.Else != nil {
+		o.statement(n.Else)
+	}
+	cond, ok := constant(n.Cond)
+	if !ok {
+		return n
+	}
+	live := n.Then
+	if cond == object.FALSE {
+		live = n.Else
+	}
+	if live == nil {
+		// if false { ... } without an else evaluates to null
+		n.Then = &ast.BlockStatement{Token: n.Then.Token}
+		n.Else = nil
+		return n
+	}
+	if len(live.Statements) == 1 {
+		if es, ok := live.Statements[0].(*ast.ExpressionStatement); ok {
+			return es.Expr
+		}
+	}
+	// keep the block, since it may bind names or hold several statements
+	n.Cond = &ast.Boolean{Token: token.Token{Type: token.TRUE, Literal: "true", Span: n.Token.Span}, Value: true}
+	n.Then, n.Else = live, nil
+	return n
+}
+
+// constant returns the value of a literal
+func constant(expr ast.Expression) (object.Object, bool) {
+	switch n := expr.(type) {
+	case *ast.Number:
+		return &object.Integer{Value: int64(n.Value)}, true
+	case *ast.String:
+		return &object.String{Value: n.Value}, true
+	case *ast.Boolean:
+		if n.Value {
+			return object.TRUE, true
+		}
+		return object.FALSE, true
+	}
+	return nil, false
+}
+
+// fold turns the value of a constant expression back into a literal with the
+// position of the original expression. If evaluating it failed, the original
+// is kept.
+func fold(tok token.Token, value object.Object, original ast.Expression) ast.Expression {
+	switch v := value.(type) {
+	case *object.Integer:
+		tok.Type, tok.Literal = token.INT, strconv.FormatInt(v.Value, 10)
+		return &ast.Number{Token: tok, Value: int(v.Value)}
+	case *object.String:
+		tok.Type, tok.Literal = token.STRING, strconv.Quote(v.Value)
+		return &ast.String{Token: tok, Value: v.Value}
+	case *object.Boolean:
+		tok.Type, tok.Literal = token.Type(v.String()), v.String()
+		return &ast.Boolean{Token: tok, Value: v.Value}
+	}
+	return original
+}
@@ -0,0 +1,83 @@
+package optimize_test
+
+import (
+	"testing"
+
+	"github.com/kvalv/monkey/eval"
+	"github.com/kvalv/monkey/object"
+	"github.com/kvalv/monkey/optimize"
+	"github.com/kvalv/monkey/parser"
+)
+
+func TestProgram(t *testing.T) {
+	cases := []struct {
+		input    string
+		expected string
+	}{
+		{"1 + 2 * 3", "7"},
+		{"-(2 + 3)", "-5"},
+		{`"a" + "b" == "ab"`, "true"},
+		{"!(1 < 2)", "false"},
+		{"x + 2 * 3", "(x + 6)"},
+		{"1 / 0", "(1 / 0)"},
+		{"2 * (1 / 0)", "(2 * (1 / 0))"},
+		{"5 + true", "(5 + true)"},
+		{"if (true) { 1 } else { 2 }", "1"},
+		{"if (1 > 2) { 1 } else { x }", "x"},
+		{"if (false) { 1 }", "if false {}"},
+		{"if (true) { let a = 1; a }", "if true {let a = 1a}"},
+		{"if (x) { 1 + 1 }", "if x {2}"},
+		{"fn(x) { return x; x + 1 }", "fn(x) {return x}"},
+		{"1; return 2 + 2; 3", "1return 4"},
+		{"fn() { return f(); 1; fn f() { 1 + 1 } }", "fn() {return f([])fn f() {2}}"},
+		{"if true { if true { return 2; } return 1; }", "return 2"},
+		{"[1 + 1, f(2 * 2)][0 + 0]", "[2, f([4])][0]"},
+		{"let [a = 1 + 1, {\"k\": b = 2 * 3}] = x", "let [a = 2, {\"k\": b = 6}] = x"},
+		{"match 1 + 1 { -1 => 0, n if 2 > 1 => n * (2 * 3) }", "match 2 {-1 => 0, n if true => (n * 6)}"},
+		{"1 ?? x", "1"},
+		{"x ?? 1 + 1", "(x ?? 2)"},
+		{"quote(1 + 2)", "quote([(1 + 2)])"},
+		{"let m = macro(x) { x }; m(1 + 2) + (1 + 2)", "let m = macro(x) {x}(m([(1 + 2)]) + 3)"},
+		{"fn(m) { m(1 + 2) }", "fn(m) {m([3])}"},
+		{"[x * (1 + 1) for x in [2 * 2] if 1 < 2]", "[(x * 2) for x in [4] if true]"},
+		{"{x: 2 - 1 for x in xs}", "{x: 1 for x in xs}"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.input, func(t *testing.T) {
+			prog, errs := parser.New(tc.input).Parse()
+			if len(errs) > 0 {
+				t.Fatalf("failed to parse: %v", errs)
+			}
+			if got := optimize.Program(prog).String(); got != tc.expected {
+				t.Fatalf("expected %q, got %q", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestSameResult(t *testing.T) {
+	inputs := []string{
+		"50 / 2 * 2 + 10",
+		"1 / 0",
+		"let f = fn(x) { x / (2 - 2) }; f(1)",
+		"5 + true",
+		"if (3 < 2) { 4 } else { 5 }",
+		"if (false) { 1 }",
+		"3; return 4; return 5; 6",
+		"let a = 10; let b = a > 7; let c = if b { 99 } else { 98 }",
+		"let f = fn(n) { if (true) { let m = n * 2; m + 1 } else { 0 } }; f(4)",
+		`let h = {1 + 1: "two"}; h[2]`,
+		`{"a": 1}["b"] ?? 2 * 3`,
+	}
+	for _, input := range inputs {
+		t.Run(input, func(t *testing.T) {
+			original, _ := parser.New(input).Parse()
+			optimized, _ := parser.New(input).Parse()
+			expected := eval.Eval(original, object.NewEnvironment())
+			got := eval.Eval(optimize.Program(optimized), object.NewEnvironment())
+			if expected.Type() != got.Type() || expected.String() != got.String() {
+				t.Fatalf("expected %q, got %q", expected, got)
+			}
+		})
+	}
+}
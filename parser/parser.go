@@ -21,6 +21,9 @@ type Parser struct {
 	curr, next token.Token
 	errs       []error
 	tracer     *tracer.Tracer
+	// guard is set while parsing the guard of a match arm, where the =>
+	// after a ( ... ) ends the guard instead of starting an arrow function
+	guard bool
 
 	prefixFns map[token.Type]PrefixFn
 	infixFns  map[token.Type]InfixFn
@@ -54,9 +57,16 @@ func New(input string, opts ...parseOpt) *Parser {
 	p.prefixFns[token.POPEN] = p.parseGroupExpression
 	p.prefixFns[token.IF] = p.parseIfExpression
 	p.prefixFns[token.FUNC] = p.parseFunctionLiteral
+	p.prefixFns[token.GENERATOR] = p.parseFunctionLiteral
 	p.prefixFns[token.RETURN] = p.parseReturnExpression
+	p.prefixFns[token.THROW] = p.parseThrowExpression
+	p.prefixFns[token.TRY] = p.parseTryExpression
 	p.prefixFns[token.SOPEN] = p.parseArray
 	p.prefixFns[token.LBRACK] = p.parseHashLiteral
+	p.prefixFns[token.ELLIPSIS] = p.parseSpread
+	p.prefixFns[token.MATCH] = p.parseMatchExpression
+	p.prefixFns[token.IMPORT] = p.parseImportExpression
+	p.prefixFns[token.MACRO] = p.parseMacroLiteral
 
 	p.infixFns[token.NEQ] = p.parseInfixExpression
 	p.infixFns[token.EQ] = p.parseInfixExpression
@@ -69,6 +79,10 @@ func New(input string, opts ...parseOpt) *Parser {
 	p.infixFns[token.POPEN] = p.parseCallExpression // todo: function call
 	p.infixFns[token.SOPEN] = p.parseArrayIndexExpression
 	p.infixFns[token.ASSIGN] = p.parseAssignExpression
+	p.infixFns[token.DOT] = p.parseFieldExpression
+	p.infixFns[token.OPTIONAL] = p.parseOptionalExpression
+	p.infixFns[token.COALESCE] = p.parseInfixExpression
+	p.infixFns[token.PIPE] = p.parsePipeExpression
 	return p
 }
 func (p *Parser) advance() {
@@ -121,7 +135,6 @@ func (p *Parser) Parse() (*ast.Program, []error) {
 		stmt := p.parseStatement()
 		if stmt != nil {
 			prog.Statements = append(prog.Statements, stmt)
-			p.advance()
 		} else {
 			break
 		}
@@ -154,14 +167,20 @@ func (p *Parser) parseIdentifier() ast.Expression {
 }
 
 func (p *Parser) parseLetStatement(precedence int) *ast.LetStatement {
-	stmt := &ast.LetStatement{Token: p.curr}
+	stmt := &ast.LetStatement{Token: p.curr, Const: p.currIsType(token.CONST)}
 	p.advance()
 	defer p.tracer.Trace("parseLetStatement")(stmt)
-	lhs := p.parseIdentifier()
-	if lhs == nil {
-		return nil
+	if p.currIsType(token.SOPEN, token.LBRACK) || p.currIsType(token.IDENT) && p.nextIsType(token.POPEN, token.DOT) {
+		if stmt.Pattern = p.parsePattern(); stmt.Pattern == nil {
+			return nil
+		}
+	} else {
+		lhs := p.parseIdentifier()
+		if lhs == nil {
+			return nil
+		}
+		stmt.Lhs = lhs.(*ast.Identifier) // also probably not ideal..
 	}
-	stmt.Lhs = lhs.(*ast.Identifier) // also probably not ideal..
 	p.advance()
 	if _, ok := p.parseToken(token.ASSIGN); !ok {
 		return nil
@@ -169,14 +188,254 @@ func (p *Parser) parseLetStatement(precedence int) *ast.LetStatement {
 	if stmt.Rhs = p.parseExpression(LOWEST); stmt.Rhs == nil {
 		return nil
 	}
-	if p.nextIsType(token.SEMICOLON) {
+	// like expression statements, we leave off at the start of the next statement
+	p.advance()
+	if p.currIsType(token.SEMICOLON) {
 		p.advance()
 	}
 	return stmt
 }
 
+// parsePattern parses the target of a destructuring let or the pattern of a
+// match arm. Like an expression, it leaves off at its last token.
+func (p *Parser) parsePattern() ast.Pattern {
+	switch p.curr.Type {
+	case token.IDENT:
+		if p.curr.Literal == "_" {
+			return &ast.WildcardPattern{Token: p.curr}
+		}
+		if p.nextIsType(token.POPEN, token.DOT) {
+			if pat := p.parseVariantPattern(); pat != nil {
+				return pat
+			}
+			return nil
+		}
+		return p.parseIdentifier().(*ast.Identifier)
+	case token.INT, token.STRING, token.TRUE, token.FALSE:
+		pat := &ast.LiteralPattern{Token: p.curr}
+		if pat.Value = p.prefixFns[p.curr.Type](); pat.Value == nil {
+			return nil
+		}
+		return pat
+	case token.MINUS:
+		if !p.nextIsType(token.INT) {
+			p.errorf("Parse(): expected a number after - in pattern at %s", p.next.Pos)
+			return nil
+		}
+		pat := &ast.LiteralPattern{Token: p.curr}
+		if pat.Value = p.parsePrefixExpression(); pat.Value == nil {
+			return nil
+		}
+		return pat
+	case token.SOPEN:
+		if pat := p.parseArrayPattern(); pat != nil {
+			return pat
+		}
+	case token.LBRACK:
+		if pat := p.parseHashPattern(); pat != nil {
+			return pat
+		}
+	default:
+		p.errorf("Parse(): expected a pattern but got %v at %s", p.curr.Type, p.curr.Pos)
+	}
+	return nil
+}
+
+// parsePatternElement parses a pattern with an optional default value
+func (p *Parser) parsePatternElement() (ast.PatternElement, bool) {
+	var elem ast.PatternElement
+	if elem.Target = p.parsePattern(); elem.Target == nil {
+		return elem, false
+	}
+	if p.nextIsType(token.ASSIGN) {
+		p.advance()
+		p.advance()
+		if elem.Default = p.parseExpression(LOWEST); elem.Default == nil {
+			return elem, false
+		}
+	}
+	return elem, true
+}
+
+// parseElementEnd moves past the end of an element of a pattern, onto the
+// next element or the closing token.
+func (p *Parser) parseElementEnd(closing token.Type) bool {
+	p.advance()
+	switch p.curr.Type {
+	case token.COMMA:
+		p.advance()
+		return true
+	case closing:
+		return true
+	}
+	p.errorf("Parse(): expected %s or %s but got %v at %s", token.COMMA, closing, p.curr.Type, p.curr.Pos)
+	return false
+}
+
+// parseVariantPattern parses Variant(patterns...), Enum.Variant(patterns...)
+// or Enum.Variant.
+func (p *Parser) parseVariantPattern() *ast.VariantPattern {
+	pat := &ast.VariantPattern{Token: p.curr}
+	defer p.tracer.Trace("parseVariantPattern")(pat)
+	pat.Name = p.parseIdentifier().(*ast.Identifier)
+	if p.nextIsType(token.DOT) {
+		p.advance()
+		if !p.nextIsType(token.IDENT) {
+			p.errorf("Parse(): expected a variant after %s. but got %v at %s", pat.Name, p.next.Type, p.next.Pos)
+			return nil
+		}
+		p.advance()
+		pat.Enum, pat.Name = pat.Name, p.parseIdentifier().(*ast.Identifier)
+		if !p.nextIsType(token.POPEN) {
+			return pat
+		}
+	}
+	p.advance()
+	p.advance()
+	pat.Fields = []ast.Pattern{}
+	for !p.currIsType(token.PCLOSE) {
+		if p.currIsType(token.EOF) {
+			p.errEOF()
+			return nil
+		}
+		field := p.parsePattern()
+		if field == nil {
+			return nil
+		}
+		pat.Fields = append(pat.Fields, field)
+		if !p.parseElementEnd(token.PCLOSE) {
+			return nil
+		}
+	}
+	return pat
+}
+
+func (p *Parser) parseArrayPattern() *ast.ArrayPattern {
+	pat := &ast.ArrayPattern{Token: p.curr}
+	defer p.tracer.Trace("parseArrayPattern")(pat)
+	p.advance()
+	for !p.currIsType(token.SCLOSE) {
+		if p.currIsType(token.EOF) {
+			p.errEOF()
+			return nil
+		}
+		if pat.Rest != nil {
+			p.errorf("rest element must be last")
+			return nil
+		}
+		if p.currIsType(token.ELLIPSIS) {
+			p.advance()
+			rest, ok := p.parseIdentifier().(*ast.Identifier)
+			if !ok {
+				return nil
+			}
+			pat.Rest = rest
+		} else {
+			elem, ok := p.parsePatternElement()
+			if !ok {
+				return nil
+			}
+			pat.Elems = append(pat.Elems, elem)
+		}
+		if !p.parseElementEnd(token.SCLOSE) {
+			return nil
+		}
+	}
+	return pat
+}
+
+func (p *Parser) parseHashPattern() *ast.HashPattern {
+	pat := &ast.HashPattern{Token: p.curr}
+	defer p.tracer.Trace("parseHashPattern")(pat)
+	p.advance()
+	for !p.currIsType(token.RBRACK) {
+		if p.currIsType(token.EOF) {
+			p.errEOF()
+			return nil
+		}
+		var pair ast.HashPatternPair
+		if pair.Key = p.parseExpression(LOWEST); pair.Key == nil {
+			return nil
+		}
+		if !p.nextIsType(token.COLON) {
+			p.errExpected(token.COLON)
+			return nil
+		}
+		p.advance()
+		p.advance()
+		var ok bool
+		if pair.PatternElement, ok = p.parsePatternElement(); !ok {
+			return nil
+		}
+		pat.Pairs = append(pat.Pairs, pair)
+		if !p.parseElementEnd(token.RBRACK) {
+			return nil
+		}
+	}
+	return pat
+}
+
+// parseMatchExpression parses match value { pattern if guard => body, ... }
+func (p *Parser) parseMatchExpression() ast.Expression {
+	out := &ast.MatchExpression{Token: p.curr}
+	defer p.tracer.Trace("parseMatchExpression")(out)
+	p.advance()
+	if out.Value = p.parseExpression(LOWEST); out.Value == nil {
+		return nil
+	}
+	if !p.nextIsType(token.LBRACK) {
+		p.errorf("Parse(): expected { after the value of match but got %v at %s", p.next.Type, p.next.Pos)
+		return nil
+	}
+	p.advance()
+	p.advance()
+	defer p.inBrackets()()
+	for !p.currIsType(token.RBRACK) {
+		if p.currIsType(token.EOF) {
+			p.errorf("Parse(): unterminated match expression starting at %s", out.Pos)
+			return nil
+		}
+		var arm ast.MatchArm
+		if arm.Pattern = p.parsePattern(); arm.Pattern == nil {
+			return nil
+		}
+		if p.nextIsType(token.IF) {
+			p.advance()
+			p.advance()
+			p.guard = true
+			arm.Guard = p.parseExpression(LOWEST)
+			p.guard = false
+			if arm.Guard == nil {
+				return nil
+			}
+		}
+		if !p.nextIsType(token.ARROW) {
+			p.errorf("Parse(): expected => after the pattern %s but got %v at %s", arm.Pattern, p.next.Type, p.next.Pos)
+			return nil
+		}
+		p.advance()
+		p.advance()
+		if arm.Body = p.parseExpression(LOWEST); arm.Body == nil {
+			return nil
+		}
+		out.Arms = append(out.Arms, arm)
+		if !p.parseElementEnd(token.RBRACK) {
+			return nil
+		}
+	}
+	if len(out.Arms) == 0 {
+		p.errorf("Parse(): match expression at %s has no arms", out.Pos)
+		return nil
+	}
+	return out
+}
+
 func (p *Parser) parseGroupExpression() ast.Expression {
 	defer p.tracer.Trace("parseGroupExpression")(nil)
+	if !p.guard && p.atArrowFunction() {
+		return p.parseArrowFunction()
+	}
+	defer p.inBrackets()()
 	p.advance()
 	exp := p.parseExpression(LOWEST)
 	if p.next.Type != token.PCLOSE {
@@ -187,9 +446,65 @@ func (p *Parser) parseGroupExpression() ast.Expression {
 	return exp
 }
 
+// inBrackets clears the guard flag until the function it returns is called:
+// inside brackets, a => cannot be the one that ends the guard.
+func (p *Parser) inBrackets() (restore func()) {
+	guard := p.guard
+	p.guard = false
+	return func() { p.guard = guard }
+}
+
+// atArrowFunction reports whether the ( at p.curr starts the parameters of an
+// arrow function, that is, whether its closing ) is followed by =>.
+func (p *Parser) atArrowFunction() bool {
+	l := *p.l
+	depth := 1
+	for tok := p.next; tok.Type != token.EOF; tok = l.Next() {
+		switch tok.Type {
+		case token.POPEN:
+			depth++
+		case token.PCLOSE:
+			if depth--; depth == 0 {
+				return l.Next().Type == token.ARROW
+			}
+		}
+	}
+	return false
+}
+
+// parseArrowFunction parses (params) => body, which is short for
+// fn(params) { body }. A body in braces is a block, so a hash literal must be
+// put in parentheses to be returned: (x) => ({"x": x}).
+func (p *Parser) parseArrowFunction() ast.Expression {
+	out := ast.FunctionLiteral{Token: token.Token{Type: token.FUNC, Literal: "fn", Span: p.curr.Span, Pos: p.curr.Pos}}
+	defer p.tracer.Trace("parseArrowFunction")(&out)
+	if !p.parseParamList(&out) {
+		return nil
+	}
+	p.advance()
+	arrow := p.curr
+	p.advance()
+	if p.currIsType(token.LBRACK) {
+		if out.Body = p.parseBlockStatement(); out.Body == nil {
+			return nil
+		}
+		return &out
+	}
+	body := p.parseExpression(LOWEST)
+	if body == nil {
+		return nil
+	}
+	out.Body = &ast.BlockStatement{
+		Token:      arrow,
+		Statements: []ast.Statement{&ast.ExpressionStatement{Token: arrow, Expr: body}},
+	}
+	return &out
+}
+
 func (p *Parser) parseArray() ast.Expression {
 	arr := &ast.Array{Token: p.curr}
 	defer p.tracer.Trace("parseArray")(arr)
+	defer p.inBrackets()()
 	p.advance()
 	for {
 		switch p.curr.Type {
@@ -198,8 +513,74 @@ func (p *Parser) parseArray() ast.Expression {
 		case token.SCLOSE:
 			return arr
 		default:
-			arr.Elems = append(arr.Elems, p.parseExpression(LOWEST))
+			elem := p.parseExpression(LOWEST)
+			if elem != nil && len(arr.Elems) == 0 && p.nextIsType(token.FOR) {
+				out := &ast.ArrayComprehension{Token: arr.Token, Value: elem}
+				if out.Clauses = p.parseComprehensionClauses(token.SCLOSE); out.Clauses == nil {
+					return nil
+				}
+				return out
+			}
+			arr.Elems = append(arr.Elems, elem)
+			p.advance()
+		}
+	}
+}
+
+// parseComprehensionClauses parses the clauses of a comprehension that
+// follow its value, up to and including end.
+func (p *Parser) parseComprehensionClauses(end token.Type) []ast.ComprehensionClause {
+	var clauses []ast.ComprehensionClause
+	for !p.nextIsType(end) {
+		p.advance()
+		clause := ast.ComprehensionClause{Token: p.curr}
+		switch p.curr.Type {
+		case token.FOR:
 			p.advance()
+			if clause.Target = p.parseComprehensionTarget(); clause.Target == nil {
+				return nil
+			}
+			if !p.nextIsType(token.IDENT) || p.next.Literal != "in" {
+				p.errorf("Parse(): expected in after for %s but got %v at %s", clause.Target, p.next.Type, p.next.Pos)
+				return nil
+			}
+			p.advance()
+			p.advance()
+			if clause.Iterable = p.parseExpression(LOWEST); clause.Iterable == nil {
+				return nil
+			}
+		case token.IF:
+			p.advance()
+			if clause.Cond = p.parseExpression(LOWEST); clause.Cond == nil {
+				return nil
+			}
+		default:
+			p.errorf("Parse(): expected for, if or %s in comprehension but got %v at %s", end, p.curr.Type, p.curr.Pos)
+			return nil
+		}
+		clauses = append(clauses, clause)
+	}
+	p.advance()
+	return clauses
+}
+
+// parseComprehensionTarget parses what a for clause binds: a pattern, or
+// names separated by commas, which destructure each value like an array.
+func (p *Parser) parseComprehensionTarget() ast.Pattern {
+	if !p.currIsType(token.IDENT) || !p.nextIsType(token.COMMA) {
+		return p.parsePattern()
+	}
+	pat := &ast.ArrayPattern{Token: p.curr}
+	for {
+		pat.Elems = append(pat.Elems, ast.PatternElement{Target: p.parseIdentifier().(*ast.Identifier)})
+		if !p.nextIsType(token.COMMA) {
+			return pat
+		}
+		p.advance()
+		p.advance()
+		if !p.currIsType(token.IDENT) {
+			p.errorf("Parse(): expected a name after , in for but got %v at %s", p.curr.Type, p.curr.Pos)
+			return nil
 		}
 	}
 }
@@ -225,14 +606,81 @@ func (p *Parser) parseStatement() ast.Statement {
 	var out ast.Statement
 	defer p.tracer.Trace("parseStatement")(out)
 	switch p.curr.Type {
-	case token.LET:
+	case token.LET, token.CONST:
 		out = p.parseLetStatement(LOWEST)
+	case token.EXPORT:
+		if exp := p.parseExportStatement(); exp != nil {
+			out = exp
+		}
+	case token.FUNC, token.GENERATOR:
+		if !p.nextIsType(token.IDENT) {
+			// an anonymous function, which may be called right away
+			out = p.parseExpressionStatement()
+			break
+		}
+		if decl := p.parseFunctionDeclaration(); decl != nil {
+			out = decl
+		}
+	case token.STRUCT:
+		if decl := p.parseStructDeclaration(); decl != nil {
+			out = decl
+		}
+	case token.ENUM:
+		if decl := p.parseEnumDeclaration(); decl != nil {
+			out = decl
+		}
 	default:
 		out = p.parseExpressionStatement()
 	}
 	return out
 }
 
+func (p *Parser) parseExportStatement() *ast.ExportStatement {
+	stmt := &ast.ExportStatement{Token: p.curr}
+	defer p.tracer.Trace("parseExportStatement")(stmt)
+	p.advance()
+	switch {
+	case p.currIsType(token.LET, token.CONST):
+		if let := p.parseLetStatement(LOWEST); let != nil {
+			stmt.Stmt = let
+		}
+	case p.currIsType(token.FUNC, token.GENERATOR) && p.nextIsType(token.IDENT):
+		if decl := p.parseFunctionDeclaration(); decl != nil {
+			stmt.Stmt = decl
+		}
+	case p.currIsType(token.STRUCT):
+		if decl := p.parseStructDeclaration(); decl != nil {
+			stmt.Stmt = decl
+		}
+	case p.currIsType(token.ENUM):
+		if decl := p.parseEnumDeclaration(); decl != nil {
+			stmt.Stmt = decl
+		}
+	default:
+		p.errorf("Parse(): expected let or a function, struct or enum declaration after export at %s", p.curr.Pos)
+	}
+	if stmt.Stmt == nil {
+		return nil
+	}
+	return stmt
+}
+
+func (p *Parser) parseImportExpression() ast.Expression {
+	out := &ast.ImportExpression{Token: p.curr}
+	defer p.tracer.Trace("parseImportExpression")(out)
+	if !p.nextIsType(token.STRING) {
+		p.errorf("Parse(): expected a module path after import at %s", p.next.Pos)
+		return nil
+	}
+	p.advance()
+	path, ok := p.parseString().(*ast.String)
+	if !ok {
+		return nil
+	}
+	out.Path = path
+	return out
+}
+
 func (p *Parser) parsePrefixExpression() ast.Expression {
 	exp := ast.PrefixExpression{Token: p.curr, Op: p.curr.Literal}
 	defer p.tracer.Trace("parsePrefixExpression")(&exp)
@@ -307,6 +755,7 @@ func (p *Parser) parseIfExpression() ast.Expression {
 func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 	out := ast.BlockStatement{Statements: []ast.Statement{}}
 	defer p.tracer.Trace("parseBlockStatement")(&out)
+	defer p.inBrackets()()
 	out.Token = p.curr
 	if p.curr.Type != token.LBRACK {
 		p.errExpected(token.LBRACK)
@@ -334,7 +783,7 @@ func (p *Parser) parseBoolean() ast.Expression {
 		p.errExpected(token.FALSE, token.TRUE)
 		return nil
 	}
-	out.Type = p.curr.Type
+	out.Token = p.curr
 	out.Value = p.curr.Literal == "true"
 	return &out
 }
@@ -370,39 +819,71 @@ func (p *Parser) parseString() ast.Expression {
 	return &out
 }
 
-func (p *Parser) parseParamList() []ast.Identifier {
-	out := []ast.Identifier{}
+// parseParamList parses the parameters of fn, which may have a default
+// value, and may end with a rest parameter: (a, b = 2, ...rest)
+func (p *Parser) parseParamList(fn *ast.FunctionLiteral) bool {
+	fn.Params = []ast.Identifier{}
 	if p.curr.Type != token.POPEN {
 		p.errExpected(token.POPEN)
-		return nil
+		return false
 	}
 	p.advance()
 
 	for {
 		switch p.curr.Type {
 		case token.IDENT:
-			out = append(out, *p.parseIdentifier().(*ast.Identifier))
+			if fn.Rest != nil {
+				p.errorf("rest parameter must be last")
+				return false
+			}
+			fn.Params = append(fn.Params, *p.parseIdentifier().(*ast.Identifier))
+			var def ast.Expression
+			if p.nextIsType(token.ASSIGN) {
+				p.advance()
+				p.advance()
+				if def = p.parseExpression(LOWEST); def == nil {
+					return false
+				}
+				if fn.Defaults == nil {
+					fn.Defaults = make([]ast.Expression, len(fn.Params)-1)
+				}
+			}
+			if fn.Defaults != nil {
+				fn.Defaults = append(fn.Defaults, def)
+			}
+			p.advance()
+		case token.ELLIPSIS:
+			if fn.Rest != nil {
+				p.errorf("rest parameter must be last")
+				return false
+			}
+			p.advance()
+			rest, ok := p.parseIdentifier().(*ast.Identifier)
+			if !ok {
+				return false
+			}
+			fn.Rest = rest
 			p.advance()
 		case token.COMMA:
 			p.advance()
 		case token.PCLOSE:
-			return out
+			return true
 		default:
 			p.errExpected(token.IDENT, token.COMMA)
-			return nil
+			return false
 		}
 	}
 }
 
 func (p *Parser) parseFunctionLiteral() ast.Expression {
-	out := ast.FunctionLiteral{Token: p.curr}
+	out := ast.FunctionLiteral{Token: p.curr, Generator: p.currIsType(token.GENERATOR)}
 	defer p.tracer.Trace("parseFunctionLiteral")(&out)
-	if p.curr.Type != token.FUNC {
+	if !p.currIsType(token.FUNC, token.GENERATOR) {
 		p.errExpected(token.FUNC)
 		return nil
 	}
 	p.advance()
-	if out.Params = p.parseParamList(); out.Params == nil {
+	if !p.parseParamList(&out) {
 		return nil
 	}
 	p.advance()
@@ -411,6 +892,198 @@ func (p *Parser) parseFunctionLiteral() ast.Expression {
 	}
 	return &out
 }
+
+// parseStructDeclaration parses struct Name { field, ..., fn method() { ... } }.
+// Fields are separated by commas; the comma after a method is optional. Like
+// a let statement, it leaves off at the start of the next statement.
+func (p *Parser) parseStructDeclaration() *ast.StructDeclaration {
+	stmt := &ast.StructDeclaration{Token: p.curr}
+	defer p.tracer.Trace("parseStructDeclaration")(stmt)
+	p.advance()
+	if !p.currIsType(token.IDENT) {
+		p.errorf("Parse(): expected the name of the struct but got %v at %s", p.curr.Type, p.curr.Pos)
+		return nil
+	}
+	stmt.Name = p.parseIdentifier().(*ast.Identifier)
+	p.advance()
+	if !p.currIsType(token.LBRACK) {
+		p.errorf("Parse(): expected { after struct %s but got %v at %s", stmt.Name, p.curr.Type, p.curr.Pos)
+		return nil
+	}
+	p.advance()
+	seen := make(map[string]bool)
+	member := func(id *ast.Identifier) bool {
+		if seen[id.Value] {
+			p.errorf("Parse(): struct %s has more than one member named %s at %s", stmt.Name, id.Value, id.Pos)
+			return false
+		}
+		seen[id.Value] = true
+		return true
+	}
+	for !p.currIsType(token.RBRACK) {
+		switch p.curr.Type {
+		case token.EOF:
+			p.errEOF()
+			return nil
+		case token.IDENT:
+			field := p.parseIdentifier().(*ast.Identifier)
+			if !member(field) {
+				return nil
+			}
+			stmt.Fields = append(stmt.Fields, field)
+			if !p.parseElementEnd(token.RBRACK) {
+				return nil
+			}
+		case token.FUNC, token.GENERATOR:
+			if !p.nextIsType(token.IDENT) {
+				p.errorf("Parse(): expected the name of the method but got %v at %s", p.next.Type, p.next.Pos)
+				return nil
+			}
+			method := p.parseFunctionDeclaration()
+			if method == nil || !member(method.Name) {
+				return nil
+			}
+			method.Fn.Receiver = &ast.Identifier{
+				Token: token.Token{Type: token.IDENT, Literal: "self", Span: method.Span, Pos: method.Pos},
+				Value: "self",
+			}
+			stmt.Methods = append(stmt.Methods, method)
+			// the declaration leaves off after the method
+			if p.currIsType(token.COMMA) {
+				p.advance()
+			}
+		default:
+			p.errorf("Parse(): expected a field or a method in struct %s but got %v at %s", stmt.Name, p.curr.Type, p.curr.Pos)
+			return nil
+		}
+	}
+	p.advance()
+	if p.currIsType(token.SEMICOLON) {
+		p.advance()
+	}
+	return stmt
+}
+
+// parseEnumDeclaration parses enum Name { Variant(field, ...), Variant, ... }.
+// Like a let statement, it leaves off at the start of the next statement.
+func (p *Parser) parseEnumDeclaration() *ast.EnumDeclaration {
+	stmt := &ast.EnumDeclaration{Token: p.curr}
+	defer p.tracer.Trace("parseEnumDeclaration")(stmt)
+	p.advance()
+	if !p.currIsType(token.IDENT) {
+		p.errorf("Parse(): expected the name of the enum but got %v at %s", p.curr.Type, p.curr.Pos)
+		return nil
+	}
+	stmt.Name = p.parseIdentifier().(*ast.Identifier)
+	p.advance()
+	if !p.currIsType(token.LBRACK) {
+		p.errorf("Parse(): expected { after enum %s but got %v at %s", stmt.Name, p.curr.Type, p.curr.Pos)
+		return nil
+	}
+	p.advance()
+	variants := make(map[string]bool)
+	for !p.currIsType(token.RBRACK) {
+		if p.currIsType(token.EOF) {
+			p.errEOF()
+			return nil
+		}
+		if !p.currIsType(token.IDENT) {
+			p.errorf("Parse(): expected a variant in enum %s but got %v at %s", stmt.Name, p.curr.Type, p.curr.Pos)
+			return nil
+		}
+		v := &ast.EnumVariant{Name: p.parseIdentifier().(*ast.Identifier)}
+		if variants[v.Name.Value] {
+			p.errorf("Parse(): enum %s has more than one variant named %s at %s", stmt.Name, v.Name, v.Name.Pos)
+			return nil
+		}
+		variants[v.Name.Value] = true
+		if p.nextIsType(token.POPEN) {
+			p.advance()
+			p.advance()
+			fields := make(map[string]bool)
+			for !p.currIsType(token.PCLOSE) {
+				if p.currIsType(token.EOF) {
+					p.errEOF()
+					return nil
+				}
+				if !p.currIsType(token.IDENT) {
+					p.errorf("Parse(): expected a field of %s but got %v at %s", v.Name, p.curr.Type, p.curr.Pos)
+					return nil
+				}
+				field := p.parseIdentifier().(*ast.Identifier)
+				if fields[field.Value] {
+					p.errorf("Parse(): variant %s has more than one field named %s at %s", v.Name, field, field.Pos)
+					return nil
+				}
+				fields[field.Value] = true
+				v.Fields = append(v.Fields, field)
+				if !p.parseElementEnd(token.PCLOSE) {
+					return nil
+				}
+			}
+		}
+		stmt.Variants = append(stmt.Variants, v)
+		if !p.parseElementEnd(token.RBRACK) {
+			return nil
+		}
+	}
+	if len(stmt.Variants) == 0 {
+		p.errorf("Parse(): enum %s has no variants at %s", stmt.Name, stmt.Pos)
+		return nil
+	}
+	p.advance()
+	if p.currIsType(token.SEMICOLON) {
+		p.advance()
+	}
+	return stmt
+}
+
+// parseMacroLiteral parses macro(params) { ... }. The parameters of a macro
+// are bound to quoted code, so they cannot have defaults.
+func (p *Parser) parseMacroLiteral() ast.Expression {
+	out := ast.MacroLiteral{Token: p.curr}
+	defer p.tracer.Trace("parseMacroLiteral")(&out)
+	p.advance()
+	var fn ast.FunctionLiteral
+	if !p.parseParamList(&fn) {
+		return nil
+	}
+	if fn.Defaults != nil || fn.Rest != nil {
+		p.errorf("Parse(): macro parameters cannot have defaults or be a rest parameter at %s", out.Pos)
+		return nil
+	}
+	out.Params = fn.Params
+	p.advance()
+	if out.Body = p.parseBlockStatement(); out.Body == nil {
+		return nil
+	}
+	return &out
+}
+
+// parseFunctionDeclaration parses fn name(params) { ... }. Like a let
+// statement, it leaves off at the start of the next statement.
+func (p *Parser) parseFunctionDeclaration() *ast.FunctionDeclaration {
+	stmt := &ast.FunctionDeclaration{Token: p.curr}
+	defer p.tracer.Trace("parseFunctionDeclaration")(stmt)
+	p.advance()
+	stmt.Name = p.parseIdentifier().(*ast.Identifier)
+	fn := &ast.FunctionLiteral{Token: stmt.Token, Name: stmt.Name.Value, Generator: stmt.Token.Type == token.GENERATOR}
+	p.advance()
+	if !p.parseParamList(fn) {
+		return nil
+	}
+	p.advance()
+	if fn.Body = p.parseBlockStatement(); fn.Body == nil {
+		return nil
+	}
+	stmt.Fn = fn
+	p.advance()
+	if p.currIsType(token.SEMICOLON) {
+		p.advance()
+	}
+	return stmt
+}
+
 func (p *Parser) parseReturnExpression() ast.Expression {
 	out := &ast.ReturnExpression{Token: p.curr}
 	defer p.tracer.Trace("parseReturnExpression")(out)
@@ -421,19 +1094,83 @@ func (p *Parser) parseReturnExpression() ast.Expression {
 	return out
 }
 
+func (p *Parser) parseThrowExpression() ast.Expression {
+	out := &ast.ThrowExpression{Token: p.curr}
+	defer p.tracer.Trace("parseThrowExpression")(out)
+	p.advance()
+	if out.Value = p.parseExpression(LOWEST); out.Value == nil {
+		return nil
+	}
+	return out
+}
+
+func (p *Parser) parseTryExpression() ast.Expression {
+	out := &ast.TryExpression{Token: p.curr}
+	defer p.tracer.Trace("parseTryExpression")(out)
+	p.advance()
+	if out.Block = p.parseBlockStatement(); out.Block == nil {
+		return nil
+	}
+	if p.next.Type == token.CATCH {
+		p.advance()
+		p.advance()
+		if p.curr.Type == token.POPEN {
+			p.advance()
+			param := p.parseIdentifier()
+			if param == nil {
+				return nil
+			}
+			out.Param = param.(*ast.Identifier)
+			p.advance()
+			if _, ok := p.parseToken(token.PCLOSE); !ok {
+				return nil
+			}
+		}
+		if out.Catch = p.parseBlockStatement(); out.Catch == nil {
+			return nil
+		}
+	}
+	if p.next.Type == token.FINALLY {
+		p.advance()
+		p.advance()
+		if out.Finally = p.parseBlockStatement(); out.Finally == nil {
+			return nil
+		}
+	}
+	if out.Catch == nil && out.Finally == nil {
+		p.advance()
+		p.errExpected(token.CATCH, token.FINALLY)
+		return nil
+	}
+	return out
+}
+
 func (p *Parser) parseCallArguments() []ast.Expression {
+	defer p.inBrackets()()
 	args := []ast.Expression{}
 	if p.next.Type == token.PCLOSE {
 		p.advance()
 		return args
 	}
 	p.advance()
-	args = append(args, p.parseExpression(LOWEST))
+	args = append(args, p.parseCallArgument())
 
 	for p.next.Type == token.COMMA {
 		p.advance()
 		p.advance()
-		args = append(args, p.parseExpression(LOWEST))
+		args = append(args, p.parseCallArgument())
+	}
+	keyword := false
+	for _, arg := range args {
+		if arg == nil {
+			return nil
+		}
+		_, ok := arg.(*ast.KeywordArgument)
+		if keyword && !ok {
+			p.errorf("positional argument %s follows keyword argument", arg)
+			return nil
+		}
+		keyword = keyword || ok
 	}
 
 	if p.next.Type != token.PCLOSE {
@@ -444,13 +1181,40 @@ func (p *Parser) parseCallArguments() []ast.Expression {
 	return args
 }
 
+// parseCallArgument parses an argument, which is passed by name if it is of
+// the form name: value
+func (p *Parser) parseCallArgument() ast.Expression {
+	if !p.currIsType(token.IDENT) || !p.nextIsType(token.COLON) {
+		return p.parseExpression(LOWEST)
+	}
+	arg := &ast.KeywordArgument{Token: p.curr}
+	defer p.tracer.Trace("parseCallArgument")(arg)
+	arg.Name = p.parseIdentifier().(*ast.Identifier)
+	p.advance()
+	p.advance()
+	if arg.Value = p.parseExpression(LOWEST); arg.Value == nil {
+		return nil
+	}
+	return arg
+}
+
+func (p *Parser) parseSpread() ast.Expression {
+	out := &ast.Spread{Token: p.curr}
+	defer p.tracer.Trace("parseSpread")(out)
+	p.advance()
+	if out.Value = p.parseExpression(LOWEST); out.Value == nil {
+		return nil
+	}
+	return out
+}
+
 func (p *Parser) parseCallExpression(precedence int, left ast.Expression) ast.Expression {
-	out := &ast.CallExpression{}
+	out := &ast.CallExpression{Token: p.curr}
 	defer p.tracer.Trace("parseCallExpression")(out)
 	out.Function = left
 	out.Params = p.parseCallArguments()
 	if out.Params == nil {
-		panic("params nil")
+		return nil
 	}
 	return out
 }
@@ -474,6 +1238,7 @@ func (p *Parser) parseArrayIndexExpression(_ int, left ast.Expression) ast.Expre
 func (p *Parser) parseHashLiteral() ast.Expression {
 	hash := &ast.HashLiteral{Token: p.curr, Pairs: make(map[ast.Expression]ast.Expression)}
 	defer p.tracer.Trace("parseHashLiteral")(hash)
+	defer p.inBrackets()()
 	p.advance()
 	// while not } and eof ... we consume
 	for tp := p.curr.Type; tp != token.RBRACK; tp = p.curr.Type {
@@ -490,6 +1255,13 @@ func (p *Parser) parseHashLiteral() ast.Expression {
 		if value == nil {
 			return nil
 		}
+		if len(hash.Pairs) == 0 && p.nextIsType(token.FOR) {
+			out := &ast.HashComprehension{Token: hash.Token, Key: key, Value: value}
+			if out.Clauses = p.parseComprehensionClauses(token.RBRACK); out.Clauses == nil {
+				return nil
+			}
+			return out
+		}
 		hash.Pairs[key] = value
 		if p.next.Type == token.COMMA {
 			p.advance()
@@ -500,9 +1272,77 @@ func (p *Parser) parseHashLiteral() ast.Expression {
 	return hash
 }
 
+func (p *Parser) parseFieldExpression(_ int, left ast.Expression) ast.Expression {
+	out := &ast.FieldExpression{Token: p.curr, Value: left}
+	defer p.tracer.Trace("parseFieldExpression")(out)
+	if !p.nextIsType(token.IDENT) {
+		p.errorf("Parse(): expected a field name after . but got %v at %s", p.next.Type, p.next.Pos)
+		return nil
+	}
+	p.advance()
+	out.Field = p.parseIdentifier().(*ast.Identifier)
+	return out
+}
+
+// parsePipeExpression parses x |> f(y), which is the call f(x, y). Anything
+// other than a call on the right is called with x alone: x |> f is f(x).
+func (p *Parser) parsePipeExpression(precedence int, left ast.Expression) ast.Expression {
+	tok := p.curr
+	defer p.tracer.Trace("parsePipeExpression")(nil)
+	p.advance()
+	rhs := p.parseExpression(precedence)
+	if rhs == nil {
+		return nil
+	}
+	if call, ok := rhs.(*ast.CallExpression); ok {
+		call.Params = append([]ast.Expression{left}, call.Params...)
+		return call
+	}
+	return &ast.CallExpression{Token: tok, Function: rhs, Params: []ast.Expression{left}}
+}
+
+// parseOptionalExpression parses ?. followed by an index, the arguments of a
+// call or a field name.
+func (p *Parser) parseOptionalExpression(precedence int, left ast.Expression) ast.Expression {
+	switch {
+	case p.nextIsType(token.SOPEN):
+		p.advance()
+		if out, ok := p.parseArrayIndexExpression(precedence, left).(*ast.ArrayIndex); ok {
+			out.Optional = true
+			return out
+		}
+	case p.nextIsType(token.POPEN):
+		p.advance()
+		if out, ok := p.parseCallExpression(precedence, left).(*ast.CallExpression); ok {
+			out.Optional = true
+			return out
+		}
+	case p.nextIsType(token.IDENT):
+		if out, ok := p.parseFieldExpression(precedence, left).(*ast.FieldExpression); ok {
+			out.Optional = true
+			return out
+		}
+	default:
+		p.errorf("Parse(): expected [, ( or a field name after ?. but got %v at %s", p.next.Type, p.next.Pos)
+	}
+	return nil
+}
+
 func (p *Parser) parseAssignExpression(_ int, left ast.Expression) ast.Expression {
 	aexpr := &ast.AssignExpression{Token: p.curr, Lhs: left}
 	defer p.tracer.Trace("parseAssignExpression")(aexpr)
+	switch lhs := left.(type) {
+	case *ast.ArrayIndex:
+		if lhs.Optional {
+			p.errorf("Parse(): cannot assign to %s at %s", lhs, lhs.Pos)
+			return nil
+		}
+	case *ast.FieldExpression:
+		if lhs.Optional {
+			p.errorf("Parse(): cannot assign to %s at %s", lhs, lhs.Pos)
+			return nil
+		}
+	}
 	p.advance()
 
 	if aexpr.Rhs = p.parseExpression(LOWEST); aexpr.Rhs == nil {
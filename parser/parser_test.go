@@ -337,6 +337,7 @@ func TestFunctionLiteral(t *testing.T) {
 		{"fn () { 2 }", "fn() {2}"},
 		{"fn (x) { x + 2 }", "fn(x) {(x + 2)}"},
 		{"fn (x, y) { x + y }", "fn(x, y) {(x + y)}"},
+		{"fn*(x) { yield(x) }", "fn*(x) {yield([x])}"},
 	}
 	for _, tc := range tests {
 		t.Run(tc.input, func(t *testing.T) {
@@ -418,6 +419,7 @@ func TestLetStatement(t *testing.T) {
 	}{
 		{"let x = 5", "let x = 5"},
 		{"let y = true", "let y = true"},
+		{"const z = 1 + 2", "const z = (1 + 2)"},
 	}
 	for _, tc := range cases {
 		t.Run(tc.input, func(t *testing.T) {
@@ -476,3 +478,502 @@ func TestIfExpression(t *testing.T) {
 		expectLiteral(t, rhs.Expr, 3)
 	}
 }
+
+func TestTryExpression(t *testing.T) {
+	cases := []struct {
+		input string
+		want  string
+	}{
+		{"try { f() } catch (e) { 1 }", "try {f([])} catch (e) {1}"},
+		{"try { f() } catch { 1 }", "try {f([])} catch {1}"},
+		{"try { f() } finally { g() }", "try {f([])} finally {g([])}"},
+		{"try { 1 } catch (e) { 2 } finally { 3 }", "try {1} catch (e) {2} finally {3}"},
+		{`throw "boom"`, `throw "boom"`},
+		{`let x = try { throw 1 + 2 } catch (e) { e }; x`, `let x = try {throw (1 + 2)} catch (e) {e}x`},
+	}
+	for _, tc := range cases {
+		t.Run(tc.input, func(t *testing.T) {
+			prog, errs := parser.New(tc.input).Parse()
+			if len(errs) > 0 {
+				t.Fatalf("got %d errors: %+v", len(errs), errs)
+			}
+			if got := prog.String(); got != tc.want {
+				t.Fatalf("mismatch: got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestTryExpressionErrors(t *testing.T) {
+	for _, input := range []string{
+		"try { 1 }",
+		"try { 1 } catch (1) { 2 }",
+		"try { 1 } catch (e { 2 }",
+	} {
+		t.Run(input, func(t *testing.T) {
+			if _, errs := parser.New(input).Parse(); len(errs) == 0 {
+				t.Fatalf("expected a parse error")
+			}
+		})
+	}
+}
+
+func TestFunctionDeclaration(t *testing.T) {
+	tests := []struct{ input, expected string }{
+		{"fn f() { 2 }", "fn f() {2}"},
+		{"fn add(x, y) { x + y }; add(1, 2)", "fn add(x, y) {(x + y)}"},
+		{"fn* count() { yield(1) }", "fn* count() {yield([1])}"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.input, func(t *testing.T) {
+			prog, errs := parser.New(tc.input).Parse()
+			if len(errs) > 0 {
+				t.Fatalf("got errors %v", errs)
+			}
+			decl, ok := prog.Statements[0].(*ast.FunctionDeclaration)
+			if !ok {
+				t.Fatalf("expected *FunctionDeclaration got %T", prog.Statements[0])
+			}
+			if decl.Name.Value != decl.Fn.Name {
+				t.Fatalf("name mismatch: %q and %q", decl.Name.Value, decl.Fn.Name)
+			}
+			if got := decl.String(); got != tc.expected {
+				t.Fatalf("string mismatch: expected %q, got %q", tc.expected, got)
+			}
+		})
+	}
+	// without a name, it's a function literal
+	prog, errs := parser.New("fn(x) { x }(1)").Parse()
+	if len(errs) > 0 {
+		t.Fatalf("got errors %v", errs)
+	}
+	if _, ok := prog.Statements[0].(*ast.ExpressionStatement); !ok {
+		t.Fatalf("expected *ExpressionStatement got %T", prog.Statements[0])
+	}
+	if _, errs := parser.New("fn f { 1 }").Parse(); len(errs) == 0 {
+		t.Fatalf("expected a parse error")
+	}
+}
+
+func TestParameters(t *testing.T) {
+	tests := []struct{ input, expected string }{
+		{"fn(a, b = 2) { a }", "fn(a, b = 2) {a}"},
+		{"fn(a = 1 + 1, b) { a }", "fn(a = (1 + 1), b) {a}"},
+		{"fn(a, ...rest) { rest }", "fn(a, ...rest) {rest}"},
+		{"fn(...rest) { rest }", "fn(...rest) {rest}"},
+		{"f(1, b: 2)", "f([1 b: 2])"},
+		{"f(...xs, 1)", "f([...xs 1])"},
+		{"[...a, ...b]", "[...a, ...b]"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.input, func(t *testing.T) {
+			prog, errs := parser.New(tc.input).Parse()
+			if len(errs) > 0 {
+				t.Fatalf("got errors %v", errs)
+			}
+			got := prog.Statements[0].(*ast.ExpressionStatement).Expr.String()
+			if got != tc.expected {
+				t.Fatalf("string mismatch: expected %q, got %q", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestParameterErrors(t *testing.T) {
+	for _, input := range []string{
+		"fn(...rest, a) { a }",
+		"fn(...a, ...b) { a }",
+		"fn(a = ) { a }",
+		"f(b: 2, 1)",
+		"f(b: )",
+	} {
+		t.Run(input, func(t *testing.T) {
+			if _, errs := parser.New(input).Parse(); len(errs) == 0 {
+				t.Fatalf("expected a parse error")
+			}
+		})
+	}
+}
+
+func TestDestructuringLet(t *testing.T) {
+	tests := []struct{ input, expected string }{
+		{"let [a, b] = xs", "let [a, b] = xs"},
+		{"let [a, b = 2, ...rest] = xs;", "let [a, b = 2, ...rest] = xs"},
+		{"let [a, [b, c]] = xs", "let [a, [b, c]] = xs"},
+		{`let {"name": n, "age": a = 1} = p`, `let {"name": n, "age": a = 1} = p`},
+		{`let {"xs": [x, y],} = p`, `let {"xs": [x, y]} = p`},
+		{"let [] = xs", "let [] = xs"},
+		{"const [a, ...rest] = xs", "const [a, ...rest] = xs"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.input, func(t *testing.T) {
+			prog, errs := parser.New(tc.input).Parse()
+			if len(errs) > 0 {
+				t.Fatalf("got errors %v", errs)
+			}
+			let, ok := prog.Statements[0].(*ast.LetStatement)
+			if !ok {
+				t.Fatalf("expected *LetStatement got %T", prog.Statements[0])
+			}
+			if let.Lhs != nil || let.Pattern == nil {
+				t.Fatalf("expected a pattern, got %v", let)
+			}
+			if got := let.String(); got != tc.expected {
+				t.Fatalf("string mismatch: expected %q, got %q", tc.expected, got)
+			}
+		})
+	}
+	for _, input := range []string{
+		"let [a, ...r, b] = xs",
+		"let [+] = xs",
+		"let {a} = p",
+		"let [a b] = xs",
+		"let [a, b = ] = xs",
+		"let [a",
+	} {
+		t.Run(input, func(t *testing.T) {
+			if _, errs := parser.New(input).Parse(); len(errs) == 0 {
+				t.Fatalf("expected a parse error")
+			}
+		})
+	}
+}
+
+func TestMatchExpression(t *testing.T) {
+	tests := []struct{ input, expected string }{
+		{"match x { 1 => 2 }", "match x {1 => 2}"},
+		{`match x { 1 => "one", -1 => "minus one", "a" => 3, true => 4, _ => 5, }`, `match x {1 => "one", (-1) => "minus one", "a" => 3, true => 4, _ => 5}`},
+		{"match x { [a, ...r] if a > 1 => r, n => n }", "match x {[a, ...r] if (a > 1) => r, n => n}"},
+		{`match p { {"name": n, "tags": [_, t]} => t }`, `match p {{"name": n, "tags": [_, t]} => t}`},
+		{"match x { 1 => match y { _ => 2 } }", "match x {1 => match y {_ => 2}}"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.input, func(t *testing.T) {
+			prog, errs := parser.New(tc.input).Parse()
+			if len(errs) > 0 {
+				t.Fatalf("got errors %v", errs)
+			}
+			got := prog.Statements[0].(*ast.ExpressionStatement).Expr.String()
+			if got != tc.expected {
+				t.Fatalf("string mismatch: expected %q, got %q", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestMatchExpressionErrors(t *testing.T) {
+	tests := []struct{ input, expected string }{
+		{"match x 1 => 2", "Parse(): expected { after the value of match but got INT at 1:9"},
+		{"match x { 1 2 }", "Parse(): expected => after the pattern 1 but got INT at 1:13"},
+		{"match x { 1 => 2", "Parse(): expected , or } but got EOF at 1:17"},
+		{"match x {", "Parse(): unterminated match expression starting at 1:1"},
+		{"match x { }", "Parse(): match expression at 1:1 has no arms"},
+		{"match x { + => 1 }", "Parse(): expected a pattern but got + at 1:11"},
+		{"match x { - a => 1 }", "Parse(): expected a number after - in pattern at 1:13"},
+		{"match x { 1 if => 2 }", ""},
+		{"match x { 1 => 2 3 => 4 }", ""},
+	}
+	for _, tc := range tests {
+		t.Run(tc.input, func(t *testing.T) {
+			_, errs := parser.New(tc.input).Parse()
+			if len(errs) == 0 {
+				t.Fatalf("expected a parse error")
+			}
+			if tc.expected != "" && errs[0].Error() != tc.expected {
+				t.Fatalf("expected %q, got %q", tc.expected, errs[0])
+			}
+		})
+	}
+}
+
+func TestModules(t *testing.T) {
+	tests := []struct{ input, expected string }{
+		{`export let x = 1;`, "export let x = 1"},
+		{`export let [a, b] = xs`, "export let [a, b] = xs"},
+		{`export const x = 1`, "export const x = 1"},
+		{`export fn f(x) { x }`, "export fn f(x) {x}"},
+		{`export struct P { x }`, "export struct P {x}"},
+		{`export enum E { A, B(x) }`, "export enum E {A, B(x)}"},
+		{`let m = import "./math"`, `let m = import "./math"`},
+		{`(import "./math")["pi"]`, `import "./math"["pi"]`},
+	}
+	for _, tc := range tests {
+		t.Run(tc.input, func(t *testing.T) {
+			prog, errs := parser.New(tc.input).Parse()
+			if len(errs) > 0 {
+				t.Fatalf("got errors %v", errs)
+			}
+			if got := prog.Statements[0].String(); got != tc.expected {
+				t.Fatalf("string mismatch: expected %q, got %q", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestModuleErrors(t *testing.T) {
+	tests := []struct{ input, expected string }{
+		{"export 1", "Parse(): expected let or a function, struct or enum declaration after export at 1:8"},
+		{"export fn() { 1 }", "Parse(): expected let or a function, struct or enum declaration after export at 1:8"},
+		{"import math", "Parse(): expected a module path after import at 1:8"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.input, func(t *testing.T) {
+			_, errs := parser.New(tc.input).Parse()
+			if len(errs) == 0 {
+				t.Fatalf("expected a parse error")
+			}
+			if errs[0].Error() != tc.expected {
+				t.Fatalf("expected %q, got %q", tc.expected, errs[0])
+			}
+		})
+	}
+}
+
+func TestMacroLiteral(t *testing.T) {
+	tests := []struct{ input, expected string }{
+		{"macro(x, y) { x + y }", "macro(x, y) {(x + y)}"},
+		{"let m = macro() { quote(1) };", "let m = macro() {quote([1])}"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.input, func(t *testing.T) {
+			prog, errs := parser.New(tc.input).Parse()
+			if len(errs) > 0 {
+				t.Fatalf("got errors %v", errs)
+			}
+			if got := prog.Statements[0].String(); got != tc.expected {
+				t.Fatalf("string mismatch: expected %q, got %q", tc.expected, got)
+			}
+		})
+	}
+	for _, input := range []string{"macro(x = 1) { x }", "macro(...xs) { xs }"} {
+		_, errs := parser.New(input).Parse()
+		expected := "Parse(): macro parameters cannot have defaults or be a rest parameter at 1:1"
+		if len(errs) == 0 || errs[0].Error() != expected {
+			t.Fatalf("%s: expected %q, got %v", input, expected, errs)
+		}
+	}
+}
+
+func TestStructDeclaration(t *testing.T) {
+	tests := []struct{ input, expected string }{
+		{"struct P { x, y }", "struct P {x, y}"},
+		{"struct P { x, y, }", "struct P {x, y}"},
+		{"struct E {}", "struct E {}"},
+		{"struct P { x, fn f(a) { self.x + a } fn g() { 1 }, }", "struct P {x, fn f(a) {(self.x + a)}, fn g() {1}}"},
+		{"struct P { x, fn* __iter() { yield(self.x) } }", "struct P {x, fn* __iter() {yield([self.x])}}"},
+		{"p.x", "p.x"},
+		{"-p.x.y", "(-p.x.y)"},
+		{"p.f(1).g", "p.f([1]).g"},
+		{"p.x = 1 + 2", "p.x=(1 + 2)"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.input, func(t *testing.T) {
+			prog, errs := parser.New(tc.input).Parse()
+			if len(errs) > 0 {
+				t.Fatalf("got errors %v", errs)
+			}
+			if got := prog.Statements[0].String(); got != tc.expected {
+				t.Fatalf("string mismatch: expected %q, got %q", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestEnumDeclaration(t *testing.T) {
+	tests := []struct{ input, expected string }{
+		{"enum Shape { Circle(r), Rect(w, h) }", "enum Shape {Circle(r), Rect(w, h)}"},
+		{"enum Option { Some(value), None, }", "enum Option {Some(value), None}"},
+		{"match s { Circle(r) => r, Shape.Rect(w, _) => w, None() => 0 }", "match s {Circle(r) => r, Shape.Rect(w, _) => w, None() => 0}"},
+		{"match s { Some([a, b]) => a, Option.None => 0 }", "match s {Some([a, b]) => a, Option.None => 0}"},
+		{"let Some(x) = y", "let Some(x) = y"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.input, func(t *testing.T) {
+			prog, errs := parser.New(tc.input).Parse()
+			if len(errs) > 0 {
+				t.Fatalf("got errors %v", errs)
+			}
+			if got := prog.Statements[0].String(); got != tc.expected {
+				t.Fatalf("string mismatch: expected %q, got %q", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestEnumDeclarationErrors(t *testing.T) {
+	tests := []struct{ input, expected string }{
+		{"enum { A }", "Parse(): expected the name of the enum but got { at 1:6"},
+		{"enum E A", "Parse(): expected { after enum E but got IDENT at 1:8"},
+		{"enum E { 1 }", "Parse(): expected a variant in enum E but got INT at 1:10"},
+		{"enum E { A, A(x) }", "Parse(): enum E has more than one variant named A at 1:13"},
+		{"enum E { A(1) }", "Parse(): expected a field of A but got INT at 1:12"},
+		{"enum E { A(x, x) }", "Parse(): variant A has more than one field named x at 1:15"},
+		{"enum E {}", "Parse(): enum E has no variants at 1:1"},
+		{"match x { E.1 => 1 }", "Parse(): expected a variant after E. but got INT at 1:13"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.input, func(t *testing.T) {
+			_, errs := parser.New(tc.input).Parse()
+			if len(errs) == 0 {
+				t.Fatalf("expected a parse error")
+			}
+			if errs[0].Error() != tc.expected {
+				t.Fatalf("expected %q, got %q", tc.expected, errs[0])
+			}
+		})
+	}
+}
+
+func TestStructDeclarationErrors(t *testing.T) {
+	tests := []struct{ input, expected string }{
+		{"struct { x }", "Parse(): expected the name of the struct but got { at 1:8"},
+		{"struct P x", "Parse(): expected { after struct P but got IDENT at 1:10"},
+		{"struct P { x, x }", "Parse(): struct P has more than one member named x at 1:15"},
+		{"struct P { x, fn x() { 1 } }", "Parse(): struct P has more than one member named x at 1:18"},
+		{"struct P { 1 }", "Parse(): expected a field or a method in struct P but got INT at 1:12"},
+		{"struct P { x y }", "Parse(): expected , or } but got IDENT at 1:14"},
+		{"struct P { fn() { 1 } }", "Parse(): expected the name of the method but got ( at 1:14"},
+		{"p.1", "Parse(): expected a field name after . but got INT at 1:3"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.input, func(t *testing.T) {
+			_, errs := parser.New(tc.input).Parse()
+			if len(errs) == 0 {
+				t.Fatalf("expected a parse error")
+			}
+			if errs[0].Error() != tc.expected {
+				t.Fatalf("expected %q, got %q", tc.expected, errs[0])
+			}
+		})
+	}
+}
+
+func TestNullSafeOperators(t *testing.T) {
+	tests := []struct{ input, expected string }{
+		{`h?.["a"]`, `h?.["a"]`},
+		{`h?.["a"]?.["b"]["c"]`, `h?.["a"]?.["b"]["c"]`},
+		{"f?.(1, 2)", "f?.([1 2])"},
+		{"p?.x.y", "p?.x.y"},
+		{"a ?? b", "(a ?? b)"},
+		{"a ?? b ?? c", "((a ?? b) ?? c)"},
+		{"a ?? b == c", "(a ?? (b == c))"},
+		{"a + b ?? c * d", "((a + b) ?? (c * d))"},
+		{`x = h?.["a"] ?? 1`, `x=(h?.["a"] ?? 1)`},
+	}
+	for _, tc := range tests {
+		t.Run(tc.input, func(t *testing.T) {
+			prog, errs := parser.New(tc.input).Parse()
+			if len(errs) > 0 {
+				t.Fatalf("got errors %v", errs)
+			}
+			if got := prog.Statements[0].String(); got != tc.expected {
+				t.Fatalf("string mismatch: expected %q, got %q", tc.expected, got)
+			}
+		})
+	}
+	errors := []struct{ input, expected string }{
+		{"h?.1", "Parse(): expected [, ( or a field name after ?. but got INT at 1:4"},
+		{`h?.["a"] = 1`, `Parse(): cannot assign to h?.["a"] at 1:4`},
+		{"p?.x = 1", "Parse(): cannot assign to p?.x at 1:2"},
+	}
+	for _, tc := range errors {
+		t.Run(tc.input, func(t *testing.T) {
+			_, errs := parser.New(tc.input).Parse()
+			if len(errs) == 0 {
+				t.Fatalf("expected a parse error")
+			}
+			if errs[0].Error() != tc.expected {
+				t.Fatalf("expected %q, got %q", tc.expected, errs[0])
+			}
+		})
+	}
+}
+
+func TestPipelineAndArrowFunctions(t *testing.T) {
+	tests := []struct{ input, expected string }{
+		{"xs |> map(f)", "map([xs f])"},
+		{"xs |> map(f) |> filter(g)", "filter([map([xs f]) g])"},
+		{"xs |> len", "len([xs])"},
+		{"a + 1 |> f(b * 2)", "f([(a + 1) (b * 2)])"},
+		{"x |> f == y", "(f([x]) == y)"},
+		{"x < y |> f", "(x < f([y]))"},
+		{"x ?? y |> f", "(x ?? f([y]))"},
+		{"(x) => x * 2", "fn(x) {(x * 2)}"},
+		{"() => 1", "fn() {1}"},
+		{"(a, b = 2, ...rest) => a + b", "fn(a, b = 2, ...rest) {(a + b)}"},
+		{"(x) => { let y = x; y }", "fn(x) {let y = xy}"},
+		{`(x) => ({"x": x})`, `fn(x) {{"x": x}}`},
+		{"(x) => (y) => x + y", "fn(x) {fn(y) {(x + y)}}"},
+		{"xs |> map((x) => x * 2)", "map([xs fn(x) {(x * 2)}])"},
+		{"(x) + (y)", "(x + y)"},
+		{"match v { n if (n > 1) => n, _ => 0 }", "match v {n if (n > 1) => n, _ => 0}"},
+		{"match v { n if f((x) => x) => n }", "match v {n if f([fn(x) {x}]) => n}"},
+		{"match v { n => (x) => n }", "match v {n => fn(x) {n}}"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.input, func(t *testing.T) {
+			prog, errs := parser.New(tc.input).Parse()
+			if len(errs) > 0 {
+				t.Fatalf("got errors %v", errs)
+			}
+			if got := prog.Statements[0].String(); got != tc.expected {
+				t.Fatalf("string mismatch: expected %q, got %q", tc.expected, got)
+			}
+		})
+	}
+	for _, input := range []string{
+		"(x, 1) => x",
+		"(x) =>",
+		"xs |>",
+		"x | y",
+	} {
+		t.Run(input, func(t *testing.T) {
+			if _, errs := parser.New(input).Parse(); len(errs) == 0 {
+				t.Fatalf("expected a parse error")
+			}
+		})
+	}
+}
+
+func TestComprehensions(t *testing.T) {
+	tests := []struct{ input, expected string }{
+		{"[x * 2 for x in xs]", "[(x * 2) for x in xs]"},
+		{"[x for x in xs if x > 0]", "[x for x in xs if (x > 0)]"},
+		{"[[x, y] for x in xs if x for y in ys if y]", "[[x, y] for x in xs if x for y in ys if y]"},
+		{"[a for a, b in xs]", "[a for [a, b] in xs]"},
+		{"[a for [a, ...r] in xs]", "[a for [a, ...r] in xs]"},
+		{"[...x for x in xs]", "[...x for x in xs]"},
+		{"[x for x in xs |> f]", "[x for x in f([xs])]"},
+		{"{k: v for k, v in h}", "{k: v for [k, v] in h}"},
+		{`{x: x * x for x in xs if x != 1}`, "{x: (x * x) for x in xs if (x != 1)}"},
+		{"[f(x) for x in [1, 2]][0]", "[f([x]) for x in [1, 2]][0]"},
+		{"let in = 1; in", "let in = 1"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.input, func(t *testing.T) {
+			prog, errs := parser.New(tc.input).Parse()
+			if len(errs) > 0 {
+				t.Fatalf("got errors %v", errs)
+			}
+			if got := prog.Statements[0].String(); got != tc.expected {
+				t.Fatalf("string mismatch: expected %q, got %q", tc.expected, got)
+			}
+		})
+	}
+	for _, input := range []string{
+		"[x for x xs]",
+		"[x for x in]",
+		"[x for x in xs if]",
+		"[x for x in xs, y]",
+		"[x, y for x in xs]",
+		"[x for x, in xs]",
+		"{k: v for k, v in h, 1: 2}",
+		"{k: v, 1: 2 for k, v in h}",
+	} {
+		t.Run(input, func(t *testing.T) {
+			if _, errs := parser.New(input).Parse(); len(errs) == 0 {
+				t.Fatalf("expected a parse error")
+			}
+		})
+	}
+}
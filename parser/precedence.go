@@ -6,8 +6,10 @@ const (
 	_ int = iota
 	LOWEST
 	ASSIGN
+	COALESCE
 	EQ
 	LESSGREATER
+	PIPE
 	SUM
 	PRODUCT
 	PREFIX
@@ -28,6 +30,11 @@ var lookup map[token.Type]int = map[token.Type]int{
 	token.Lt:     LESSGREATER,
 	token.POPEN:  FUNCTION_CALL,
 	token.SOPEN:  ARRAY_INDEX,
+	token.DOT:    FUNCTION_CALL,
+
+	token.OPTIONAL: FUNCTION_CALL,
+	token.COALESCE: COALESCE,
+	token.PIPE:     PIPE,
 }
 
 func tokenPrecedence(ttype token.Type) int {
@@ -2,10 +2,13 @@ package repl
 
 import (
 	"bufio"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 
 	"github.com/kvalv/monkey/eval"
+	"github.com/kvalv/monkey/module"
 	"github.com/kvalv/monkey/object"
 	"github.com/kvalv/monkey/parser"
 )
@@ -13,7 +16,8 @@ import (
 func Start(w io.Writer, r io.Reader) {
 	sc := bufio.NewScanner(r)
 	fmt.Fprintf(w, "> ")
-	env := object.NewEnvironment()
+	// imports are relative to the working directory
+	in := eval.NewInterpreter(eval.WithLoader(module.Dir(".")))
 	for sc.Scan() {
 		line := sc.Text()
 		p := parser.New(line)
@@ -24,8 +28,15 @@ func Start(w io.Writer, r io.Reader) {
 			}
 			continue
 		}
-		res := eval.Eval(prog, env)
-		fmt.Fprintf(w, "%s", res)
+		res, err := in.RunProgram(context.Background(), prog)
+		var rerr *object.Error
+		if errors.As(err, &rerr) {
+			fmt.Fprintf(w, "error: %s", rerr.StackTrace())
+		} else if err != nil {
+			fmt.Fprintf(w, "error: %s", err)
+		} else {
+			fmt.Fprintf(w, "%s", res)
+		}
 		fmt.Fprintf(w, "\n> ")
 	}
 }
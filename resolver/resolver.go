@@ -0,0 +1,434 @@
+// Package resolver binds the identifiers of a program to the variables they
+// refer to before the program runs.
+//
+// Parameters and let bindings inside a function get a slot in the frame of
+// that function, and every use of them is annotated with the number of
+// functions between the use and the definition (the depth) and the slot.
+// Top-level bindings stay in the global environment and are looked up by
+// name, so that they can be shared between programs and set from Go.
+//
+// Function bodies are resolved once the enclosing scope is complete, so a
+// function may refer to bindings that are defined after it, as long as they
+// exist by the time it is called.
+//
+// Each for clause of a comprehension, each arm of a match and each catch
+// block binds its names in a scope of its own, which is a frame just like
+// that of a function.
+//
+// A pattern that is just the name of a variant of an enum matches that
+// variant rather than binding the name, so the resolver replaces it with a
+// variant pattern.
+package resolver
+
+import (
+	"fmt"
+
+	"github.com/kvalv/monkey/ast"
+	"github.com/kvalv/monkey/token"
+)
+
+// Error is a name error found by the resolver. Token is the offending
+// identifier.
+type Error struct {
+	Token   token.Token
+	Message string
+}
+
+func (e *Error) Error() string { return e.Message }
+
+type scope struct {
+	parent *scope // nil for the global scope
+	slots  map[string]int
+	// names in slots that are variants of an enum declared in this scope
+	variants map[string]bool
+	// functions declared in this scope, resolved once it is complete
+	pending []*ast.FunctionLiteral
+	// scopes of the comprehensions in this scope, completed along with it
+	children []*scope
+}
+
+func (s *scope) global() bool { return s.parent == nil }
+
+// declare returns the slot for name, reusing it if the name already has one
+func (s *scope) declare(name string) int {
+	if slot, ok := s.slots[name]; ok {
+		return slot
+	}
+	slot := len(s.slots)
+	s.slots[name] = slot
+	return slot
+}
+
+// Global is what a name is bound to before the program runs.
+type Global int
+
+const (
+	Undefined Global = iota
+	Defined
+	// Variant is a variant of an enum, which a pattern of just its name
+	// matches
+	Variant
+)
+
+type resolver struct {
+	globals func(name string) Global
+	errs    []error
+	blocks  int // depth of nested blocks, for exports
+}
+
+// Resolve returns a copy of prog whose identifiers and function literals are
+// annotated, and which is marked as resolved if there were no errors. prog
+// itself is not changed, so a program may be resolved while other goroutines
+// evaluate it. globals reports what a name is bound to in the global
+// environment, where builtins count as defined; top-level names that are
+// neither defined nor bound by the program are errors.
+func Resolve(prog *ast.Program, globals func(name string) Global) (*ast.Program, []error) {
+	prog = ast.Modify(prog, func(n ast.Node) (ast.Node, bool) { return n, true }).(*ast.Program)
+	r := &resolver{globals: globals}
+	global := &scope{slots: make(map[string]int)}
+	r.statements(prog.Statements, global, make(map[string]bool))
+	r.complete(global)
+	prog.Resolved = len(r.errs) == 0
+	return prog, r.errs
+}
+
+func (r *resolver) errorf(tok token.Token, format string, a ...any) {
+	r.errs = append(r.errs, &Error{Token: tok, Message: fmt.Sprintf(format, a...)})
+}
+
+// statements resolves a statement list; seen holds the names bound by
+// earlier statements of the same list. Declared functions are hoisted: they
+// are bound before any statement of the list.
+func (r *resolver) statements(stmts []ast.Statement, s *scope, seen map[string]bool) {
+	for _, stmt := range stmts {
+		if decl := ast.Declaration(stmt); decl != nil {
+			r.bind(decl.Name, s, seen)
+		}
+	}
+	for _, stmt := range stmts {
+		r.statement(stmt, s, seen)
+	}
+}
+
+func (r *resolver) statement(stmt ast.Statement, s *scope, seen map[string]bool) {
+	switch n := stmt.(type) {
+	case *ast.LetStatement:
+		r.expression(n.Rhs, s)
+		if n.Pattern != nil {
+			n.Pattern = r.pattern(n.Pattern, s, seen)
+		} else {
+			r.bind(n.Lhs, s, seen)
+		}
+	case *ast.FunctionDeclaration:
+		s.pending = append(s.pending, n.Fn)
+	case *ast.EnumDeclaration:
+		r.bind(n.Name, s, seen)
+		for _, v := range n.Variants {
+			r.bind(v.Name, s, seen)
+			if s.variants == nil {
+				s.variants = make(map[string]bool)
+			}
+			s.variants[v.Name.Value] = true
+		}
+	case *ast.StructDeclaration:
+		r.bind(n.Name, s, seen)
+		for _, m := range n.Methods {
+			s.pending = append(s.pending, m.Fn)
+		}
+	case *ast.ExpressionStatement:
+		r.expression(n.Expr, s)
+	case *ast.ExportStatement:
+		if !s.global() || r.blocks > 0 {
+			r.errorf(n.Token, "export is only allowed at the top level of a module")
+		}
+		r.statement(n.Stmt, s, seen)
+	case *ast.BlockStatement:
+		r.blocks++
+		r.statements(n.Statements, s, make(map[string]bool))
+		r.blocks--
+	}
+}
+
+// bind declares the identifier of a let statement or function declaration in
+// s
+func (r *resolver) bind(id *ast.Identifier, s *scope, seen map[string]bool) {
+	if seen[id.Value] {
+		r.errorf(id.Token, "identifier '%s' already defined", id.Value)
+	}
+	seen[id.Value] = true
+	slot := s.declare(id.Value)
+	id.Binding = nil
+	if !s.global() {
+		id.Binding = &ast.Binding{Depth: 0, Slot: slot}
+	}
+}
+
+// pattern declares the identifiers of a destructuring pattern, and returns
+// the pattern with the names of variants replaced by variant patterns. Keys
+// and defaults are resolved before the element they belong to is bound,
+// which is the order they are evaluated in.
+func (r *resolver) pattern(pat ast.Pattern, s *scope, seen map[string]bool) ast.Pattern {
+	element := func(e *ast.PatternElement) {
+		if e.Default != nil {
+			r.expression(e.Default, s)
+		}
+		e.Target = r.pattern(e.Target, s, seen)
+	}
+	switch p := pat.(type) {
+	case *ast.Identifier:
+		if r.variant(p.Value, s) {
+			r.identifier(p, s)
+			return &ast.VariantPattern{Token: p.Token, Name: p}
+		}
+		r.bind(p, s, seen)
+	case *ast.ArrayPattern:
+		for i := range p.Elems {
+			element(&p.Elems[i])
+		}
+		if p.Rest != nil {
+			r.bind(p.Rest, s, seen)
+		}
+	case *ast.HashPattern:
+		for i := range p.Pairs {
+			r.expression(p.Pairs[i].Key, s)
+			element(&p.Pairs[i].PatternElement)
+		}
+	case *ast.LiteralPattern:
+		r.expression(p.Value, s)
+	case *ast.VariantPattern:
+		// the variant is looked up, not bound
+		if p.Enum != nil {
+			r.identifier(p.Enum, s)
+		} else {
+			r.identifier(p.Name, s)
+		}
+		for i, field := range p.Fields {
+			p.Fields[i] = r.pattern(field, s, seen)
+		}
+	}
+	return pat
+}
+
+// variant reports whether name refers to a variant of an enum in s
+func (r *resolver) variant(name string, s *scope) bool {
+	for ; s != nil; s = s.parent {
+		if _, ok := s.slots[name]; ok {
+			return s.variants[name]
+		}
+	}
+	return r.globals(name) == Variant
+}
+
+func (r *resolver) expression(expr ast.Expression, s *scope) {
+	switch n := expr.(type) {
+	case *ast.Identifier:
+		r.identifier(n, s)
+	case *ast.PrefixExpression:
+		r.expression(n.Rhs, s)
+	case *ast.InfixExpression:
+		r.expression(n.Lhs, s)
+		r.expression(n.Rhs, s)
+	case *ast.AssignExpression:
+		r.expression(n.Lhs, s)
+		r.expression(n.Rhs, s)
+	case *ast.IfExpression:
+		r.expression(n.Cond, s)
+		r.statement(n.Then, s, nil)
+		if n.Else != nil {
+			r.statement(n.Else, s, nil)
+		}
+	case *ast.ReturnExpression:
+		r.expression(n.Value, s)
+	case *ast.ThrowExpression:
+		r.expression(n.Value, s)
+	case *ast.TryExpression:
+		r.statement(n.Block, s, nil)
+		if n.Catch != nil {
+			// the parameter is bound like a let at the start of the block,
+			// in a scope that only the block sees
+			inner := &scope{parent: s, slots: make(map[string]int)}
+			s.children = append(s.children, inner)
+			seen := make(map[string]bool)
+			if n.Param != nil {
+				r.bind(n.Param, inner, seen)
+			}
+			r.blocks++
+			r.statements(n.Catch.Statements, inner, seen)
+			r.blocks--
+			n.Locals = len(inner.slots)
+		}
+		if n.Finally != nil {
+			r.statement(n.Finally, s, nil)
+		}
+	case *ast.FunctionLiteral:
+		s.pending = append(s.pending, n)
+	case *ast.CallExpression:
+		if id, ok := n.Function.(*ast.Identifier); ok && id.Value == "quote" && !bound(id.Value, s) {
+			r.quoted(n.Params, s)
+			return
+		}
+		r.expression(n.Function, s)
+		for _, p := range n.Params {
+			r.expression(p, s)
+		}
+	case *ast.MatchExpression:
+		r.expression(n.Value, s)
+		for i := range n.Arms {
+			// like a for clause, each arm binds its names in a scope of its
+			// own
+			arm := &n.Arms[i]
+			inner := &scope{parent: s, slots: make(map[string]int)}
+			s.children = append(s.children, inner)
+			arm.Pattern = r.pattern(arm.Pattern, inner, make(map[string]bool))
+			if arm.Guard != nil {
+				r.expression(arm.Guard, inner)
+			}
+			r.expression(arm.Body, inner)
+			arm.Locals = len(inner.slots)
+		}
+	case *ast.FieldExpression:
+		// the field is looked up on the value, not in scope
+		r.expression(n.Value, s)
+	case *ast.Spread:
+		r.expression(n.Value, s)
+	case *ast.KeywordArgument:
+		// the name refers to a parameter of the callee, not to a variable
+		r.expression(n.Value, s)
+	case *ast.Array:
+		for _, e := range n.Elems {
+			r.expression(e, s)
+		}
+	case *ast.ArrayIndex:
+		r.expression(n.Array, s)
+		r.expression(n.Index, s)
+	case *ast.HashLiteral:
+		for k, v := range n.Pairs {
+			r.expression(k, s)
+			r.expression(v, s)
+		}
+	case *ast.ArrayComprehension:
+		r.comprehension(n.Clauses, s, n.Value)
+	case *ast.HashComprehension:
+		r.comprehension(n.Clauses, s, n.Key, n.Value)
+	}
+}
+
+// comprehension resolves the clauses of a comprehension, each in the scope of
+// the for clauses before it, and then the expressions that make up its
+// values in the scope of the last one.
+func (r *resolver) comprehension(clauses []ast.ComprehensionClause, s *scope, values ...ast.Expression) {
+	scopes := make([]*scope, len(clauses))
+	for i := range clauses {
+		c := &clauses[i]
+		if c.Cond != nil {
+			r.expression(c.Cond, s)
+			continue
+		}
+		r.expression(c.Iterable, s)
+		inner := &scope{parent: s, slots: make(map[string]int)}
+		s.children = append(s.children, inner)
+		c.Target = r.pattern(c.Target, inner, make(map[string]bool))
+		scopes[i], s = inner, inner
+	}
+	for _, v := range values {
+		r.expression(v, s)
+	}
+	// the values may bind names of their own, in blocks
+	for i, inner := range scopes {
+		if inner != nil {
+			clauses[i].Locals = len(inner.slots)
+		}
+	}
+}
+
+// quoted resolves the arguments of quote. Quoted code is not evaluated, so
+// only the expressions that it unquotes are resolved.
+func (r *resolver) quoted(args []ast.Expression, s *scope) {
+	for _, arg := range args {
+		ast.Modify(arg, func(n ast.Node) (ast.Node, bool) {
+			call, ok := n.(*ast.CallExpression)
+			if !ok {
+				return n, true
+			}
+			if id, ok := call.Function.(*ast.Identifier); !ok || id.Value != "unquote" {
+				return n, true
+			}
+			for _, p := range call.Params {
+				r.expression(p, s)
+			}
+			return n, false
+		})
+	}
+}
+
+// bound reports whether name is bound in s or a scope around it
+func bound(name string, s *scope) bool {
+	for ; s != nil; s = s.parent {
+		if _, ok := s.slots[name]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *resolver) identifier(id *ast.Identifier, s *scope) {
+	for depth := 0; !s.global(); depth++ {
+		if slot, ok := s.slots[id.Value]; ok {
+			id.Binding = &ast.Binding{Depth: depth, Slot: slot}
+			return
+		}
+		s = s.parent
+	}
+	id.Binding = nil
+	if _, ok := s.slots[id.Value]; !ok && r.globals(id.Value) == Undefined {
+		r.errorf(id.Token, "identifier '%s' not defined", id.Value)
+	}
+}
+
+// complete resolves the functions declared in s, now that all of the
+// bindings of s are known.
+func (r *resolver) complete(s *scope) {
+	for len(s.pending) > 0 {
+		fn := s.pending[0]
+		s.pending = s.pending[1:]
+		r.function(fn, s)
+	}
+	for _, c := range s.children {
+		r.complete(c)
+	}
+}
+
+func (r *resolver) function(fn *ast.FunctionLiteral, outer *scope) {
+	s := &scope{parent: outer, slots: make(map[string]int)}
+	seen := make(map[string]bool)
+	if p := fn.Receiver; p != nil {
+		seen[p.Value] = true
+		p.Binding = &ast.Binding{Depth: 0, Slot: s.declare(p.Value)}
+	}
+	for i := range fn.Params {
+		p := &fn.Params[i]
+		if seen[p.Value] {
+			r.errorf(p.Token, "repeated argument %q", p.Value)
+		}
+		seen[p.Value] = true
+		p.Binding = &ast.Binding{Depth: 0, Slot: s.declare(p.Value)}
+	}
+	if p := fn.Rest; p != nil {
+		if seen[p.Value] {
+			r.errorf(p.Token, "repeated argument %q", p.Value)
+		}
+		seen[p.Value] = true
+		p.Binding = &ast.Binding{Depth: 0, Slot: s.declare(p.Value)}
+	}
+	// defaults are evaluated in the frame of the call, once the arguments
+	// are bound
+	for _, d := range fn.Defaults {
+		if d != nil {
+			r.expression(d, s)
+		}
+	}
+	r.statements(fn.Body.Statements, s, seen)
+	r.complete(s)
+	fn.Locals = len(s.slots)
+	fn.Resolved = true
+}
@@ -0,0 +1,265 @@
+package resolver_test
+
+import (
+	"testing"
+
+	"github.com/kvalv/monkey/ast"
+	"github.com/kvalv/monkey/parser"
+	"github.com/kvalv/monkey/resolver"
+)
+
+func TestBindings(t *testing.T) {
+	prog := expectResolve(t, `
+		let g = 1;
+		let f = fn(a, b) {
+			let c = a + g;
+			fn(d) { a + c + d }
+		};`)
+
+	f := prog.Statements[1].(*ast.LetStatement).Rhs.(*ast.FunctionLiteral)
+	if f.Locals != 3 {
+		t.Fatalf("expected 3 locals, got %d", f.Locals)
+	}
+	let := f.Body.Statements[0].(*ast.LetStatement)
+	expectBinding(t, let.Lhs, &ast.Binding{Depth: 0, Slot: 2})
+	sum := let.Rhs.(*ast.InfixExpression)
+	expectBinding(t, sum.Lhs.(*ast.Identifier), &ast.Binding{Depth: 0, Slot: 0})
+	expectBinding(t, sum.Rhs.(*ast.Identifier), nil) // g is a global
+
+	inner := f.Body.Statements[1].(*ast.ExpressionStatement).Expr.(*ast.FunctionLiteral)
+	body := inner.Body.Statements[0].(*ast.ExpressionStatement).Expr.(*ast.InfixExpression)
+	lhs := body.Lhs.(*ast.InfixExpression)
+	expectBinding(t, lhs.Lhs.(*ast.Identifier), &ast.Binding{Depth: 1, Slot: 0})
+	expectBinding(t, lhs.Rhs.(*ast.Identifier), &ast.Binding{Depth: 1, Slot: 2})
+	expectBinding(t, body.Rhs.(*ast.Identifier), &ast.Binding{Depth: 0, Slot: 0})
+}
+
+func TestForwardReferences(t *testing.T) {
+	expectResolve(t, "let f = fn() { g() }; let g = fn() { 1 }; f()")
+	expectResolve(t, "fn() { let f = fn() { g() }; let g = fn() { 1 }; f() }")
+	expectResolve(t, "len")
+	// the parameter of a catch block is bound in a scope of its own, which
+	// may shadow outer names
+	expectResolve(t, "fn(e) { try { 1 } catch (e) { let x = e; x }; e }")
+	// defaults may refer to the other parameters
+	expectResolve(t, "let n = 1; fn(a, b = a + n, ...rest) { [b, rest] }")
+	expectResolve(t, "let f = fn(a) { a }; let xs = [1]; f(...xs, a: 2)")
+	// destructuring binds every identifier of the pattern
+	expectResolve(t, `fn(xs) { let [a, {"k": b = a}, ...r] = xs; [a, b, r] }`)
+	// every arm of a match binds its own names
+	expectResolve(t, "fn(x) { match x { [a, b] if a > b => a, [a, _] => a, a => a } }")
+	// declared functions are hoisted
+	expectResolve(t, "f(); fn f() { 1 }")
+	expectResolve(t, "fn() { even(2); fn even(n) { odd(n) }; fn odd(n) { even(n) } }")
+	// methods see the instance as self, and the struct itself
+	expectResolve(t, "struct P { a, fn get() { self.a }, fn next() { P(self.a + 1) } }; P(1).next().get()")
+	expectResolve(t, "fn() { struct P { a, fn f(x = self.a) { [self, x] } }; P(1).f() }")
+	// variants are looked up in patterns, their fields are bound
+	expectResolve(t, "enum E { A(x), B }; fn f(e) { match e { A(x) => x, E.B => 0 } }; let A(y) = A(1); y")
+	// quoted code is not resolved, except for what it unquotes
+	expectResolve(t, "fn(x) { quote(y + unquote(x)) }")
+	// comprehension variables are bound for the clauses after them, and
+	// shadow outer names
+	expectResolve(t, "let x = 1; fn(xs) { [[x, y] for x in xs if x for y in x if y > x] }")
+	expectResolve(t, "{k: [k, v] for k, v in {}}")
+	// exported bindings are bound like any other
+	expectResolve(t, `let m = import "./m"; export fn f() { g() }; export let [g] = [fn() { m }]; f()`)
+}
+
+func TestErrors(t *testing.T) {
+	cases := []struct {
+		input    string
+		expected []string
+	}{
+		{"x", []string{"identifier 'x' not defined"}},
+		{"x; let x = 1", []string{"identifier 'x' not defined"}},
+		{"fn() { y }", []string{"identifier 'y' not defined"}},
+		{"fn(a, a) { a }", []string{`repeated argument "a"`}},
+		{"fn(a, ...a) { a }", []string{`repeated argument "a"`}},
+		{"fn(a = b) { a }", []string{"identifier 'b' not defined"}},
+		{"let [a, b = c] = []", []string{"identifier 'c' not defined"}},
+		{"let [a, [a]] = []", []string{"identifier 'a' already defined"}},
+		{"match 1 { [a, a] => a }", []string{"identifier 'a' already defined"}},
+		{"match 1 { a => b }", []string{"identifier 'b' not defined"}},
+		{"let a = 1; let a = 2", []string{"identifier 'a' already defined"}},
+		{"fn(a) { let a = 2 }", []string{"identifier 'a' already defined"}},
+		{"let a = b; c", []string{"identifier 'b' not defined", "identifier 'c' not defined"}},
+		{"try { 1 } catch (e) { let e = 2 }", []string{"identifier 'e' already defined"}},
+		{"fn() { try { 1 } catch (e) { 2 }; e }", []string{"identifier 'e' not defined"}},
+		{"try { 1 } catch (e) { let x = 2 }; x", []string{"identifier 'x' not defined"}},
+		{"fn f() { 1 }; fn f() { 2 }", []string{"identifier 'f' already defined"}},
+		{"fn() { let f = 1; fn f() { 2 } }", []string{"identifier 'f' already defined"}},
+		{"struct P { a }; let P = 1", []string{"identifier 'P' already defined"}},
+		{"enum E { A, B(x) }; let B = 1", []string{"identifier 'B' already defined"}},
+		{"match 1 { C(x) => x }", []string{"identifier 'C' not defined"}},
+		{"struct P { a, fn f(self) { 1 } }", []string{`repeated argument "self"`}},
+		{"struct P { a, fn f() { a } }", []string{"identifier 'a' not defined"}},
+		{"quote(unquote(z))", []string{"identifier 'z' not defined"}},
+		{"[x for x in []]; x", []string{"identifier 'x' not defined"}},
+		{"[x for x in [y]]", []string{"identifier 'y' not defined"}},
+		{"[x for x in [] if y]", []string{"identifier 'y' not defined"}},
+		{"[x for x, x in []]", []string{"identifier 'x' already defined"}},
+		{"export let a = 1; export let a = 2", []string{"identifier 'a' already defined"}},
+		{"fn() { export let a = 1 }", []string{"export is only allowed at the top level of a module"}},
+		{"if (true) { export let a = 1 }", []string{"export is only allowed at the top level of a module"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.input, func(t *testing.T) {
+			prog, _ := parser.New(tc.input).Parse()
+			prog, errs := resolver.Resolve(prog, builtins)
+			if len(errs) != len(tc.expected) {
+				t.Fatalf("expected %d errors, got %v", len(tc.expected), errs)
+			}
+			for i, err := range errs {
+				if err.Error() != tc.expected[i] {
+					t.Fatalf("expected %q, got %q", tc.expected[i], err)
+				}
+			}
+			if prog.Resolved {
+				t.Fatalf("program with errors should not be marked as resolved")
+			}
+		})
+	}
+}
+
+func TestShadowingInBranches(t *testing.T) {
+	// both branches bind the same local, which is fine as they are different
+	// statement lists
+	prog := expectResolve(t, "fn(c) { if c { let x = 1 } else { let x = 2 }; x }")
+	f := prog.Statements[0].(*ast.ExpressionStatement).Expr.(*ast.FunctionLiteral)
+	if f.Locals != 2 {
+		t.Fatalf("expected 2 locals, got %d", f.Locals)
+	}
+}
+
+func TestComprehensionScopes(t *testing.T) {
+	prog := expectResolve(t, "fn(n) { [a + b + n for a in [1] for [b, c] in [[2, 3]]] }")
+	f := prog.Statements[0].(*ast.ExpressionStatement).Expr.(*ast.FunctionLiteral)
+	if f.Locals != 1 {
+		t.Fatalf("expected 1 local, got %d", f.Locals)
+	}
+	comp := f.Body.Statements[0].(*ast.ExpressionStatement).Expr.(*ast.ArrayComprehension)
+	if got := []int{comp.Clauses[0].Locals, comp.Clauses[1].Locals}; got[0] != 1 || got[1] != 2 {
+		t.Fatalf("expected clauses with 1 and 2 locals, got %v", got)
+	}
+	sum := comp.Value.(*ast.InfixExpression)
+	lhs := sum.Lhs.(*ast.InfixExpression)
+	expectBinding(t, lhs.Lhs.(*ast.Identifier), &ast.Binding{Depth: 1, Slot: 0})
+	expectBinding(t, lhs.Rhs.(*ast.Identifier), &ast.Binding{Depth: 0, Slot: 0})
+	expectBinding(t, sum.Rhs.(*ast.Identifier), &ast.Binding{Depth: 2, Slot: 0})
+}
+
+func TestMatchScopes(t *testing.T) {
+	prog := expectResolve(t, "fn(n) { match n { [a, b] => a + b + n, c => c } }")
+	f := prog.Statements[0].(*ast.ExpressionStatement).Expr.(*ast.FunctionLiteral)
+	if f.Locals != 1 {
+		t.Fatalf("expected 1 local, got %d", f.Locals)
+	}
+	m := f.Body.Statements[0].(*ast.ExpressionStatement).Expr.(*ast.MatchExpression)
+	if got := []int{m.Arms[0].Locals, m.Arms[1].Locals}; got[0] != 2 || got[1] != 1 {
+		t.Fatalf("expected arms with 2 and 1 locals, got %v", got)
+	}
+	sum := m.Arms[0].Body.(*ast.InfixExpression)
+	lhs := sum.Lhs.(*ast.InfixExpression)
+	expectBinding(t, lhs.Lhs.(*ast.Identifier), &ast.Binding{Depth: 0, Slot: 0})
+	expectBinding(t, lhs.Rhs.(*ast.Identifier), &ast.Binding{Depth: 0, Slot: 1})
+	expectBinding(t, sum.Rhs.(*ast.Identifier), &ast.Binding{Depth: 1, Slot: 0})
+	expectBinding(t, m.Arms[1].Body.(*ast.Identifier), &ast.Binding{Depth: 0, Slot: 0})
+}
+
+func TestVariantPatterns(t *testing.T) {
+	prog := expectResolve(t, "enum E { A, B(x) }; fn(v) { match v { A => 1, [B, b] => b, c => c } }")
+	f := prog.Statements[1].(*ast.ExpressionStatement).Expr.(*ast.FunctionLiteral)
+	m := f.Body.Statements[0].(*ast.ExpressionStatement).Expr.(*ast.MatchExpression)
+	if p, ok := m.Arms[0].Pattern.(*ast.VariantPattern); !ok || p.Fields != nil {
+		t.Fatalf("expected a variant pattern without fields, got %#v", m.Arms[0].Pattern)
+	}
+	arr := m.Arms[1].Pattern.(*ast.ArrayPattern)
+	if _, ok := arr.Elems[0].Target.(*ast.VariantPattern); !ok {
+		t.Fatalf("expected a variant pattern, got %#v", arr.Elems[0].Target)
+	}
+	if _, ok := arr.Elems[1].Target.(*ast.Identifier); !ok {
+		t.Fatalf("expected an identifier, got %#v", arr.Elems[1].Target)
+	}
+	if _, ok := m.Arms[2].Pattern.(*ast.Identifier); !ok {
+		t.Fatalf("expected an identifier, got %#v", m.Arms[2].Pattern)
+	}
+
+	// a variant of the global environment, and one that is shadowed
+	globals := func(name string) resolver.Global {
+		if name == "A" {
+			return resolver.Variant
+		}
+		return resolver.Undefined
+	}
+	prog, _ = parser.New("match 1 { A => 1 }; fn(A) { match 1 { A => A } }").Parse()
+	prog, errs := resolver.Resolve(prog, globals)
+	if len(errs) > 0 {
+		t.Fatalf("failed to resolve: %v", errs)
+	}
+	m = prog.Statements[0].(*ast.ExpressionStatement).Expr.(*ast.MatchExpression)
+	if _, ok := m.Arms[0].Pattern.(*ast.VariantPattern); !ok {
+		t.Fatalf("expected a variant pattern, got %#v", m.Arms[0].Pattern)
+	}
+	f = prog.Statements[1].(*ast.ExpressionStatement).Expr.(*ast.FunctionLiteral)
+	m = f.Body.Statements[0].(*ast.ExpressionStatement).Expr.(*ast.MatchExpression)
+	if _, ok := m.Arms[0].Pattern.(*ast.Identifier); !ok {
+		t.Fatalf("expected an identifier, got %#v", m.Arms[0].Pattern)
+	}
+}
+
+func TestResolveCopies(t *testing.T) {
+	prog, _ := parser.New("let f = fn(a) { a }; f(1)").Parse()
+	res, errs := resolver.Resolve(prog, builtins)
+	if len(errs) > 0 {
+		t.Fatalf("failed to resolve: %v", errs)
+	}
+	if prog.Resolved {
+		t.Fatalf("the program that was passed in should not be changed")
+	}
+	f := prog.Statements[0].(*ast.LetStatement).Rhs.(*ast.FunctionLiteral)
+	if f.Resolved || f.Params[0].Binding != nil {
+		t.Fatalf("the functions of the program that was passed in should not be changed")
+	}
+	f = res.Statements[0].(*ast.LetStatement).Rhs.(*ast.FunctionLiteral)
+	if !f.Resolved {
+		t.Fatalf("the functions of the copy should be resolved")
+	}
+	expectBinding(t, &f.Params[0], &ast.Binding{Depth: 0, Slot: 0})
+}
+
+func builtins(name string) resolver.Global {
+	if name == "len" {
+		return resolver.Defined
+	}
+	return resolver.Undefined
+}
+
+func expectResolve(t *testing.T, input string) *ast.Program {
+	t.Helper()
+	prog, errs := parser.New(input).Parse()
+	if len(errs) > 0 {
+		t.Fatalf("failed to parse: %v", errs)
+	}
+	prog, errs = resolver.Resolve(prog, builtins)
+	if len(errs) > 0 {
+		t.Fatalf("failed to resolve: %v", errs)
+	}
+	if !prog.Resolved {
+		t.Fatalf("program not marked as resolved")
+	}
+	return prog
+}
+
+func expectBinding(t *testing.T, id *ast.Identifier, expected *ast.Binding) {
+	t.Helper()
+	if expected == nil || id.Binding == nil {
+		if expected != id.Binding {
+			t.Fatalf("%s: expected binding %v, got %v", id.Value, expected, id.Binding)
+		}
+		return
+	}
+	if *id.Binding != *expected {
+		t.Fatalf("%s: expected binding %+v, got %+v", id.Value, *expected, *id.Binding)
+	}
+}
@@ -1,5 +1,7 @@
 package token
 
+import "fmt"
+
 type Type string
 
 const (
@@ -10,6 +12,8 @@ const (
 	COMMA   Type = ","
 	COLON   Type = ":"
 
+	ELLIPSIS Type = "..."
+
 	POPEN  Type = "("
 	PCLOSE Type = ")"
 	LBRACK Type = "{"
@@ -20,9 +24,42 @@ const (
 	FUNC   Type = "fn"
 	RETURN Type = "return"
 	LET    Type = "let"
+	CONST  Type = "const"
 	IF     Type = "if"
 	ELSE   Type = "else"
 
+	// GENERATOR starts a generator function, fn*
+	GENERATOR Type = "fn*"
+
+	TRY     Type = "try"
+	CATCH   Type = "catch"
+	FINALLY Type = "finally"
+	THROW   Type = "throw"
+
+	MATCH Type = "match"
+
+	MACRO Type = "macro"
+
+	STRUCT Type = "struct"
+	ENUM   Type = "enum"
+	DOT    Type = "."
+
+	// OPTIONAL starts an access that gives null when the value is null:
+	// a?.[key], f?.(x) or a?.field. COALESCE is a ?? b.
+	OPTIONAL Type = "?."
+	COALESCE Type = "??"
+
+	// PIPE passes its left operand as the first argument of a call: x |> f(y)
+	PIPE Type = "|>"
+
+	// FOR starts a clause of a comprehension, [x for x in xs]. The in that
+	// follows is an identifier, so that it can still be used as a name.
+	FOR Type = "for"
+
+	IMPORT Type = "import"
+	EXPORT Type = "export"
+	ARROW  Type = "=>"
+
 	EQ        Type = "=="
 	ASSIGN    Type = "="
 	MUL       Type = "*"
@@ -44,9 +81,17 @@ type Span struct {
 	Start, End int
 }
 
+// Pos is the line and column of the start of a token, both counting from 1.
+type Pos struct {
+	Line, Col int
+}
+
+func (p Pos) String() string { return fmt.Sprintf("%d:%d", p.Line, p.Col) }
+
 type Token struct {
 	Type    Type
 	Literal string
 	// Span marks the position from start (inclusive) to end (exclusive)
 	Span
+	Pos
 }
@@ -5,6 +5,7 @@ import (
 	"io"
 	"log"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/kvalv/monkey/ast"
@@ -12,6 +13,7 @@ import (
 
 type Tracer struct {
 	log.Logger
+	mu    sync.Mutex // guards level; a tracer may be shared by concurrent evaluations
 	level int
 }
 
@@ -21,10 +23,18 @@ func New(w io.Writer) *Tracer {
 	}
 }
 
+func noop(ast.Node) {}
+
 func (t *Tracer) Trace(name string) func(n ast.Node) {
+	if t.Writer() == io.Discard {
+		// nobody is listening; don't pay for formatting
+		return noop
+	}
 	start := time.Now()
+	t.mu.Lock()
 	indent := strings.Repeat(" ", t.level*2)
 	t.level++
+	t.mu.Unlock()
 	t.Logger.Printf("%sBEGIN %s", indent, name)
 	return func(n ast.Node) {
 		elapsed := time.Since(start)
@@ -33,6 +43,8 @@ func (t *Tracer) Trace(name string) func(n ast.Node) {
 			line = fmt.Sprintf("%s -> %s", line, n.String())
 		}
 		t.Logger.Printf(line)
+		t.mu.Lock()
 		t.level--
+		t.mu.Unlock()
 	}
 }
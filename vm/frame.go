@@ -0,0 +1,23 @@
+package vm
+
+import (
+	"github.com/kvalv/monkey/compiler"
+	"github.com/kvalv/monkey/object"
+)
+
+// Frame is the activation record of a single call.
+type Frame struct {
+	cl *object.Closure
+	ip int
+	// basePointer is the stack position of the first local; the closure
+	// itself sits just below it.
+	basePointer int
+}
+
+func NewFrame(cl *object.Closure, basePointer int) *Frame {
+	return &Frame{cl: cl, ip: -1, basePointer: basePointer}
+}
+
+func (f *Frame) Instructions() compiler.Instructions {
+	return f.cl.Fn.Instructions
+}
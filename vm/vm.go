@@ -0,0 +1,425 @@
+package vm
+
+import (
+	"context"
+	"sync"
+
+	"github.com/kvalv/monkey/compiler"
+	"github.com/kvalv/monkey/eval"
+	"github.com/kvalv/monkey/object"
+)
+
+const (
+	StackSize   = 1 << 16
+	GlobalsSize = 1 << 16
+	MaxFrames   = 1 << 14
+)
+
+// the stack of a vm starts out with room for this many values, and grows as
+// needed up to StackSize
+const initialStackSize = 1 << 8
+
+// VM executes bytecode produced by the compiler. Operators, indexing and
+// builtins are shared with the evaluator, so both give the same results and
+// the same error messages.
+type VM struct {
+	constants []object.Object
+	globals   []object.Object
+	builtins  []*object.Builtin
+
+	stack []object.Object
+	sp    int // points to the next free slot; the top is stack[sp-1]
+
+	frames      []*Frame
+	framesIndex int
+
+	result object.Object
+}
+
+func New(bc *compiler.Bytecode) *VM {
+	return NewWithGlobals(bc, make([]object.Object, GlobalsSize))
+}
+
+// NewWithGlobals returns a vm that shares its globals with an earlier run,
+// which is how a REPL keeps state between lines.
+func NewWithGlobals(bc *compiler.Bytecode, globals []object.Object) *VM {
+	var builtins []*object.Builtin
+	for _, name := range eval.BuiltinNames() {
+		fn, _ := eval.Builtin(name)
+		builtins = append(builtins, fn)
+	}
+	main := &object.Closure{Fn: &object.CompiledFunction{Instructions: bc.Instructions}}
+	vm := &VM{
+		constants: bc.Constants,
+		globals:   globals,
+		builtins:  builtins,
+		stack:     make([]object.Object, initialStackSize),
+	}
+	vm.pushFrame(NewFrame(main, 0))
+	return vm
+}
+
+// Result returns the value of the program after Run has returned without
+// error.
+func (vm *VM) Result() object.Object { return vm.result }
+
+// Run executes the program, and then runs the event loop until no timers or
+// promise callbacks are pending, as Interpreter.RunProgram does. Runtime
+// errors are returned as *object.Error. Cancelling ctx aborts the program at
+// the next function call; ctx is also handed to builtins through their
+// environment, along with a way to call the closures of the vm.
+func (vm *VM) Run(ctx context.Context) error {
+	ctx, runLoop := eval.WithEventLoop(eval.WithCallback(ctx, vm.callback), nil)
+	env := object.NewEnvironment().WithContext(ctx)
+	if err := vm.run(env); err != nil {
+		return err
+	}
+	return runLoop(env)
+}
+
+// callbackMain is the main function of the vms that callbacks run on. It
+// returns what the call leaves on the stack.
+var callbackMain = &object.Closure{Fn: &object.CompiledFunction{Instructions: compiler.Make(compiler.OpReturnValue)}}
+
+// callbackVMs holds the vms that callbacks have finished with, so that a
+// builtin that calls a closure for every element does not make a new stack
+// each time.
+var callbackVMs = sync.Pool{
+	New: func() any { return &VM{stack: make([]object.Object, initialStackSize)} },
+}
+
+// callback calls fn for a builtin, if it is a closure. It runs on a vm of its
+// own that shares the globals, so builtins may call it from any goroutine and
+// after the program has ended.
+func (vm *VM) callback(fn object.Object, args []object.Object, env *object.Environment) (object.Object, bool) {
+	cl, ok := fn.(*object.Closure)
+	if !ok {
+		return nil, false
+	}
+	sub := callbackVMs.Get().(*VM)
+	defer sub.release()
+	sub.constants, sub.globals, sub.builtins = vm.constants, vm.globals, vm.builtins
+	sub.pushFrame(NewFrame(callbackMain, 0))
+	err := sub.push(cl)
+	for _, arg := range args {
+		if err == nil {
+			err = sub.push(arg)
+		}
+	}
+	if err == nil {
+		err = sub.call(env, len(args))
+	}
+	if err == nil {
+		err = sub.run(env)
+	}
+	if err != nil {
+		// the vm only fails with *object.Error
+		return err.(*object.Error), true
+	}
+	return sub.result, true
+}
+
+// release resets a vm that ran a callback and puts it back in the pool. The
+// values it held are cleared, so that the pool does not keep them alive.
+func (vm *VM) release() {
+	clear(vm.stack)
+	clear(vm.frames)
+	*vm = VM{stack: vm.stack, frames: vm.frames[:0]}
+	callbackVMs.Put(vm)
+}
+
+func (vm *VM) run(env *object.Environment) error {
+	ctx := env.Context()
+	for {
+		frame := vm.currentFrame()
+		frame.ip++
+		ins := frame.Instructions()
+		ip := frame.ip
+		op := compiler.Opcode(ins[ip])
+
+		switch op {
+		case compiler.OpConstant:
+			idx := compiler.ReadUint16(ins[ip+1:])
+			frame.ip += 2
+			if err := vm.push(vm.constants[idx]); err != nil {
+				return err
+			}
+		case compiler.OpPop:
+			vm.pop()
+		case compiler.OpTrue:
+			if err := vm.push(object.TRUE); err != nil {
+				return err
+			}
+		case compiler.OpFalse:
+			if err := vm.push(object.FALSE); err != nil {
+				return err
+			}
+		case compiler.OpNull:
+			if err := vm.push(object.NULL); err != nil {
+				return err
+			}
+
+		case compiler.OpAdd, compiler.OpSub, compiler.OpMul, compiler.OpDiv,
+			compiler.OpEqual, compiler.OpNotEqual, compiler.OpGreaterThan, compiler.OpLessThan:
+			rhs := vm.pop()
+			lhs := vm.pop()
+			if err := vm.pushResult(eval.Infix(compiler.Operators[op], lhs, rhs)); err != nil {
+				return err
+			}
+		case compiler.OpMinus, compiler.OpBang:
+			rhs := vm.pop()
+			if err := vm.pushResult(eval.Prefix(compiler.Operators[op], rhs)); err != nil {
+				return err
+			}
+
+		case compiler.OpJump:
+			pos := int(compiler.ReadUint16(ins[ip+1:]))
+			frame.ip = pos - 1
+		case compiler.OpJumpNotTruthy:
+			pos := int(compiler.ReadUint16(ins[ip+1:]))
+			frame.ip += 2
+			if !isTruthy(vm.pop()) {
+				frame.ip = pos - 1
+			}
+
+		case compiler.OpSetGlobal:
+			idx := compiler.ReadUint16(ins[ip+1:])
+			frame.ip += 2
+			vm.globals[idx] = vm.pop()
+		case compiler.OpGetGlobal:
+			idx := compiler.ReadUint16(ins[ip+1:])
+			frame.ip += 2
+			if err := vm.pushDefined(vm.globals[idx]); err != nil {
+				return err
+			}
+		case compiler.OpSetLocal:
+			idx := compiler.ReadUint8(ins[ip+1:])
+			frame.ip++
+			vm.stack[frame.basePointer+int(idx)] = vm.pop()
+		case compiler.OpGetLocal:
+			idx := compiler.ReadUint8(ins[ip+1:])
+			frame.ip++
+			if err := vm.pushDefined(vm.stack[frame.basePointer+int(idx)]); err != nil {
+				return err
+			}
+		case compiler.OpGetBuiltin:
+			idx := compiler.ReadUint8(ins[ip+1:])
+			frame.ip++
+			if err := vm.push(vm.builtins[idx]); err != nil {
+				return err
+			}
+		case compiler.OpGetFree:
+			idx := compiler.ReadUint8(ins[ip+1:])
+			frame.ip++
+			if err := vm.push(frame.cl.Free[idx]); err != nil {
+				return err
+			}
+		case compiler.OpCurrentClosure:
+			if err := vm.push(frame.cl); err != nil {
+				return err
+			}
+
+		case compiler.OpArray:
+			n := int(compiler.ReadUint16(ins[ip+1:]))
+			frame.ip += 2
+			elems := make([]object.Object, n)
+			copy(elems, vm.stack[vm.sp-n:vm.sp])
+			vm.sp -= n
+			if err := vm.push(object.NewArray(elems...)); err != nil {
+				return err
+			}
+		case compiler.OpHash:
+			n := int(compiler.ReadUint16(ins[ip+1:]))
+			frame.ip += 2
+			hash := &object.Hash{}
+			for i := vm.sp - n; i < vm.sp; i += 2 {
+				hash.Set(vm.stack[i], vm.stack[i+1])
+			}
+			vm.sp -= n
+			if err := vm.push(hash); err != nil {
+				return err
+			}
+		case compiler.OpIndex:
+			container := vm.pop()
+			index := vm.pop()
+			if err := vm.pushResult(eval.Index(container, index)); err != nil {
+				return err
+			}
+		case compiler.OpSetIndex:
+			value := vm.pop()
+			index := vm.pop()
+			container := vm.pop()
+			hash, ok := container.(*object.Hash)
+			if !ok {
+				return object.NewError(object.TypeError, "assignment is only supported for hashes, got %s", container.Type())
+			}
+			if err := hash.Set(index, value); err != nil {
+				return object.NewError(object.TypeError, "cannot modify a frozen %s", hash.Type())
+			}
+			if err := vm.push(object.NULL); err != nil {
+				return err
+			}
+
+		case compiler.OpClosure:
+			idx := compiler.ReadUint16(ins[ip+1:])
+			numFree := int(compiler.ReadUint8(ins[ip+3:]))
+			frame.ip += 3
+			fn, ok := vm.constants[idx].(*object.CompiledFunction)
+			if !ok {
+				return object.NewError(object.TypeError, "not a function: %s", vm.constants[idx].Type())
+			}
+			free := make([]object.Object, numFree)
+			copy(free, vm.stack[vm.sp-numFree:vm.sp])
+			vm.sp -= numFree
+			if err := vm.push(&object.Closure{Fn: fn, Free: free}); err != nil {
+				return err
+			}
+		case compiler.OpSetFree:
+			idx := compiler.ReadUint8(ins[ip+1:])
+			frame.ip++
+			value := vm.pop()
+			cl, ok := vm.pop().(*object.Closure)
+			if !ok {
+				return object.NewError(object.TypeError, "not a closure")
+			}
+			cl.Free[idx] = value
+		case compiler.OpCall:
+			numArgs := int(compiler.ReadUint8(ins[ip+1:]))
+			frame.ip++
+			if err := ctx.Err(); err != nil {
+				return object.NewError(object.CancelledError, "evaluation cancelled: %v", err)
+			}
+			if err := vm.call(env, numArgs); err != nil {
+				return err
+			}
+		case compiler.OpReturnValue, compiler.OpReturn:
+			value := object.Object(object.NULL)
+			if op == compiler.OpReturnValue {
+				value = vm.pop()
+			}
+			if vm.framesIndex == 1 {
+				// returning from the main program ends it
+				vm.result = value
+				return nil
+			}
+			frame := vm.popFrame()
+			vm.sp = frame.basePointer - 1
+			if err := vm.push(value); err != nil {
+				return err
+			}
+
+		default:
+			return object.NewError(object.RuntimeError, "unknown opcode %d", op)
+		}
+	}
+}
+
+func (vm *VM) call(env *object.Environment, numArgs int) error {
+	callee := vm.stack[vm.sp-1-numArgs]
+	switch fn := callee.(type) {
+	case *object.Closure:
+		if exp := fn.Fn.NumParams; numArgs > exp {
+			return object.NewError(object.ArgumentError, "Error invoking function: expected %d arguments but received %d", exp, numArgs)
+		} else if numArgs < exp {
+			return object.NewError(object.ArgumentError, "Error invoking function: missing argument for parameter '%s'", fn.Fn.ParamNames[numArgs])
+		}
+		if vm.framesIndex == MaxFrames {
+			return object.NewError(object.LimitError, "stack overflow")
+		}
+		base := vm.sp - numArgs
+		if err := vm.grow(base + fn.Fn.NumLocals); err != nil {
+			return err
+		}
+		vm.pushFrame(NewFrame(fn, base))
+		// clear the locals that are not arguments; the slots may hold values
+		// from an earlier call
+		for i := vm.sp; i < base+fn.Fn.NumLocals; i++ {
+			vm.stack[i] = nil
+		}
+		vm.sp = base + fn.Fn.NumLocals
+		return nil
+	case *object.Builtin:
+		args := make([]object.Object, numArgs)
+		copy(args, vm.stack[vm.sp-numArgs:vm.sp])
+		vm.sp -= numArgs + 1
+		return vm.pushResult(fn.Fn(env, args...))
+	default:
+		return object.NewError(object.TypeError, "evalCallExpression: unknown type %T", callee)
+	}
+}
+
+func (vm *VM) currentFrame() *Frame { return vm.frames[vm.framesIndex-1] }
+
+func (vm *VM) pushFrame(f *Frame) {
+	if vm.framesIndex == len(vm.frames) {
+		vm.frames = append(vm.frames, f)
+	} else {
+		vm.frames[vm.framesIndex] = f
+	}
+	vm.framesIndex++
+}
+
+func (vm *VM) popFrame() *Frame {
+	vm.framesIndex--
+	return vm.frames[vm.framesIndex]
+}
+
+func (vm *VM) push(obj object.Object) error {
+	if err := vm.grow(vm.sp + 1); err != nil {
+		return err
+	}
+	vm.stack[vm.sp] = obj
+	vm.sp++
+	return nil
+}
+
+// grow makes room on the stack for n values, doubling its size as often as
+// needed. A stack of more than StackSize values overflows.
+func (vm *VM) grow(n int) error {
+	if n <= len(vm.stack) {
+		return nil
+	}
+	if n > StackSize {
+		return object.NewError(object.LimitError, "stack overflow")
+	}
+	size := max(len(vm.stack), initialStackSize)
+	for size < n {
+		size *= 2
+	}
+	stack := make([]object.Object, min(size, StackSize))
+	copy(stack, vm.stack)
+	vm.stack = stack
+	return nil
+}
+
+// pushResult pushes the result of an operation, aborting the program if it
+// is an error.
+func (vm *VM) pushResult(obj object.Object) error {
+	if err, ok := obj.(*object.Error); ok {
+		return err
+	}
+	return vm.push(obj)
+}
+
+// pushDefined pushes the value of a variable. A nil value means the variable
+// is read before its let statement has run.
+func (vm *VM) pushDefined(obj object.Object) error {
+	if obj == nil {
+		return object.NewError(object.NameError, "variable used before it is defined")
+	}
+	return vm.push(obj)
+}
+
+func (vm *VM) pop() object.Object {
+	vm.sp--
+	return vm.stack[vm.sp]
+}
+
+func isTruthy(obj object.Object) bool {
+	switch obj {
+	case object.NULL, object.FALSE:
+		return false
+	default:
+		return true
+	}
+}
@@ -0,0 +1,193 @@
+package vm_test
+
+import (
+	"context"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/kvalv/monkey/compiler"
+	"github.com/kvalv/monkey/eval"
+	"github.com/kvalv/monkey/object"
+	"github.com/kvalv/monkey/parser"
+	"github.com/kvalv/monkey/vm"
+)
+
+// The inputs of eval_test.go, plus a few that exercise closures. The vm must
+// give the same result as the evaluator for every one of them.
+var sameAsEval = []string{
+	"2", "-3", "5 + 5 + 5 + 5 - 10", "2 * 2* 2 * 2 * 2", "50 / 2 * 2 + 10", "2 * (5 + 10)",
+	"true", "!false", "!!true", "1 > 2", "3 > 2", "true != false", "(1 < 2) == false", "(1 > 2) == true",
+	`"hello"`, `"he" + "llo"`, `"" + ""`, `"x" == "x"`, `"x" == "y"`,
+	`len("1234")`, `len("ab" + "cd")`, `len(2)`,
+	"if (3 > 2) { 4 } else { 5 }", "if (3 < 2) { 4 } else { 5 }", "if (false) { 1 }", "if (true) { 1 }",
+	"3; return 4; 5;", "3; return 4; return 5; 6", "3; 4; return 5;", `if true { if true { return 2; } return 1; }`,
+	"5 + true", "true > false", "if true { if true { return 2 + false } }", "1 / 0",
+	"let x = 5; x", "let a = 1; a + 1", "let a = b", "let a = 10; let b = a > 7; let c = if b { 99 } else { 98 }",
+	"fn(x, x) { x + x }",
+	"let plus = fn(x) { return x + 2 }; plus(2)", "fn(x) { return x + 2 }(2)", "fn(x) { x + 2 }(2)",
+	"let add = fn(x, y) { return x + y }; add(1, 2)", "fn(x, y) { x + y }(1, 2)",
+	"let apply = fn(f, in) { f(in) }; apply(fn(x) { x + 2 }, 2)", "let a = 1; fn(x) { x + a }(1)",
+	"fn(x) { x }(1, 2)", "fn(x, y) { x }(1)", "fn() { }()", "fn() { let a = 3 }()", "1(2)",
+	"[1, 2, 3][1]", "[1, 2, 3][true]", "[1, 2, 3][4]", "let index = 2; [1, 2, 3][index]",
+	"[1, 2, 3][-123]", "[2+2][0]", "rest([1, 2, 3])", "first([1, 2, 3])", "first([])",
+	"push([1, 2], 3, 4)", "push([1])", "push([])",
+	`{"foo": "bar"}["foo"]`, `{"foo": "bar"}["123"]`, `{true: true}[true]`, `{1: 2}[1]`, `{}[123]`,
+	`let x = "hi"; {x: "mom"}["hi"]`, `let h = {"a": 1}; h["a"] + h["a"]`, `let h = {}; h[2] = 2; h[2]`,
+	"let a = 1; a[0] = 2",
+	"const c = 3; c * 2", `let h = freeze({"a": {}}); h["a"] = 2`, `let h = freeze({"a": {}}); let g = h["a"]; g["b"] = 1`,
+	`let h = {}; h["a"] = 1; is_frozen(freeze(h))`,
+	"let double = (x) => x * 2; 3 |> double", "[1, 2] |> len", "let add = (a, b) => a + b; 1 |> add(2) |> add(3)",
+	"let adder = fn(a) { fn(b) { a + b } }; adder(2)(3)",
+	"let f = fn(a) { fn(b) { fn(c) { a + b + c } } }; f(1)(2)(3)",
+	"let fib = fn(n) { if n < 2 { n } else { fib(n - 1) + fib(n - 2) } }; fib(15)",
+	"let f = fn() { let g = fn(n) { if n == 0 { 0 } else { g(n - 1) } }; g(10) }; f()",
+	"let x = 1; let f = fn() { x }; let x = 2; f()",
+	"let a = 1; let a = a + 1; a",
+	"let r = f(3); fn f(n) { n * 2 }; r",
+	"let r = even(10); fn even(n) { if n == 0 { true } else { odd(n - 1) } }; fn odd(n) { if n == 0 { false } else { even(n - 1) } }; r",
+	"fn() { let x = fact(5); fn fact(n) { if n < 2 { 1 } else { n * fact(n - 1) } }; x }()",
+	"fn() { fn f() { g() }; fn g() { 1 }; f() }()",
+	"fn() { let r = even(7); fn even(n) { if n == 0 { true } else { odd(n - 1) } }; fn odd(n) { if n == 0 { false } else { even(n - 1) } }; r }()",
+	"fn(k) { fn f() { fn() { g() + k } }; fn g() { h(1) }; fn h(x) { x * 10 }; f()() }(2)",
+	"let mk = fn(n) { fn a() { b() }; fn b() { n }; a }; mk(1)() + mk(2)()",
+	"await(spawn(fn(x) { x }, 1))", "let n = 2; await(spawn(fn(x) { x * n }, 21))", "await(spawn(fn(x, y) { x }, 1))",
+	"await(spawn(fn() { 1 / 0 }))", "await(spawn(len, [1, 2]))", "spawn(1)",
+	`let h = {}; set_timeout(fn() { h["a"] = 1 }, 0); h`, "set_timeout(fn() { 1 / 0 }, 0)",
+	`let h = {}; then(promise(fn(resolve, reject) { resolve(2) }), fn(v) { h["v"] = v * 2 }); h`,
+	"collect(map_iter(range(3), fn(x) { x * 2 }))", "collect(map_iter([1], fn() { 1 }))",
+}
+
+func TestSameAsEval(t *testing.T) {
+	for _, input := range sameAsEval {
+		t.Run(input, func(t *testing.T) {
+			prog, errs := parser.New(input).Parse()
+			if len(errs) > 0 {
+				t.Fatalf("failed to parse: %v", errs)
+			}
+			ctx, runLoop := eval.WithEventLoop(context.Background(), nil)
+			env := object.NewEnvironment().WithContext(ctx)
+			expected := eval.Eval(prog, env)
+			if !object.IsError(expected) {
+				if err := runLoop(env); err != nil {
+					expected = object.Errorf("%s", err)
+				}
+			}
+			got := run(t, input)
+			if expected.Type() != got.Type() || expected.String() != got.String() {
+				t.Fatalf("eval gave %s %q, vm gave %s %q", expected.Type(), expected, got.Type(), got)
+			}
+		})
+	}
+}
+
+func TestDeepRecursion(t *testing.T) {
+	got := run(t, "let count = fn(n) { if n == 0 { 0 } else { 1 + count(n - 1) } }; count(5000)")
+	if got.String() != "5000" {
+		t.Fatalf("expected 5000, got %q", got)
+	}
+	got = run(t, "let f = fn() { f() }; f()")
+	if got.String() != "error: stack overflow" {
+		t.Fatalf("expected a stack overflow, got %q", got)
+	}
+	// callbacks grow their stacks the same way
+	got = run(t, "let count = fn(n) { if n == 0 { 0 } else { 1 + count(n - 1) } }; collect(map_iter([5000], count))")
+	if got.String() != "[5000]" {
+		t.Fatalf("expected [5000], got %q", got)
+	}
+	got = run(t, "let f = fn() { f() }; collect(map_iter([1], fn(x) { f() }))")
+	if got.String() != "error: stack overflow" {
+		t.Fatalf("expected a stack overflow, got %q", got)
+	}
+}
+
+func TestCallbackAllocations(t *testing.T) {
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	got := run(t, "len(collect(map_iter(range(1000), fn(x) { x * 2 })))")
+	runtime.ReadMemStats(&after)
+	if got.String() != "1000" {
+		t.Fatalf("expected 1000, got %q", got)
+	}
+	// a stack of StackSize values for each element would take about 1 GiB
+	if n := after.TotalAlloc - before.TotalAlloc; n > 64<<20 {
+		t.Fatalf("expected callbacks to reuse their stacks, but %d MiB were allocated", n>>20)
+	}
+}
+
+func TestCancellation(t *testing.T) {
+	prog, _ := parser.New("let loop = fn(n) { loop(n + 1) }; loop(0)").Parse()
+	c := compiler.New()
+	if err := c.Compile(prog); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err := vm.New(c.Bytecode()).Run(ctx)
+	if err == nil {
+		t.Fatalf("expected the program to be cancelled")
+	}
+}
+
+func TestGlobalsPersist(t *testing.T) {
+	symbols := compiler.New().SymbolTable()
+	globals := make([]object.Object, vm.GlobalsSize)
+	var constants []object.Object
+	var got object.Object
+	for _, line := range []string{"let a = 40", "let f = fn(x) { a + x }", "f(2)"} {
+		prog, _ := parser.New(line).Parse()
+		c := compiler.NewWithState(symbols, constants)
+		if err := c.Compile(prog); err != nil {
+			t.Fatal(err)
+		}
+		bc := c.Bytecode()
+		constants = bc.Constants
+		machine := vm.NewWithGlobals(bc, globals)
+		if err := machine.Run(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		got = machine.Result()
+	}
+	if got.String() != "42" {
+		t.Fatalf("expected 42, got %q", got)
+	}
+}
+
+func TestConstPersists(t *testing.T) {
+	symbols := compiler.New().SymbolTable()
+	var constants []object.Object
+	for _, line := range []string{"const a = 1", "fn f() { let a = 2; a }"} {
+		prog, _ := parser.New(line).Parse()
+		c := compiler.NewWithState(symbols, constants)
+		if err := c.Compile(prog); err != nil {
+			t.Fatal(err)
+		}
+		constants = c.Bytecode().Constants
+	}
+	for _, line := range []string{"let a = 2", "fn a() { 2 }"} {
+		prog, _ := parser.New(line).Parse()
+		err := compiler.NewWithState(symbols, constants).Compile(prog)
+		if err == nil || err.Error() != "compiler: cannot bind 'a': it is a constant" {
+			t.Fatalf("%s: expected a constant error, got %v", line, err)
+		}
+	}
+}
+
+// run compiles and runs input; compile and runtime errors are returned as
+// *object.Error so that they can be compared with the evaluator.
+func run(t *testing.T, input string) object.Object {
+	t.Helper()
+	prog, errs := parser.New(input).Parse()
+	if len(errs) > 0 {
+		t.Fatalf("failed to parse: %v", errs)
+	}
+	c := compiler.New()
+	if err := c.Compile(prog); err != nil {
+		return object.Errorf("%s", err)
+	}
+	machine := vm.New(c.Bytecode())
+	if err := machine.Run(context.Background()); err != nil {
+		return object.Errorf("%s", err)
+	}
+	return machine.Result()
+}